@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"summerWebCrawler/logging"
 	"fmt"
+	"io/ioutil"
+	"bytes"
 )
 
 //分析器的接口类型
@@ -21,7 +23,8 @@ type Analyzer interface {
 }
 
 //被用于解析http响应的函数类型
-type ParseResponse func(httpResp *http.Response, respDepth uint32) ([]base.Data, []error)
+//参数respMeta代表响应的元数据,其中包含了产生该响应的请求的元数据.解析函数不应该修改它
+type ParseResponse func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error)
 
 //分析器的实现类型
 type myAnalyzer struct {
@@ -49,10 +52,19 @@ func NewAnalyzer() Analyzer {
 }
 
 //添加请求值或条目值到列表
-func appendDataList(dataList []base.Data, data base.Data, respDepth uint32) []base.Data {
+//参数reqUrl代表被解析的响应对应的url,参数parserIndex代表解析函数的序号.它们会被记录到新请求的元数据中,
+//reqUrl还会作为来源url被记录到条目中
+func appendDataList(dataList []base.Data, data base.Data, respDepth uint32, reqUrl *url.URL, parserIndex int) []base.Data {
 	if data == nil {
 		return dataList
 	}
+	//条目需要记录来源url
+	if item, ok := data.(*base.Item); ok {
+		if _, exists := (*item)[base.ITEM_SOURCE_URL_KEY]; !exists && *item != nil && reqUrl != nil {
+			(*item)[base.ITEM_SOURCE_URL_KEY] = reqUrl.String()
+		}
+		return append(dataList, data)
+	}
 	//断言当前data是否是(*base.Request).
 	//*base.Request实现了 Vaildate() 方法
 	req, ok := data.(*base.Request)
@@ -63,8 +75,15 @@ func appendDataList(dataList []base.Data, data base.Data, respDepth uint32) []ba
 	newDepth := respDepth + 1
 	//新的请求
 	if req.Depth() != newDepth {
-		//创建新的请求
-		req = base.NewRequest(req.HttpReq(), newDepth)
+		//创建新的请求,保留解析函数设置的元数据
+		req = base.NewRequestWithMeta(req.HttpReq(), newDepth, req.Meta())
+	}
+	//引用页总是被解析的响应的url,即使解析函数传入的元数据中带有其他的值
+	if reqUrl != nil {
+		req.SetMeta(base.META_REFERER, reqUrl.String())
+	}
+	if _, ok := req.Meta()[base.META_PARSER_INDEX]; !ok {
+		req.SetMeta(base.META_PARSER_INDEX, parserIndex)
 	}
 	return append(dataList, req)
 
@@ -99,6 +118,21 @@ func (analyzer *myAnalyzer) Analyze(respParsers []ParseResponse, resp base.Respo
 	logger.Infof("Parse the response (reqUrl=%s)...\n", reqUrl)
 	//获取爬取深度
 	respDepth := resp.Depth()
+	//读取响应体并转换为UTF-8编码
+	//响应体只能被读取一次,所以这里把它缓存下来,供每个解析函数使用
+	body, err := readBody(httpResp)
+	if err != nil {
+		return nil, []error{err}
+	}
+	body, originalCharset, err := transcode(body, httpResp.Header.Get("Content-Type"))
+	if err != nil {
+		logger.Warnf("Transcode the response failing! (reqUrl=%s, charset=%s): %s\n", reqUrl, originalCharset, err)
+	} else if originalCharset != "" {
+		resetCharset(httpResp.Header)
+	}
+	resp.SetMeta(base.META_ORIGINAL_CHARSET, originalCharset)
+	//没有被路由到的解析函数的数量
+	skipped := 0
 	//respParsers是一个slice[],里面放的是解析函数
 	for i, respParser := range respParsers {
 		if respParser == nil {
@@ -106,23 +140,41 @@ func (analyzer *myAnalyzer) Analyze(respParsers []ParseResponse, resp base.Respo
 			errorList = append(errorList, err)
 			continue
 		}
+		httpResp.Body = ioutil.NopCloser(bytes.NewReader(body))
 
 		//通过解析函数解析出想要的数据
-		pDataList, pErrorList := respParser(httpResp, respDepth)
+		pDataList, pErrorList := respParser(httpResp, respDepth, resp.Meta())
 
 		if pDataList != nil {
 			//把解析的数据加入到dataList列表
 			for _, pData := range pDataList {
 				//appendDataList()会根据数据类型进行创建新的请求或者直接加入到DataList列表
-				dataList = appendDataList(dataList, pData, respDepth)
+				dataList = appendDataList(dataList, pData, respDepth, reqUrl, i)
 			}
 		}
 
 		if pErrorList != nil {
 			for _, pError := range pErrorList {
+				if pError == errNotRouted {
+					skipped++
+					continue
+				}
 				errorList = appendErrorList(errorList, pError)
 			}
 		}
 	}
+	//响应没有被路由到任何一个解析函数
+	if skipped > 0 && skipped == len(respParsers) {
+		errorList = append(errorList, &SkipError{url: reqUrl.String(), contentType: httpResp.Header.Get("Content-Type")})
+	}
 	return dataList, errorList
 }
+
+//读取并关闭响应体
+func readBody(httpResp *http.Response) ([]byte, error) {
+	if httpResp.Body == nil {
+		return nil, nil
+	}
+	defer httpResp.Body.Close()
+	return ioutil.ReadAll(httpResp.Body)
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"summerWebCrawler/base"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	//无法确定字符集并且内容不是UTF-8时使用的字符集
+	//爬取的大多是中文网站,所以默认使用GB18030(兼容GBK和GB2312)
+	FallbackCharset = "gb18030"
+	//可以包含文本的媒体类型的关键字
+	textualMediaTypeKeys = []string{"text/", "html", "xml", "json", "javascript"}
+	//网页中声明字符集的meta标签,包括<meta charset>和<meta http-equiv content>两种形式
+	metaCharsetRegexp = regexp.MustCompile(`(?i)<meta\s[^>]*?charset\s*=\s*["']?\s*([a-z0-9_:.+-]+)`)
+)
+
+//只在响应体的前面这么多字节中查找meta标签
+const metaPrescanSize = 4096
+
+//判断响应体是否是文本内容.没有Content-Type的响应也当作文本处理
+func isTextual(contentType string) bool {
+	mediaType := base.MediaType(contentType)
+	if mediaType == "" {
+		return true
+	}
+	for _, key := range textualMediaTypeKeys {
+		if strings.Contains(mediaType, key) {
+			return true
+		}
+	}
+	return false
+}
+
+//把响应体转换为UTF-8编码
+//字符集依次根据BOM,Content-Type响应头和网页中的meta标签确定.
+//都没有声明时,整个响应体是合法的UTF-8就当作UTF-8,否则使用FallbackCharset
+//返回值分别为转换后的内容和原始字符集的名称
+func transcode(content []byte, contentType string) ([]byte, string, error) {
+	if !isTextual(contentType) {
+		return content, "", nil
+	}
+	//只有BOM和Content-Type响应头能让DetermineEncoding确定字符集,
+	//它在其他情况下返回的windows-1252无法与网页中声明的iso-8859-1区分,所以meta标签由我们自己解析
+	encoding, name, certain := charset.DetermineEncoding(content, contentType)
+	if !certain {
+		encoding, name = nil, ""
+		if label := metaCharset(content); label != "" {
+			encoding, name = charset.Lookup(label)
+		}
+		if encoding == nil {
+			if utf8.Valid(content) {
+				return content, "utf-8", nil
+			}
+			encoding, name = charset.Lookup(FallbackCharset)
+			if encoding == nil {
+				return content, "", nil
+			}
+		}
+	}
+	if name == "utf-8" {
+		return content, name, nil
+	}
+	utf8Content, err := encoding.NewDecoder().Bytes(content)
+	if err != nil {
+		return content, name, err
+	}
+	return utf8Content, name, nil
+}
+
+//获取网页中的meta标签声明的字符集,没有声明时返回空字符串
+func metaCharset(content []byte) string {
+	if len(content) > metaPrescanSize {
+		content = content[:metaPrescanSize]
+	}
+	matches := metaCharsetRegexp.FindSubmatch(content)
+	if matches == nil {
+		return ""
+	}
+	return string(matches[1])
+}
+
+//把Content-Type响应头中的字符集改为utf-8,以便解析函数做出正确的判断
+func resetCharset(header http.Header) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return
+	}
+	params["charset"] = "utf-8"
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+}
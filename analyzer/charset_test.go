@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//没有声明字符集并且前1KB都是ASCII的UTF-8网页不应被当作GB18030
+func TestTranscodeUndeclaredUTF8(t *testing.T) {
+	content := []byte("<html><head><title>test</title></head><body>" +
+		strings.Repeat("a", 2048) + "中文内容</body></html>")
+	result, name, err := transcode(content, "text/html")
+	if err != nil {
+		t.Fatalf("An error occurs when transcoding: %s", err)
+	}
+	if name != "utf-8" {
+		t.Errorf("The charset is %q, but expected %q", name, "utf-8")
+	}
+	if !bytes.Equal(result, content) {
+		t.Errorf("The content is changed: %q", result)
+	}
+}
+
+//网页中声明的iso-8859-1不应被FallbackCharset替换
+func TestTranscodeDeclaredLatin1(t *testing.T) {
+	content := []byte("<html><head><meta charset=\"iso-8859-1\"></head><body>caf\xe9</body></html>")
+	result, name, err := transcode(content, "text/html")
+	if err != nil {
+		t.Fatalf("An error occurs when transcoding: %s", err)
+	}
+	if name != "windows-1252" {
+		t.Errorf("The charset is %q, but expected %q", name, "windows-1252")
+	}
+	if !bytes.Contains(result, []byte("café")) {
+		t.Errorf("The content is not decoded as latin-1: %q", result)
+	}
+}
+
+//http-equiv形式的meta标签
+func TestTranscodeDeclaredHttpEquiv(t *testing.T) {
+	content := []byte("<html><head><meta http-equiv=\"Content-Type\" content=\"text/html; charset=gbk\"></head>" +
+		"<body>\xd6\xd0\xce\xc4</body></html>")
+	result, name, err := transcode(content, "text/html")
+	if err != nil {
+		t.Fatalf("An error occurs when transcoding: %s", err)
+	}
+	if name != "gbk" {
+		t.Errorf("The charset is %q, but expected %q", name, "gbk")
+	}
+	if !bytes.Contains(result, []byte("中文")) {
+		t.Errorf("The content is not decoded as gbk: %q", result)
+	}
+}
+
+//Content-Type响应头中声明的字符集优先于meta标签
+func TestTranscodeHeaderCharset(t *testing.T) {
+	content := []byte("<html><head><meta charset=\"gbk\"></head><body>中文</body></html>")
+	result, name, err := transcode(content, "text/html; charset=utf-8")
+	if err != nil {
+		t.Fatalf("An error occurs when transcoding: %s", err)
+	}
+	if name != "utf-8" {
+		t.Errorf("The charset is %q, but expected %q", name, "utf-8")
+	}
+	if !bytes.Equal(result, content) {
+		t.Errorf("The content is changed: %q", result)
+	}
+}
+
+//没有声明字符集并且不是合法的UTF-8时使用FallbackCharset
+func TestTranscodeFallback(t *testing.T) {
+	content := []byte("<html><body>\xd6\xd0\xce\xc4</body></html>")
+	result, name, err := transcode(content, "text/html")
+	if err != nil {
+		t.Fatalf("An error occurs when transcoding: %s", err)
+	}
+	if name != FallbackCharset {
+		t.Errorf("The charset is %q, but expected %q", name, FallbackCharset)
+	}
+	if !bytes.Contains(result, []byte("中文")) {
+		t.Errorf("The content is not decoded as %s: %q", FallbackCharset, result)
+	}
+}
@@ -0,0 +1,313 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+//链接的来源,即包含链接的元素和属性
+type LinkSource struct {
+	//元素名称
+	Element string
+	//属性名称
+	//srcset属性会被拆分为多个链接;meta元素的content属性只在http-equiv为refresh时有效
+	Attr string
+}
+
+func (source LinkSource) String() string {
+	return fmt.Sprintf("%s[%s]", source.Element, source.Attr)
+}
+
+//默认的链接来源,只包含导航性的链接
+var DefaultLinkSources = []LinkSource{
+	{"a", "href"},
+	{"area", "href"},
+	{"iframe", "src"},
+	{"frame", "src"},
+	{"form", "action"},
+	{"meta", "content"},
+}
+
+//资源类的链接来源,如图片和样式表.需要抓取这些资源时可以把它们追加到DefaultLinkSources之后
+var AssetLinkSources = []LinkSource{
+	{"link", "href"},
+	{"img", "srcset"},
+	{"source", "srcset"},
+}
+
+//链接提取器的参数
+type LinkExtractorArgs struct {
+	//链接的来源的列表
+	sources []LinkSource
+	//是否遵守nofollow
+	honourNofollow bool
+}
+
+var linkExtractorArgsTemplate = "{sources:%v, honourNofollow:%v}"
+
+//创建链接提取器的参数
+//参数honourNofollow为true时,rel中包含nofollow的链接会被忽略,
+//页面的robots元信息中包含nofollow时该页面中的所有链接都会被忽略
+func NewLinkExtractorArgs(sources []LinkSource, honourNofollow bool) LinkExtractorArgs {
+	return LinkExtractorArgs{sources: sources, honourNofollow: honourNofollow}
+}
+
+//获得链接的来源的列表
+func (args LinkExtractorArgs) Sources() []LinkSource {
+	return args.sources
+}
+
+//获得是否遵守nofollow
+func (args LinkExtractorArgs) HonourNofollow() bool {
+	return args.honourNofollow
+}
+
+func (args LinkExtractorArgs) Check() error {
+	if len(args.sources) == 0 {
+		return errors.New("The link source list can not be empty!")
+	}
+	for i, source := range args.sources {
+		if strings.TrimSpace(source.Element) == "" || strings.TrimSpace(source.Attr) == "" {
+			return errors.New(fmt.Sprintf("The %dth link source is invalid: %s", i, source))
+		}
+	}
+	return nil
+}
+
+func (args LinkExtractorArgs) String() string {
+	return fmt.Sprintf(linkExtractorArgsTemplate, args.sources, args.honourNofollow)
+}
+
+//被提取出的链接
+type extractedLink struct {
+	//链接的url
+	url *url.URL
+	//锚文本
+	text string
+	//rel属性的值
+	rel string
+	//来源
+	source LinkSource
+}
+
+//创建链接提取器
+//链接提取器会按照参数中的链接来源从HTML中提取链接,相对链接会按照<base href>(如果有)或响应的url被解析.
+//新请求的元数据中会带有锚文本(META_ANCHOR_TEXT)丶rel(META_LINK_REL)和链接来源(META_LINK_SOURCE)
+func NewLinkExtractor(args LinkExtractorArgs) ParseResponse {
+	if err := args.Check(); err != nil {
+		panic(errors.New(fmt.Sprintf("Invalid link extractor args: %s", err)))
+	}
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		if httpResp.StatusCode != 200 {
+			err := errors.New(fmt.Sprintf("unsupported status code %d. (requestUrl=%s)",
+				httpResp.StatusCode, httpResp.Request.URL))
+			return nil, []error{err}
+		}
+		defer httpResp.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(httpResp.Body)
+		if err != nil {
+			return nil, []error{err}
+		}
+		links, errs := extractLinks(doc, httpResp.Request.URL, args)
+		dataList := make([]base.Data, 0, len(links))
+		for _, link := range links {
+			httpReq, err := http.NewRequest("GET", link.url.String(), nil)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			meta := base.Meta{base.META_LINK_SOURCE: link.source.String()}
+			if link.text != "" {
+				meta[base.META_ANCHOR_TEXT] = link.text
+			}
+			if link.rel != "" {
+				meta[base.META_LINK_REL] = link.rel
+			}
+			dataList = append(dataList, base.NewRequestWithMeta(httpReq, respDepth, meta))
+		}
+		return dataList, errs
+	}
+}
+
+//从文档中提取链接,结果中的链接都是去掉了片段的绝对url并且不会重复
+func extractLinks(doc *goquery.Document, pageUrl *url.URL, args LinkExtractorArgs) ([]extractedLink, []error) {
+	errs := make([]error, 0)
+	if args.honourNofollow && hasRobotsNofollow(doc) {
+		return nil, errs
+	}
+	baseUrl := pageUrl
+	if href, exists := doc.Find("base[href]").First().Attr("href"); exists {
+		if parsed, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			baseUrl = pageUrl.ResolveReference(parsed)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	links := make([]extractedLink, 0)
+	seen := make(map[string]bool)
+	for _, source := range args.sources {
+		doc.Find(source.Element).Each(func(index int, selection *goquery.Selection) {
+			value, exists := selection.Attr(source.Attr)
+			if !exists {
+				return
+			}
+			rel := strings.TrimSpace(selection.AttrOr("rel", ""))
+			if args.honourNofollow && containsToken(rel, "nofollow") {
+				return
+			}
+			for _, raw := range linkValues(selection, source, value) {
+				linkUrl, err := resolveLink(baseUrl, raw)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if linkUrl == nil || seen[linkUrl.String()] {
+					continue
+				}
+				seen[linkUrl.String()] = true
+				links = append(links, extractedLink{
+					url:    linkUrl,
+					text:   anchorText(selection),
+					rel:    rel,
+					source: source,
+				})
+			}
+		})
+	}
+	return links, errs
+}
+
+//从属性值中取出原始的链接
+func linkValues(selection *goquery.Selection, source LinkSource, value string) []string {
+	switch {
+	case strings.EqualFold(source.Attr, "srcset"):
+		return parseSrcset(value)
+	case strings.EqualFold(source.Element, "meta"):
+		if !strings.EqualFold(selection.AttrOr("http-equiv", ""), "refresh") {
+			return nil
+		}
+		//meta refresh的格式为"秒数; url=地址"
+		index := strings.Index(strings.ToLower(value), "url=")
+		if index < 0 {
+			return nil
+		}
+		return []string{strings.Trim(strings.TrimSpace(value[index+4:]), `'"`)}
+	case strings.EqualFold(source.Element, "form"):
+		//只有GET表单才能被当作普通的链接
+		method := strings.TrimSpace(selection.AttrOr("method", "get"))
+		if !strings.EqualFold(method, "get") {
+			return nil
+		}
+	}
+	return []string{value}
+}
+
+//解析链接,不支持的链接(如javascript:和mailto:)会返回nil
+func resolveLink(baseUrl *url.URL, raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "#") {
+		return nil, nil
+	}
+	linkUrl, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !linkUrl.IsAbs() {
+		linkUrl = baseUrl.ResolveReference(linkUrl)
+	}
+	if linkUrl.Scheme != "http" && linkUrl.Scheme != "https" {
+		return nil, nil
+	}
+	linkUrl.Fragment = ""
+	return linkUrl, nil
+}
+
+//获得链接的锚文本,area和img元素使用alt属性
+func anchorText(selection *goquery.Selection) string {
+	text := strings.TrimSpace(selection.Text())
+	if text == "" {
+		text = strings.TrimSpace(selection.AttrOr("alt", ""))
+	}
+	if text == "" {
+		text = strings.TrimSpace(selection.AttrOr("title", ""))
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+//判断页面的robots元信息中是否包含nofollow
+func hasRobotsNofollow(doc *goquery.Document) bool {
+	nofollow := false
+	doc.Find("meta[name]").EachWithBreak(func(index int, selection *goquery.Selection) bool {
+		if !strings.EqualFold(selection.AttrOr("name", ""), "robots") {
+			return true
+		}
+		content := strings.Replace(selection.AttrOr("content", ""), ",", " ", -1)
+		if containsToken(content, "nofollow") || containsToken(content, "none") {
+			nofollow = true
+			return false
+		}
+		return true
+	})
+	return nofollow
+}
+
+//判断以空白分隔的值中是否包含指定的记号
+func containsToken(value string, token string) bool {
+	for _, field := range strings.Fields(value) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
+
+//解析srcset属性,返回其中的url
+//srcset的格式为"url 描述符, url 描述符".按照HTML标准,url是一段不含空白的字符,末尾的逗号不属于url,
+//因此url中的逗号(如data:url)不会被当作分隔符;描述符直到括号之外的下一个逗号为止
+func parseSrcset(value string) []string {
+	values := make([]string, 0)
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+	pos := 0
+	for pos < len(value) {
+		//跳过空白和逗号
+		for pos < len(value) && (isSpace(value[pos]) || value[pos] == ',') {
+			pos++
+		}
+		start := pos
+		for pos < len(value) && !isSpace(value[pos]) {
+			pos++
+		}
+		candidate := value[start:pos]
+		if candidate == "" {
+			break
+		}
+		//以逗号结尾的url没有描述符
+		if trimmed := strings.TrimRight(candidate, ","); len(trimmed) < len(candidate) {
+			if trimmed != "" {
+				values = append(values, trimmed)
+			}
+			continue
+		}
+		values = append(values, candidate)
+		//跳过描述符
+		depth := 0
+		for ; pos < len(value); pos++ {
+			if c := value[pos]; c == '(' {
+				depth++
+			} else if c == ')' && depth > 0 {
+				depth--
+			} else if c == ',' && depth == 0 {
+				break
+			}
+		}
+	}
+	return values
+}
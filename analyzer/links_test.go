@@ -0,0 +1,29 @@
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSrcset(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"width descriptors", "a.jpg 480w, b.jpg 800w", []string{"a.jpg", "b.jpg"}},
+		{"no descriptors", "a.jpg, b.jpg", []string{"a.jpg", "b.jpg"}},
+		{"no space after comma", "a.jpg 1x,b.jpg 2x", []string{"a.jpg", "b.jpg"}},
+		{"data url", "data:image/png;base64,iVBORw0KGgo= 1x, b.jpg 2x",
+			[]string{"data:image/png;base64,iVBORw0KGgo=", "b.jpg"}},
+		{"comma in url", "/img/a,b.jpg 1x, /img/c,d.jpg 2x", []string{"/img/a,b.jpg", "/img/c,d.jpg"}},
+		{"trailing commas", " a.jpg,, b.jpg,", []string{"a.jpg", "b.jpg"}},
+		{"comma in parentheses", "a.jpg foo(1,2), b.jpg", []string{"a.jpg", "b.jpg"}},
+		{"empty", " , ", []string{}},
+	}
+	for _, c := range cases {
+		if got := parseSrcset(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: parseSrcset(%q) = %q, want %q", c.name, c.value, got, c.want)
+		}
+	}
+}
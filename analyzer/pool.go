@@ -1,22 +1,36 @@
 package analyzer
 
 import (
+	"context"
 	"summerWebCrawler/middleware"
 	"reflect"
 	"fmt"
 	"errors"
+	"time"
 )
 
 //分析池的接口类型
 type AnalyzerPool interface {
 	//从池中取出一个分析器
 	Take() (Analyzer, error)
+	//从池中取出一个分析器,超过参数timeout仍没有空闲的分析器时返回错误
+	TakeWithTimeout(timeout time.Duration) (Analyzer, error)
+	//从池中取出一个分析器,参数ctx被取消时返回它的错误
+	TakeContext(ctx context.Context) (Analyzer, error)
 	//把一个分析器归还给池
 	Return(analyzer Analyzer) error
 	//获得池的总容量
 	Total() uint32
 	//获得正在使用的分析器的数量
 	Used() uint32
+	//调整池的总容量,缩小时正在被使用的分析器会在被归还时淘汰
+	Resize(total uint32) error
+	//设置分析器的健康检查函数,归还时检查失败的分析器会被替换为新生成的
+	SetHealthCheck(check func(Analyzer) bool)
+	//获得被取出的时间超过参数threshold且尚未归还的分析器
+	Leaks(threshold time.Duration) []middleware.Leak
+	//获得因健康检查失败而被替换的分析器的数量
+	Replaced() uint32
 }
 
 //分析器的实现
@@ -51,7 +65,19 @@ func NewAnalyzerPool(total uint32, gen GenAnalyzer) (AnalyzerPool, error) {
 
 func (maPool *myAnalyzerPool) Take() (Analyzer, error) {
 	//从池中取出一个资源
-	entity, err := maPool.pool.Take()
+	return maPool.convert(maPool.pool.Take())
+}
+
+func (maPool *myAnalyzerPool) TakeWithTimeout(timeout time.Duration) (Analyzer, error) {
+	return maPool.convert(maPool.pool.TakeWithTimeout(timeout))
+}
+
+func (maPool *myAnalyzerPool) TakeContext(ctx context.Context) (Analyzer, error) {
+	return maPool.convert(maPool.pool.TakeContext(ctx))
+}
+
+//把取出的实体转换为分析器
+func (maPool *myAnalyzerPool) convert(entity middleware.Entity, err error) (Analyzer, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -80,3 +106,29 @@ func (maPool *myAnalyzerPool) Used() uint32 {
 	//实体池中已经被使用的实体的数量
 	return maPool.pool.Used()
 }
+
+func (maPool *myAnalyzerPool) Resize(total uint32) error {
+	//调整实体池的总容量
+	return maPool.pool.Resize(total)
+}
+
+func (maPool *myAnalyzerPool) SetHealthCheck(check func(Analyzer) bool) {
+	if check == nil {
+		maPool.pool.SetHealthCheck(nil)
+		return
+	}
+	maPool.pool.SetHealthCheck(func(entity middleware.Entity) bool {
+		ma, ok := entity.(Analyzer)
+		return ok && check(ma)
+	})
+}
+
+func (maPool *myAnalyzerPool) Leaks(threshold time.Duration) []middleware.Leak {
+	//实体池中被取出过久的实体
+	return maPool.pool.Leaks(threshold)
+}
+
+func (maPool *myAnalyzerPool) Replaced() uint32 {
+	//因健康检查失败而被替换的实体的数量
+	return maPool.pool.Replaced()
+}
@@ -0,0 +1,487 @@
+package analyzer
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"summerWebCrawler/base"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+//正文条目的类型名称和其中的键
+const (
+	ARTICLE_ITEM_TYPE = "article"
+	ARTICLE_TITLE     = "title"
+	ARTICLE_BYLINE    = "byline"
+	ARTICLE_PUBLISHED = "published"
+	ARTICLE_LANG      = "lang"
+	ARTICLE_EXCERPT   = "excerpt"
+	ARTICLE_TEXT      = "text"
+	ARTICLE_MARKDOWN  = "markdown"
+)
+
+var (
+	//多半不是正文的元素的class和id
+	unlikelyCandidates = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|` +
+		`header|legends|menu|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|` +
+		`ad-break|agegate|pagination|pager|popup|share|subscribe|^ads?$|^ads?[-_]|[-_]ads?$|navbar|^nav`)
+	//很可能是正文的元素的class和id
+	maybeCandidate = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow|entry|post|story|text`)
+	//加分的class和id
+	positiveWeight = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|text|blog|story`)
+	//减分的class和id
+	negativeWeight = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|foot|` +
+		`footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|` +
+		`skyscraper|sponsor|shopping|tags|tool|widget|nav|menu`)
+	//标题中分隔网站名称的符号
+	titleSeparator = regexp.MustCompile(`\s+[|\-–—_»]\s+`)
+	//总是会被去掉的元素
+	boilerplateSelector = "script, style, noscript, template, iframe, object, embed, svg, canvas, " +
+		"form, button, input, select, textarea, nav, footer, aside, header nav"
+	//摘要的最大长度(字符数)
+	excerptLength = 200
+)
+
+//创建正文提取器
+//正文提取器会去掉导航丶广告和页脚等模板内容,找出正文所在的块,并把标题丶作者丶发布日期丶语言
+//以及纯文本和Markdown形式的正文作为一个条目返回.正文少于minTextLength个字符的网页不会产生条目
+func NewContentExtractor(minTextLength int) ParseResponse {
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		defer httpResp.Body.Close()
+		//只解析成功的响应
+		if httpResp.StatusCode != 200 {
+			return nil, nil
+		}
+		doc, err := goquery.NewDocumentFromReader(httpResp.Body)
+		if err != nil {
+			return nil, []error{err}
+		}
+		item, err := extractArticle(doc, httpResp.Request.URL)
+		if err != nil {
+			return nil, []error{err}
+		}
+		if utf8.RuneCountInString(item[ARTICLE_TEXT].(string)) < minTextLength {
+			return nil, nil
+		}
+		return []base.Data{&item}, nil
+	}
+}
+
+//提取正文和元信息
+func extractArticle(doc *goquery.Document, pageUrl *url.URL) (base.Item, error) {
+	//元信息要在去掉模板内容之前提取
+	item := base.Item{
+		ARTICLE_TITLE:     articleTitle(doc),
+		ARTICLE_BYLINE:    articleByline(doc),
+		ARTICLE_PUBLISHED: articlePublished(doc),
+		ARTICLE_LANG:      articleLang(doc),
+	}
+	item.SetType(ARTICLE_ITEM_TYPE)
+	removeBoilerplate(doc)
+	content := findMainContent(doc)
+	if content == nil {
+		return nil, errors.New("No content block found!")
+	}
+	text := renderText(content, pageUrl, false)
+	item[ARTICLE_TEXT] = text
+	item[ARTICLE_MARKDOWN] = renderText(content, pageUrl, true)
+	item[ARTICLE_EXCERPT] = excerpt(text)
+	return item, nil
+}
+
+//获得标题,依次尝试og:title丶唯一的h1和去掉网站名称的title
+func articleTitle(doc *goquery.Document) string {
+	if title := metaContent(doc, `meta[property="og:title"]`, `meta[name="twitter:title"]`); title != "" {
+		return title
+	}
+	if h1 := doc.Find("h1"); h1.Length() == 1 {
+		if title := normalizeText(h1.Text()); title != "" {
+			return title
+		}
+	}
+	title := normalizeText(doc.Find("title").First().Text())
+	if locs := titleSeparator.FindAllStringIndex(title, -1); len(locs) > 0 {
+		//去掉最后一个分隔符之后的网站名称
+		if trimmed := strings.TrimSpace(title[:locs[len(locs)-1][0]]); len(strings.Fields(trimmed)) >= 2 {
+			return trimmed
+		}
+	}
+	return title
+}
+
+//获得作者
+func articleByline(doc *goquery.Document) string {
+	if byline := metaContent(doc, `meta[name="author"]`, `meta[property="article:author"]`); byline != "" {
+		return byline
+	}
+	for _, selector := range []string{`[itemprop~="author"]`, `[rel="author"]`, `.byline`, `.author`} {
+		if byline := normalizeText(doc.Find(selector).First().Text()); byline != "" {
+			return byline
+		}
+	}
+	return ""
+}
+
+//获得发布日期
+func articlePublished(doc *goquery.Document) string {
+	if published := metaContent(doc,
+		`meta[property="article:published_time"]`,
+		`meta[itemprop="datePublished"]`,
+		`meta[name="date"]`,
+		`meta[name="pubdate"]`,
+		`meta[name="publishdate"]`,
+		`meta[name="DC.date.issued"]`); published != "" {
+		return published
+	}
+	for _, selector := range []string{`[itemprop="datePublished"]`, `time[pubdate]`, `time[datetime]`} {
+		selection := doc.Find(selector).First()
+		if datetime := strings.TrimSpace(selection.AttrOr("datetime", "")); datetime != "" {
+			return datetime
+		}
+		if text := normalizeText(selection.Text()); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+//获得语言
+func articleLang(doc *goquery.Document) string {
+	if lang := strings.TrimSpace(doc.Find("html").AttrOr("lang", "")); lang != "" {
+		return lang
+	}
+	return metaContent(doc, `meta[http-equiv="content-language"]`, `meta[property="og:locale"]`)
+}
+
+//获得第一个非空的meta标签的content
+func metaContent(doc *goquery.Document, selectors ...string) string {
+	for _, selector := range selectors {
+		if content := normalizeText(doc.Find(selector).First().AttrOr("content", "")); content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+//去掉模板内容
+func removeBoilerplate(doc *goquery.Document) {
+	doc.Find(boilerplateSelector).Remove()
+	doc.Find("*").Each(func(index int, selection *goquery.Selection) {
+		switch goquery.NodeName(selection) {
+		case "html", "body", "article", "main":
+			return
+		}
+		match := selection.AttrOr("class", "") + " " + selection.AttrOr("id", "")
+		if unlikelyCandidates.MatchString(match) && !maybeCandidate.MatchString(match) {
+			selection.Remove()
+		}
+	})
+}
+
+//找出正文所在的元素
+//每个段落会按照长度和逗号的数量给父元素和祖父元素加分,得分乘以(1-链接密度)最高的元素即为正文
+func findMainContent(doc *goquery.Document) *goquery.Selection {
+	scores := make(map[*html.Node]float64)
+	candidates := make([]*goquery.Selection, 0)
+	addCandidate := func(selection *goquery.Selection) {
+		node := selection.Get(0)
+		if _, exists := scores[node]; !exists {
+			scores[node] = initialScore(selection)
+			candidates = append(candidates, selection)
+		}
+	}
+	doc.Find("p, pre, td, blockquote, li").Each(func(index int, selection *goquery.Selection) {
+		text := normalizeText(selection.Text())
+		length := utf8.RuneCountInString(text)
+		if length < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，"))
+		if bonus := float64(length) / 100; bonus < 3 {
+			score += bonus
+		} else {
+			score += 3
+		}
+		parent := selection.Parent()
+		if parent.Length() == 0 {
+			return
+		}
+		addCandidate(parent)
+		scores[parent.Get(0)] += score
+		if grandparent := parent.Parent(); grandparent.Length() > 0 {
+			addCandidate(grandparent)
+			scores[grandparent.Get(0)] += score / 2
+		}
+	})
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, candidate := range candidates {
+		score := scores[candidate.Get(0)] * (1 - linkDensity(candidate))
+		if best == nil || score > bestScore {
+			best = candidate
+			bestScore = score
+		}
+	}
+	if best == nil {
+		//没有足够长的段落时退回到article丶main或body
+		for _, selector := range []string{"article", "main", "body"} {
+			if selection := doc.Find(selector).First(); selection.Length() > 0 {
+				return selection
+			}
+		}
+		return nil
+	}
+	return best
+}
+
+//根据标签和class/id给出初始得分
+func initialScore(selection *goquery.Selection) float64 {
+	score := 0.0
+	switch goquery.NodeName(selection) {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	for _, attr := range []string{"class", "id"} {
+		value := selection.AttrOr(attr, "")
+		if value == "" {
+			continue
+		}
+		if negativeWeight.MatchString(value) {
+			score -= 25
+		}
+		if positiveWeight.MatchString(value) {
+			score += 25
+		}
+	}
+	return score
+}
+
+//计算链接密度,即链接文本占全部文本的比例
+func linkDensity(selection *goquery.Selection) float64 {
+	length := utf8.RuneCountInString(normalizeText(selection.Text()))
+	if length == 0 {
+		return 0
+	}
+	linkLength := 0
+	selection.Find("a").Each(func(index int, link *goquery.Selection) {
+		linkLength += utf8.RuneCountInString(normalizeText(link.Text()))
+	})
+	return float64(linkLength) / float64(length)
+}
+
+//生成摘要,使用第一个足够长的块(跳过小标题之类的短块)
+func excerpt(text string) string {
+	blocks := strings.Split(text, "\n\n")
+	chosen := blocks[0]
+	for _, block := range blocks {
+		if utf8.RuneCountInString(block) >= 25 {
+			chosen = block
+			break
+		}
+	}
+	runes := []rune(chosen)
+	if len(runes) <= excerptLength {
+		return chosen
+	}
+	return string(runes[:excerptLength]) + "…"
+}
+
+//把元素转换为纯文本或Markdown
+func renderText(selection *goquery.Selection, pageUrl *url.URL, markdown bool) string {
+	writer := &textWriter{pageUrl: pageUrl, markdown: markdown}
+	for _, node := range selection.Nodes {
+		writer.walk(node)
+	}
+	writer.flush()
+	return strings.Join(writer.blocks, "\n\n")
+}
+
+//文本的写入器,按块收集文本
+type textWriter struct {
+	//网页的url,用来解析相对链接
+	pageUrl *url.URL
+	//是否输出Markdown
+	markdown bool
+	//已完成的块
+	blocks []string
+	//当前块中的文本
+	current strings.Builder
+	//当前块的前缀,如列表标记和标题标记
+	prefix string
+	//是否在预格式化的文本中
+	preformatted bool
+}
+
+//完成当前块
+func (writer *textWriter) flush() {
+	content := writer.current.String()
+	writer.current.Reset()
+	prefix := writer.prefix
+	writer.prefix = ""
+	if !writer.preformatted {
+		lines := strings.Split(content, "\n")
+		for i, line := range lines {
+			lines[i] = normalizeText(line)
+		}
+		content = strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	writer.blocks = append(writer.blocks, prefix+content)
+}
+
+//在Markdown模式下写入标记
+func (writer *textWriter) mark(marker string) {
+	if writer.markdown {
+		writer.current.WriteString(marker)
+	}
+}
+
+//遍历节点
+func (writer *textWriter) walk(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		writer.current.WriteString(node.Data)
+		return
+	case html.ElementNode:
+	default:
+		writer.walkChildren(node)
+		return
+	}
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		writer.flush()
+		if writer.markdown {
+			writer.prefix = strings.Repeat("#", int(node.Data[1]-'0')) + " "
+		}
+		writer.walkChildren(node)
+		writer.flush()
+	case "p", "div", "section", "article", "main", "header", "figure", "figcaption",
+		"table", "tr", "dl", "dt", "dd", "ul", "ol", "address", "hr":
+		writer.flush()
+		writer.walkChildren(node)
+		writer.flush()
+	case "li":
+		writer.flush()
+		writer.prefix = "- "
+		if node.Parent != nil && node.Parent.Data == "ol" {
+			writer.prefix = listIndex(node) + ". "
+		}
+		writer.walkChildren(node)
+		writer.flush()
+	case "br":
+		writer.current.WriteString("\n")
+	case "td", "th":
+		writer.walkChildren(node)
+		writer.current.WriteString(" ")
+	case "pre":
+		writer.flush()
+		writer.preformatted = true
+		writer.mark("```\n")
+		writer.walkChildren(node)
+		writer.mark("\n```")
+		writer.flush()
+		writer.preformatted = false
+	case "blockquote":
+		writer.flush()
+		quote := &textWriter{pageUrl: writer.pageUrl, markdown: writer.markdown}
+		quote.walkChildren(node)
+		quote.flush()
+		text := strings.Join(quote.blocks, "\n\n")
+		if writer.markdown && text != "" {
+			text = "> " + strings.Replace(text, "\n", "\n> ", -1)
+		}
+		writer.current.WriteString(text)
+		writer.preformatted = true
+		writer.flush()
+		writer.preformatted = false
+	case "a":
+		href := resolveNodeAttr(node, "href", writer.pageUrl)
+		if !writer.markdown || href == "" || strings.HasPrefix(href, "javascript:") {
+			writer.walkChildren(node)
+			return
+		}
+		writer.current.WriteString("[")
+		writer.walkChildren(node)
+		writer.current.WriteString("](" + href + ")")
+	case "img":
+		src := resolveNodeAttr(node, "src", writer.pageUrl)
+		if writer.markdown && src != "" {
+			writer.current.WriteString("![" + nodeAttr(node, "alt") + "](" + src + ")")
+		}
+	case "strong", "b":
+		writer.mark("**")
+		writer.walkChildren(node)
+		writer.mark("**")
+	case "em", "i":
+		writer.mark("_")
+		writer.walkChildren(node)
+		writer.mark("_")
+	case "code":
+		if writer.preformatted {
+			writer.walkChildren(node)
+			return
+		}
+		writer.mark("`")
+		writer.walkChildren(node)
+		writer.mark("`")
+	default:
+		writer.walkChildren(node)
+	}
+}
+
+//遍历子节点
+func (writer *textWriter) walkChildren(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		writer.walk(child)
+	}
+}
+
+//获得有序列表项的序号
+func listIndex(node *html.Node) string {
+	index := 1
+	for sibling := node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == html.ElementNode && sibling.Data == "li" {
+			index++
+		}
+	}
+	return strconv.Itoa(index)
+}
+
+//获得节点的属性
+func nodeAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+//把节点的属性解析为绝对url
+func resolveNodeAttr(node *html.Node, key string, pageUrl *url.URL) string {
+	value := nodeAttr(node, key)
+	if value == "" || pageUrl == nil {
+		return value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return pageUrl.ResolveReference(parsed).String()
+}
@@ -0,0 +1,52 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"summerWebCrawler/base"
+)
+
+//响应没有被路由到解析函数时,经过路由的解析函数返回的错误.分析器会把它换成跳过错误
+var errNotRouted = errors.New("The content type of the response is not routed to the parser")
+
+//响应的内容类型不匹配任何一个经过路由的解析函数时,分析器返回的错误类型
+//调用方用它来统计被跳过的响应,而不是作为错误上报
+type SkipError struct {
+	//被跳过的url
+	url string
+	//响应的Content-Type
+	contentType string
+}
+
+//获取被跳过的url
+func (se *SkipError) URL() string {
+	return se.url
+}
+
+func (se *SkipError) Error() string {
+	return fmt.Sprintf("Skip the response (url=%s): the content type '%s' is not routed to any parser", se.url, se.contentType)
+}
+
+//判断错误是否代表响应被跳过
+func IsSkipError(err error) bool {
+	_, ok := err.(*SkipError)
+	return ok
+}
+
+//按内容类型路由响应解析函数
+//只有当响应的Content-Type与参数contentTypes之一匹配时,parser才会被调用.
+//contentTypes中的元素支持"text/*"这样的通配形式.
+//不匹配时返回errNotRouted,如果所有解析函数都没有被调用,分析器会返回SkipError
+func ForContentTypes(parser ParseResponse, contentTypes ...string) ParseResponse {
+	if parser == nil {
+		return nil
+	}
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		contentType := httpResp.Header.Get("Content-Type")
+		if !base.MatchMediaType(contentType, contentTypes) {
+			return nil, []error{errNotRouted}
+		}
+		return parser(httpResp, respDepth, respMeta)
+	}
+}
@@ -0,0 +1,60 @@
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//创建指定Content-Type的响应
+func newTypedResponse(contentType string) *base.Response {
+	pageUrl, _ := url.Parse("http://example.com/file")
+	httpResp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       ioutil.NopCloser(strings.NewReader("content")),
+		Request:    &http.Request{Method: "GET", URL: pageUrl},
+	}
+	return base.NewResponse(httpResp, 0)
+}
+
+//返回一个条目的解析函数
+func itemParser(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+	return []base.Data{&base.Item{"type": httpResp.Header.Get("Content-Type")}}, nil
+}
+
+func TestAnalyzeRouteByContentType(t *testing.T) {
+	parsers := []ParseResponse{
+		ForContentTypes(itemParser, "text/html"),
+		ForContentTypes(itemParser, "image/*"),
+	}
+	cases := []struct {
+		contentType string
+		items       int
+		skipped     bool
+	}{
+		{"text/html; charset=utf-8", 1, false},
+		{"image/png", 1, false},
+		{"application/pdf", 0, true},
+	}
+	for _, c := range cases {
+		dataList, errs := NewAnalyzer().Analyze(parsers, *newTypedResponse(c.contentType))
+		if len(dataList) != c.items {
+			t.Errorf("%d items are parsed from %q, want %d", len(dataList), c.contentType, c.items)
+		}
+		if skipped := len(errs) == 1 && IsSkipError(errs[0]); skipped != c.skipped {
+			t.Errorf("The response %q is skipped: %v, want %v (errors=%v)", c.contentType, skipped, c.skipped, errs)
+		}
+		if !c.skipped && len(errs) != 0 {
+			t.Errorf("Errors occur when analyzing %q: %v", c.contentType, errs)
+		}
+	}
+	//有不经过路由的解析函数时响应不算被跳过
+	parsers = append(parsers, itemParser)
+	if dataList, errs := NewAnalyzer().Analyze(parsers, *newTypedResponse("application/pdf")); len(dataList) != 1 || len(errs) != 0 {
+		t.Errorf("The result is (%v, %v), want 1 item and no errors", dataList, errs)
+	}
+}
@@ -0,0 +1,377 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+//结构化数据的格式
+const (
+	STRUCTURED_FORMAT_JSONLD    = "jsonld"
+	STRUCTURED_FORMAT_MICRODATA = "microdata"
+	STRUCTURED_FORMAT_RDFA      = "rdfa"
+	STRUCTURED_FORMAT_OPENGRAPH = "opengraph"
+	STRUCTURED_FORMAT_TWITTER   = "twitter"
+)
+
+//所有结构化数据的格式
+var StructuredFormats = []string{
+	STRUCTURED_FORMAT_JSONLD,
+	STRUCTURED_FORMAT_MICRODATA,
+	STRUCTURED_FORMAT_RDFA,
+	STRUCTURED_FORMAT_OPENGRAPH,
+	STRUCTURED_FORMAT_TWITTER,
+}
+
+//结构化数据条目的类型名称和其中的键
+//每个条目都形如{"_type":"structured_data", "format":格式, "schema_type":类型, "properties":属性}.
+//嵌套的条目(如微数据中的itemscope)以{"@type":类型, 属性...}的形式出现在属性值中
+const (
+	STRUCTURED_DATA_ITEM_TYPE  = "structured_data"
+	STRUCTURED_DATA_FORMAT     = "format"
+	STRUCTURED_DATA_SCHEMA     = "schema_type"
+	STRUCTURED_DATA_PROPERTIES = "properties"
+)
+
+//创建结构化数据提取器
+//参数formats代表需要提取的格式,为空时提取所有格式.条目的来源url会由分析器记录
+func NewStructuredDataExtractor(formats ...string) ParseResponse {
+	if len(formats) == 0 {
+		formats = StructuredFormats
+	}
+	for _, format := range formats {
+		if !isStructuredFormat(format) {
+			panic(errors.New(fmt.Sprintf("Unknown structured data format '%s'!", format)))
+		}
+	}
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		defer httpResp.Body.Close()
+		//只解析成功的响应
+		if httpResp.StatusCode != 200 {
+			return nil, nil
+		}
+		doc, err := goquery.NewDocumentFromReader(httpResp.Body)
+		if err != nil {
+			return nil, []error{err}
+		}
+		items, errs := extractStructuredData(doc, httpResp.Request.URL, formats)
+		dataList := make([]base.Data, 0, len(items))
+		for i := range items {
+			dataList = append(dataList, &items[i])
+		}
+		return dataList, errs
+	}
+}
+
+//判断格式是否有效
+func isStructuredFormat(format string) bool {
+	for _, known := range StructuredFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+//从文档中提取结构化数据
+func extractStructuredData(doc *goquery.Document, pageUrl *url.URL, formats []string) ([]base.Item, []error) {
+	items := make([]base.Item, 0)
+	errs := make([]error, 0)
+	for _, format := range formats {
+		switch format {
+		case STRUCTURED_FORMAT_JSONLD:
+			jsonLdItems, jsonLdErrs := extractJSONLD(doc)
+			items = append(items, jsonLdItems...)
+			errs = append(errs, jsonLdErrs...)
+		case STRUCTURED_FORMAT_MICRODATA:
+			items = append(items, extractMicrodata(doc, pageUrl)...)
+		case STRUCTURED_FORMAT_RDFA:
+			items = append(items, extractRDFa(doc, pageUrl)...)
+		case STRUCTURED_FORMAT_OPENGRAPH:
+			if item := extractMetaTags(doc, STRUCTURED_FORMAT_OPENGRAPH, "property", "og:", "type",
+				[]string{"article:", "book:", "profile:", "product:", "music:", "video:"}); item != nil {
+				items = append(items, item)
+			}
+		case STRUCTURED_FORMAT_TWITTER:
+			if item := extractMetaTags(doc, STRUCTURED_FORMAT_TWITTER, "name", "twitter:", "card", nil); item != nil {
+				items = append(items, item)
+			}
+		}
+	}
+	return items, errs
+}
+
+//创建结构化数据条目
+func newStructuredItem(format string, schemaType string, properties map[string]interface{}) base.Item {
+	item := base.Item{
+		STRUCTURED_DATA_FORMAT:     format,
+		STRUCTURED_DATA_SCHEMA:     schemaType,
+		STRUCTURED_DATA_PROPERTIES: properties,
+	}
+	item.SetType(STRUCTURED_DATA_ITEM_TYPE)
+	return item
+}
+
+//提取JSON-LD
+//数组和@graph中的每个节点都会成为单独的条目
+func extractJSONLD(doc *goquery.Document) ([]base.Item, []error) {
+	items := make([]base.Item, 0)
+	errs := make([]error, 0)
+	doc.Find(`script[type="application/ld+json"]`).Each(func(index int, selection *goquery.Selection) {
+		content := strings.TrimSpace(selection.Text())
+		if content == "" {
+			return
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(content), &value); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid JSON-LD block %d: %s", index, err)))
+			return
+		}
+		for _, node := range jsonLDNodes(value) {
+			items = append(items, newStructuredItem(STRUCTURED_FORMAT_JSONLD, jsonLDType(node["@type"]), node))
+		}
+	})
+	return items, errs
+}
+
+//展开JSON-LD中的节点
+func jsonLDNodes(value interface{}) []map[string]interface{} {
+	nodes := make([]map[string]interface{}, 0)
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, element := range typed {
+			nodes = append(nodes, jsonLDNodes(element)...)
+		}
+	case map[string]interface{}:
+		if graph, ok := typed["@graph"]; ok {
+			for _, node := range jsonLDNodes(graph) {
+				//@graph中的节点继承外层的@context
+				if _, exists := node["@context"]; !exists && typed["@context"] != nil {
+					node["@context"] = typed["@context"]
+				}
+				nodes = append(nodes, node)
+			}
+			return nodes
+		}
+		nodes = append(nodes, typed)
+	}
+	return nodes
+}
+
+//获得JSON-LD节点的类型,多个类型之间用空格分隔
+func jsonLDType(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case []interface{}:
+		types := make([]string, 0, len(typed))
+		for _, element := range typed {
+			if typeName, ok := element.(string); ok {
+				types = append(types, typeName)
+			}
+		}
+		return strings.Join(types, " ")
+	}
+	return ""
+}
+
+//提取微数据,只有顶层的itemscope会成为条目
+//带有itemprop但不在任何itemscope之中的itemscope同样是顶层的条目
+func extractMicrodata(doc *goquery.Document, pageUrl *url.URL) []base.Item {
+	items := make([]base.Item, 0)
+	doc.Find("[itemscope]").Each(func(index int, selection *goquery.Selection) {
+		if !topLevelScope(selection, "itemscope", "itemprop") {
+			return
+		}
+		typeName := strings.TrimSpace(selection.AttrOr("itemtype", ""))
+		properties := collectProperties(selection, pageUrl, "itemscope", "itemprop", "itemtype", microdataValue)
+		items = append(items, newStructuredItem(STRUCTURED_FORMAT_MICRODATA, typeName, properties))
+	})
+	return items
+}
+
+//提取RDFa-lite,只有顶层的typeof会成为条目
+func extractRDFa(doc *goquery.Document, pageUrl *url.URL) []base.Item {
+	items := make([]base.Item, 0)
+	doc.Find("[typeof]").Each(func(index int, selection *goquery.Selection) {
+		if !topLevelScope(selection, "typeof", "property") {
+			return
+		}
+		typeName := strings.TrimSpace(selection.AttrOr("typeof", ""))
+		if vocab := selection.AttrOr("vocab", ""); vocab != "" && !strings.Contains(typeName, ":") {
+			typeName = strings.TrimRight(vocab, "/") + "/" + typeName
+		}
+		properties := collectProperties(selection, pageUrl, "typeof", "property", "typeof", rdfaValue)
+		items = append(items, newStructuredItem(STRUCTURED_FORMAT_RDFA, typeName, properties))
+	})
+	return items
+}
+
+//判断条目是否是顶层的条目
+//不是属性的条目总是顶层的;是属性的条目只有在没有外层条目时才是顶层的,否则它会作为外层条目的属性值被收集
+func topLevelScope(selection *goquery.Selection, scopeAttr string, propAttr string) bool {
+	if _, isProp := selection.Attr(propAttr); !isProp {
+		return true
+	}
+	return nearestScope(selection.Get(0), scopeAttr) == nil
+}
+
+//收集属于某个条目的属性
+//参数scopeAttr代表开启新条目的属性,propAttr代表属性名所在的属性,typeAttr代表条目类型所在的属性.
+//只有最近的scopeAttr祖先是该条目的元素才属于该条目,嵌套的条目会被递归地收集
+func collectProperties(scope *goquery.Selection,
+	pageUrl *url.URL,
+	scopeAttr string,
+	propAttr string,
+	typeAttr string,
+	value func(*goquery.Selection, *url.URL) interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	scopeNode := scope.Get(0)
+	scope.Find("[" + propAttr + "]").Each(func(index int, selection *goquery.Selection) {
+		if nearestScope(selection.Get(0), scopeAttr) != scopeNode {
+			return
+		}
+		var propValue interface{}
+		if _, nested := selection.Attr(scopeAttr); nested {
+			nestedProps := collectProperties(selection, pageUrl, scopeAttr, propAttr, typeAttr, value)
+			if typeName := strings.TrimSpace(selection.AttrOr(typeAttr, "")); typeName != "" {
+				nestedProps["@type"] = typeName
+			}
+			propValue = nestedProps
+		} else {
+			propValue = value(selection, pageUrl)
+		}
+		for _, name := range strings.Fields(selection.AttrOr(propAttr, "")) {
+			addProperty(properties, name, propValue)
+		}
+	})
+	return properties
+}
+
+//查找最近的带有scopeAttr属性的祖先节点(不包括节点自身)
+func nearestScope(node *html.Node, scopeAttr string) *html.Node {
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		if parent.Type != html.ElementNode {
+			continue
+		}
+		for _, attr := range parent.Attr {
+			if attr.Key == scopeAttr {
+				return parent
+			}
+		}
+	}
+	return nil
+}
+
+//添加属性,同名的属性会被合并为列表
+func addProperty(properties map[string]interface{}, name string, value interface{}) {
+	existing, exists := properties[name]
+	if !exists {
+		properties[name] = value
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		properties[name] = append(list, value)
+		return
+	}
+	properties[name] = []interface{}{existing, value}
+}
+
+//获得微数据属性的值,规则参见HTML标准中的itemprop一节
+func microdataValue(selection *goquery.Selection, pageUrl *url.URL) interface{} {
+	switch goquery.NodeName(selection) {
+	case "meta":
+		return selection.AttrOr("content", "")
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return resolveAttr(selection, "src", pageUrl)
+	case "a", "area", "link":
+		return resolveAttr(selection, "href", pageUrl)
+	case "object":
+		return resolveAttr(selection, "data", pageUrl)
+	case "data", "meter":
+		return selection.AttrOr("value", "")
+	case "time":
+		if datetime, exists := selection.Attr("datetime"); exists {
+			return datetime
+		}
+	}
+	return normalizeText(selection.Text())
+}
+
+//获得RDFa属性的值,content属性优先
+func rdfaValue(selection *goquery.Selection, pageUrl *url.URL) interface{} {
+	if content, exists := selection.Attr("content"); exists {
+		return content
+	}
+	for _, attr := range []string{"href", "src", "resource"} {
+		if _, exists := selection.Attr(attr); exists {
+			return resolveAttr(selection, attr, pageUrl)
+		}
+	}
+	return microdataValue(selection, pageUrl)
+}
+
+//把属性值解析为绝对url
+func resolveAttr(selection *goquery.Selection, attr string, pageUrl *url.URL) string {
+	value := strings.TrimSpace(selection.AttrOr(attr, ""))
+	if value == "" || pageUrl == nil {
+		return value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return pageUrl.ResolveReference(parsed).String()
+}
+
+//合并文本中的空白
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+//提取以meta标签表示的数据(OpenGraph和Twitter卡片)
+//参数keyAttr代表属性名所在的属性,prefix代表需要去掉的前缀,typeKey代表去掉前缀之后表示类型的属性名,
+//参数extraPrefixes代表需要一并收集但保留前缀的属性
+func extractMetaTags(doc *goquery.Document,
+	format string,
+	keyAttr string,
+	prefix string,
+	typeKey string,
+	extraPrefixes []string) base.Item {
+	properties := make(map[string]interface{})
+	doc.Find("meta").Each(func(index int, selection *goquery.Selection) {
+		key := strings.TrimSpace(selection.AttrOr(keyAttr, ""))
+		if key == "" {
+			//很多网站把OpenGraph写在name中,或者把Twitter卡片写在property中
+			key = strings.TrimSpace(selection.AttrOr("name", selection.AttrOr("property", "")))
+		}
+		content, exists := selection.Attr("content")
+		if key == "" || !exists {
+			return
+		}
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, prefix) {
+			addProperty(properties, lowerKey[len(prefix):], content)
+			return
+		}
+		for _, extra := range extraPrefixes {
+			if strings.HasPrefix(lowerKey, extra) {
+				addProperty(properties, lowerKey, content)
+				return
+			}
+		}
+	})
+	if len(properties) == 0 {
+		return nil
+	}
+	typeName, _ := properties[typeKey].(string)
+	return newStructuredItem(format, typeName, properties)
+}
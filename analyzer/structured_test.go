@@ -0,0 +1,166 @@
+package analyzer
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//用结构化数据提取器解析testdata中的网页
+func extractFixture(t *testing.T, name string, formats ...string) ([]base.Item, []error) {
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Open fixture failing: %s", err)
+	}
+	pageUrl, _ := url.Parse("https://example.com/page")
+	httpResp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:       file,
+		Request:    &http.Request{URL: pageUrl},
+	}
+	dataList, errs := NewStructuredDataExtractor(formats...)(httpResp, 0, nil)
+	items := make([]base.Item, 0, len(dataList))
+	for _, data := range dataList {
+		item, ok := data.(*base.Item)
+		if !ok {
+			t.Fatalf("Unexpected data type: %T", data)
+		}
+		if item.Type() != STRUCTURED_DATA_ITEM_TYPE {
+			t.Errorf("The item type is %q, but expected %q", item.Type(), STRUCTURED_DATA_ITEM_TYPE)
+		}
+		items = append(items, *item)
+	}
+	return items, errs
+}
+
+//获得条目的属性
+func properties(item base.Item) map[string]interface{} {
+	props, _ := item[STRUCTURED_DATA_PROPERTIES].(map[string]interface{})
+	return props
+}
+
+//获得条目的类型的列表
+func schemaTypes(items []base.Item) []string {
+	types := make([]string, 0, len(items))
+	for _, item := range items {
+		types = append(types, item[STRUCTURED_DATA_SCHEMA].(string))
+	}
+	return types
+}
+
+func TestStructuredJSONLD(t *testing.T) {
+	items, errs := extractFixture(t, "jsonld.html", STRUCTURED_FORMAT_JSONLD)
+	if len(errs) != 1 {
+		t.Errorf("Expected 1 error for the invalid block, but got %v", errs)
+	}
+	expected := []string{"Article", "BreadcrumbList", "Product Thing", "Organization", "WebSite"}
+	if got := schemaTypes(items); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("The schema types are %v, but expected %v", got, expected)
+	}
+	author, _ := properties(items[0])["author"].(map[string]interface{})
+	if author["name"] != "Li Lei" {
+		t.Errorf("The nested author is %v", properties(items[0])["author"])
+	}
+	//@graph中的节点继承外层的@context
+	if properties(items[3])["@context"] != "https://schema.org" {
+		t.Errorf("The @context is not inherited by the @graph node: %v", properties(items[3]))
+	}
+	for _, item := range items {
+		if item[STRUCTURED_DATA_FORMAT] != STRUCTURED_FORMAT_JSONLD {
+			t.Errorf("The format is %v", item[STRUCTURED_DATA_FORMAT])
+		}
+	}
+}
+
+func TestStructuredMicrodata(t *testing.T) {
+	items, _ := extractFixture(t, "microdata.html", STRUCTURED_FORMAT_MICRODATA)
+	expected := []string{"https://schema.org/Movie", "https://schema.org/Person"}
+	if got := schemaTypes(items); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("The schema types are %v, but expected %v", got, expected)
+	}
+	movie := properties(items[0])
+	if movie["name"] != "Avatar" {
+		t.Errorf("The name is %v", movie["name"])
+	}
+	if !reflect.DeepEqual(movie["genre"], []interface{}{"Science fiction", "Adventure"}) {
+		t.Errorf("The genres are %v", movie["genre"])
+	}
+	if movie["datePublished"] != "2009-12-18" {
+		t.Errorf("The datePublished is %v", movie["datePublished"])
+	}
+	director := map[string]interface{}{
+		"@type": "https://schema.org/Person",
+		"name":  "James Cameron",
+		"url":   "https://example.com/people/james",
+	}
+	if !reflect.DeepEqual(movie["director"], director) {
+		t.Errorf("The director is %v, but expected %v", movie["director"], director)
+	}
+	//嵌套条目的属性不属于外层条目
+	if _, exists := movie["url"]; exists {
+		t.Errorf("The property of the nested item leaks to the outer item: %v", movie)
+	}
+	//带有itemprop但没有外层条目的itemscope是顶层的条目
+	if properties(items[1])["name"] != "Han Meimei" {
+		t.Errorf("The top level itemprop item is %v", properties(items[1]))
+	}
+}
+
+func TestStructuredRDFa(t *testing.T) {
+	items, _ := extractFixture(t, "rdfa.html", STRUCTURED_FORMAT_RDFA)
+	expected := []string{"https://schema.org/Person", "https://schema.org/Event"}
+	if got := schemaTypes(items); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("The schema types are %v, but expected %v", got, expected)
+	}
+	person := properties(items[0])
+	if person["name"] != "Zhang San" || person["jobTitle"] != "Engineer" {
+		t.Errorf("The person is %v", person)
+	}
+	if person["url"] != "https://example.com/zhangsan" {
+		t.Errorf("The url is %v", person["url"])
+	}
+	address := map[string]interface{}{"@type": "PostalAddress", "addressLocality": "Beijing"}
+	if !reflect.DeepEqual(person["address"], address) {
+		t.Errorf("The address is %v, but expected %v", person["address"], address)
+	}
+	if properties(items[1])["name"] != "Conference" {
+		t.Errorf("The top level property item is %v", properties(items[1]))
+	}
+}
+
+func TestStructuredMetaTags(t *testing.T) {
+	items, _ := extractFixture(t, "meta.html", STRUCTURED_FORMAT_OPENGRAPH, STRUCTURED_FORMAT_TWITTER)
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 items, but got %d: %v", len(items), items)
+	}
+	og := items[0]
+	if og[STRUCTURED_DATA_FORMAT] != STRUCTURED_FORMAT_OPENGRAPH || og[STRUCTURED_DATA_SCHEMA] != "article" {
+		t.Errorf("The open graph item is %v", og)
+	}
+	ogProps := properties(og)
+	expectedImages := []interface{}{"https://example.com/a.png", "https://example.com/b.png"}
+	if !reflect.DeepEqual(ogProps["image"], expectedImages) {
+		t.Errorf("The images are %v", ogProps["image"])
+	}
+	if ogProps["title"] != "Open Graph Title" || ogProps["site_name"] != "Example" ||
+		ogProps["article:author"] != "Li Lei" {
+		t.Errorf("The open graph properties are %v", ogProps)
+	}
+	if _, exists := ogProps["description"]; exists {
+		t.Errorf("The unrelated meta tag is collected: %v", ogProps)
+	}
+	twitter := items[1]
+	if twitter[STRUCTURED_DATA_FORMAT] != STRUCTURED_FORMAT_TWITTER ||
+		twitter[STRUCTURED_DATA_SCHEMA] != "summary_large_image" {
+		t.Errorf("The twitter item is %v", twitter)
+	}
+	twitterProps := properties(twitter)
+	if twitterProps["title"] != "Twitter Title" || twitterProps["site"] != "@example" {
+		t.Errorf("The twitter properties are %v", twitterProps)
+	}
+}
@@ -14,25 +14,88 @@ type Request struct {
 	httpReq *http.Request
 	//请求深度
 	depth uint32
+	//元数据,会随着请求传递到响应以及解析函数
+	meta Meta
 }
 
 //响应
 type Response struct {
 	httpResp *http.Response
 	depth    uint32
+	//元数据
+	meta Meta
 }
 
 //条目
 type Item map[string]interface{}
 
+//元数据,用来在组件之间传递附加信息
+type Meta map[string]interface{}
+
+//条目中预定义的键
+const (
+	//条目的类型名称
+	ITEM_TYPE_KEY = "_type"
+	//条目的来源url,即条目是从哪个网页中解析出来的
+	ITEM_SOURCE_URL_KEY = "_source_url"
+	//条目的ID,由条目处理管道在接受条目时生成
+	ITEM_ID_KEY = "_id"
+)
+
+//元数据中预定义的键
+const (
+	//响应体被转换为UTF-8之前的原始字符集
+	META_ORIGINAL_CHARSET = "original_charset"
+	//引用页的url,即请求是从哪个网页中解析出来的
+	META_REFERER = "referer"
+	//链接的锚文本
+	META_ANCHOR_TEXT = "anchor_text"
+	//产生该请求的解析函数的序号
+	META_PARSER_INDEX = "parser_index"
+	//链接的rel属性
+	META_LINK_REL = "link_rel"
+	//链接的来源,形如"a[href]"
+	META_LINK_SOURCE = "link_source"
+	//响应是否来自渲染器
+	META_RENDERED = "rendered"
+	//响应是否来自回放下载器
+	META_REPLAYED = "replayed"
+	//响应的缓存状态,取值为CACHE_HIT、CACHE_REVALIDATED或CACHE_MISS,未使用缓存时不设置
+	META_CACHE_STATUS = "cache_status"
+)
+
+//响应的缓存状态
+const (
+	//缓存仍然新鲜,没有访问网络
+	CACHE_HIT = "hit"
+	//服务器返回了304,响应体来自缓存
+	CACHE_REVALIDATED = "revalidated"
+	//缓存中没有或者服务器返回了新的内容
+	CACHE_MISS = "miss"
+)
+
 //创建新的请求
 func NewRequest(httpReq *http.Request, depth uint32) *Request {
-	return &Request{httpReq: httpReq, depth: depth}
+	return NewRequestWithMeta(httpReq, depth, nil)
+}
+
+//创建带有元数据的请求
+//元数据会被复制,解析函数传入响应的元数据时,新请求与响应以及其他请求不会共享同一份元数据
+func NewRequestWithMeta(httpReq *http.Request, depth uint32, meta Meta) *Request {
+	return &Request{httpReq: httpReq, depth: depth, meta: meta.Copy()}
 }
 
 //创建新的响应
 func NewResponse(httpResp *http.Response, depth uint32) *Response {
-	return &Response{httpResp: httpResp, depth: depth}
+	return NewResponseWithMeta(httpResp, depth, nil)
+}
+
+//创建带有元数据的响应
+func NewResponseWithMeta(httpResp *http.Response, depth uint32, meta Meta) *Response {
+	if meta == nil {
+		meta = make(Meta)
+	}
+	return &Response{httpResp: httpResp, depth: depth, meta: meta}
 }
 
 //获取请求
@@ -45,6 +108,19 @@ func (req *Request) Depth() uint32 {
 	return req.depth
 }
 
+//获取元数据
+func (req *Request) Meta() Meta {
+	return req.meta
+}
+
+//设置元数据中的值
+func (req *Request) SetMeta(key string, value interface{}) {
+	if req.meta == nil {
+		req.meta = make(Meta)
+	}
+	req.meta[key] = value
+}
+
 //数据是否有效
 func (req *Request) Valid() bool {
 	return req.httpReq != nil && req.httpReq.URL != nil
@@ -62,6 +138,20 @@ func (resp *Response) Depth() uint32 {
 	return resp.depth
 }
 
+//获取元数据
+func (resp *Response) Meta() Meta {
+	return resp.meta
+}
+
+//设置元数据中的值
+//由于元数据是引用类型,通过值复制得到的响应会共享同一份元数据
+func (resp *Response) SetMeta(key string, value interface{}) {
+	if resp.meta == nil {
+		resp.meta = make(Meta)
+	}
+	resp.meta[key] = value
+}
+
 //数据是否有效
 func (resp *Response) Valid() bool {
 	return resp.httpResp != nil && resp.httpResp.Body != nil
@@ -71,3 +161,49 @@ func (resp *Response) Valid() bool {
 func (item Item) Valid() bool {
 	return item != nil
 }
+
+//获取条目的类型名称,没有类型名称时返回空字符串
+func (item Item) Type() string {
+	if typeName, ok := item[ITEM_TYPE_KEY].(string); ok {
+		return typeName
+	}
+	return ""
+}
+
+//设置条目的类型名称
+func (item Item) SetType(typeName string) {
+	item[ITEM_TYPE_KEY] = typeName
+}
+
+//获取条目的ID,没有ID时返回空字符串
+func (item Item) ID() string {
+	if id, ok := item[ITEM_ID_KEY].(string); ok {
+		return id
+	}
+	return ""
+}
+
+//获取元数据中的值
+func (meta Meta) Get(key string) interface{} {
+	if meta == nil {
+		return nil
+	}
+	return meta[key]
+}
+
+//获取元数据中的字符串值,不存在或者类型不符时返回空字符串
+func (meta Meta) GetString(key string) string {
+	if v, ok := meta.Get(key).(string); ok {
+		return v
+	}
+	return ""
+}
+
+//复制元数据
+func (meta Meta) Copy() Meta {
+	newMeta := make(Meta, len(meta))
+	for k, v := range meta {
+		newMeta[k] = v
+	}
+	return newMeta
+}
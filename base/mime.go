@@ -0,0 +1,43 @@
+package base
+
+import (
+	"mime"
+	"strings"
+)
+
+//获取Content-Type中的媒体类型(小写,不含参数)
+func MediaType(contentType string) string {
+	contentType = strings.TrimSpace(contentType)
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		//解析失败时退而求其次,直接截掉参数部分
+		if index := strings.Index(contentType, ";"); index >= 0 {
+			contentType = contentType[:index]
+		}
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return strings.ToLower(mediaType)
+}
+
+//判断Content-Type是否与给定的媒体类型之一匹配
+//参数patterns中的元素可以是完整的媒体类型(如"text/html"),也可以是通配形式(如"text/*"或"*/*")
+func MatchMediaType(contentType string, patterns []string) bool {
+	mediaType := MediaType(contentType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") &&
+			strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
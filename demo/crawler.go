@@ -15,6 +15,13 @@ import (
 	"summerWebCrawler/itempipeline"
 	sched "summerWebCrawler/scheduler"
 	"summerWebCrawler/tool"
+	download "summerWebCrawler/downloadder"
+	"summerWebCrawler/warc"
+	"flag"
+	"path/filepath"
+	"summerWebCrawler/frontier"
+	middle "summerWebCrawler/middleware"
+	"net"
 )
 
 var (
@@ -23,6 +30,21 @@ var (
 )
 
 func main() {
+	//指定该参数时不访问网络,而是从已有的WARC归档中回放响应,用于修改解析函数之后重新分析网页
+	replay := flag.Bool("replay", false, "replay the responses from the warc archives instead of crawling")
+	//指定该参数时持续地重新爬取,只有发生了变化的网页才会产生条目
+	recrawl := flag.Bool("recrawl", false, "keep revisiting the crawled urls and emit items for changed pages only")
+	//分布式爬取:协调节点通过-serve-frontier提供共享的爬取边界,
+	//各工作节点(在各自的目录中)通过-frontier和-worker连接它
+	serveFrontier := flag.String("serve-frontier", "", "serve a shared frontier on the address and act as the coordinator")
+	workers := flag.Uint("workers", 1, "the number of workers of the shared frontier")
+	frontierAddr := flag.String("frontier", "", "the address of the shared frontier to work for")
+	worker := flag.Uint("worker", 0, "the worker number of this crawler")
+	flag.Parse()
+	if *serveFrontier != "" {
+		runCoordinator(*serveFrontier, uint32(*workers))
+		return
+	}
 
 	//happy new year
 	//创建调度器
@@ -40,7 +62,7 @@ func main() {
 	//解析函数,爬取到内容的时候应该怎么样解析
 	respParses := genResponseParsers()
 	//拿到条目后应该怎样处理.(可以存数据库、csv...)
-	itemProcessors := genItemProcessors()
+	itemStages := genItemStages()
 	//爬取页面
 	startUrl := "http://www.sogou.com"
 	//第一次请求返回的响应
@@ -51,14 +73,114 @@ func main() {
 		return
 	}
 
+	//所有下载器共享的cookie容器
+	cookieJar, err := download.NewCookieJar("")
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	//只下载不超过5MB的网页,跳过二进制文件和其他类型的内容
+	downloaderArgs := download.DownloaderArgs{
+		Filter: download.NewContentFilter(
+			download.DefaultSkipExts,
+			[]string{"text/html", "application/xhtml+xml"},
+			5<<20,
+			true),
+		Jar: cookieJar,
+	}
+	if *replay {
+		//从归档中回放响应,归档中没有的url会被跳过
+		paths, err := filepath.Glob("crawl-*.warc.gz")
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		store, err := warc.NewStore(paths...)
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		scheduler.SetPageDownloaderGenerator(download.NewReplayDownloaderGenerator(store, download.MISS_SKIP))
+	} else {
+		//缓存响应,重新爬取时发送条件请求,未修改的网页使用缓存中的响应体
+		cache, err := download.NewDiskCache("http-cache")
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		downloaderArgs.Cache = cache
+		//把下载到的响应按记录压缩写入WARC归档,单个文件不超过100MB
+		archive, err := warc.NewWriter(warc.NewWriterArgs("crawl", true, 100<<20, nil))
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		downloaderArgs.Recorder = archive
+	}
+	scheduler.SetDownloaderArgs(downloaderArgs)
+
+	//校验解析出的条目
+	scheduler.SetSchemaRegistry(genSchemaRegistry(), rejectItem)
+	//把条目写入JSON Lines文件,每100条写出一次,单个文件不超过10MB
+	itemSink, err := itempipeline.NewJSONLinesSink(itempipeline.NewSinkArgs("items.jsonl", 100, 10<<20))
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetItemSinks([]itempipeline.ItemSink{itemSink})
+	//记录已写出的条目,重新爬取时跳过检查点之前已写出的条目.每5秒设置一次检查点
+	itemLedger, err := itempipeline.NewItemLedger("items.ledger")
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetItemLedger(itemLedger, 5*time.Second)
+	if *recrawl {
+		//重新抓取的间隔在1小时到7天之间,首次抓取之后1天重新抓取
+		tracker, err := sched.NewRecrawlTracker(sched.NewRecrawlArgs("recrawl.json", time.Hour, 24*time.Hour, 7*24*time.Hour))
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		scheduler.SetRecrawlTracker(tracker)
+	}
+	if *frontierAddr != "" {
+		sharedFrontier, err := frontier.DialFrontier(*frontierAddr)
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		defer sharedFrontier.Close()
+		scheduler.SetFrontier(sharedFrontier, uint32(*worker))
+	}
+	//根据延迟和错误率自动调整并发数:全局1到10个网页下载器,每个主机1到4个并发,平均延迟的目标为2秒,每5秒调整一次
+	controller, err := sched.NewAIMDController(sched.NewConcurrencyArgs(1, 10, 1, 4, 2*time.Second, 5*time.Second))
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetConcurrencyController(controller)
+	//等待网页下载器或分析器超过1分钟时报告错误,被持有超过5分钟的实体被报告为可能已泄漏
+	scheduler.SetPoolHealthArgs(sched.NewPoolHealthArgs(time.Minute, 5*time.Minute, nil, nil))
+	//全局每秒最多10个请求,每个主机每秒最多2个请求
+	rateLimiter, err := middle.NewRateLimiter([]middle.RateRule{
+		middle.NewRateRule(middle.RATE_SCOPE_GLOBAL, "", 10, 10),
+		middle.NewRateRule(middle.RATE_SCOPE_HOST, "", 2, 2),
+	})
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetRateLimiter(rateLimiter)
+
 	//开启调度器
-	scheduler.Start(
+	scheduler.StartWithStages(
 		channelArgs,
 		poolBaseArgs,
 		crawlDepth,
 		httpClientGenerator,
 		respParses,
-		itemProcessors,
+		itemStages,
 		firstHttpReq)
 
 	//开始监控.包括输出错误信息,summary信息.
@@ -76,6 +198,34 @@ func main() {
 	<-checkCountChan
 }
 
+//作为协调节点运行,提供共享的爬取边界并定期输出各工作节点报告的摘要信息
+//整个爬取持续空闲一段时间之后退出
+func runCoordinator(address string, workers uint32) {
+	localFrontier, err := frontier.NewLocalFrontier(workers)
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	defer listener.Close()
+	go frontier.ServeFrontier(localFrontier, listener)
+	idleCount := 0
+	for idleCount < 3 {
+		time.Sleep(10 * time.Second)
+		summary, _ := localFrontier.Summary()
+		logger.Infoln(summary)
+		if idle, _ := localFrontier.Idle(); idle {
+			idleCount++
+		} else {
+			idleCount = 0
+		}
+	}
+}
+
 //生成http客户端
 func genHttpClient() *http.Client {
 	return &http.Client{}
@@ -84,17 +234,42 @@ func genHttpClient() *http.Client {
 //获得响应解析函数的序列
 func genResponseParsers() []analyzer.ParseResponse {
 	parsers := []analyzer.ParseResponse{
-		parseForATag,
+		//提取链接,遵守nofollow
+		analyzer.ForContentTypes(
+			analyzer.NewLinkExtractor(analyzer.NewLinkExtractorArgs(analyzer.DefaultLinkSources, true)),
+			"text/html", "application/xhtml+xml"),
+		analyzer.ForContentTypes(parseForATag, "text/html", "application/xhtml+xml"),
 	}
 	return parsers
 }
 
-//获得条目处理器的序列
-func genItemProcessors() []itempipeline.ProcessItem {
-	itemProcessors := []itempipeline.ProcessItem{
-		processItem,
+//获得条目处理阶段的序列
+func genItemStages() []itempipeline.ItemStage {
+	itemStages := []itempipeline.ItemStage{
+		//3个工作goroutine,队列长度为10,出错时重试2次,仍然失败则快速失败
+		itempipeline.NewItemStage("process", processItem, 3, 10).
+			WithErrorPolicy(itempipeline.NewRetryPolicy(2, 100*time.Millisecond, itempipeline.NewFailFastPolicy())),
 	}
-	return itemProcessors
+	return itemStages
+}
+
+//获得条目的模式注册表
+func genSchemaRegistry() itempipeline.SchemaRegistry {
+	registry := itempipeline.NewSchemaRegistry()
+	registry.Register(itempipeline.Schema{
+		TypeName: "anchor",
+		Fields: []itempipeline.FieldRule{
+			{Name: "a.text", Kind: itempipeline.FIELD_KIND_STRING, Required: true, MaxLen: 200},
+			{Name: "parent_url", Kind: itempipeline.FIELD_KIND_URL, Required: true},
+		},
+	})
+	return registry
+}
+
+//拒收处理器,只记录校验失败的条目
+func rejectItem(item base.Item) (result base.Item, err error) {
+	logger.Warnf("Reject the item: %v\n", item)
+	return item, nil
 }
 
 //条目处理器
@@ -115,10 +290,10 @@ func processItem(item base.Item) (result base.Item, err error) {
 }
 
 //响应解析函数,只解析"A"标签
-func parseForATag(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+func parseForATag(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
 	//TODO支持更多的http响应状态
 	if httpResp.StatusCode != 200 {
-		err := errors.New(fmt.Sprintf("unsupported status code %d. (httpResponseCode=%d)", httpResp.StatusCode))
+		err := errors.New(fmt.Sprintf("unsupported status code %d. (requestUrl=%s)", httpResp.StatusCode, httpResp.Request.URL))
 		return nil, []error{err}
 	}
 
@@ -139,39 +314,20 @@ func parseForATag(httpResp *http.Response, respDepth uint32) ([]base.Data, []err
 		return dataList, errs
 	}
 
-	//查找"A"标签并提取链接地址
+	//查找"A"标签并提取锚文本,链接由链接提取器负责
 	doc.Find("a").Each(func(index int, selection *goquery.Selection) {
 		href, exists := selection.Attr("href")
 		//前期过滤
 		if !exists || href == "" || href == "#" || href == "/" {
 			return
 		}
-		href = strings.TrimSpace(href)
-		lowerHref := strings.ToLower(href)
-		//暂不支持对JavaScript代码的解析
-		if href != "" && !strings.HasPrefix(lowerHref, "javascript") {
-			aUrl, err := url.Parse(href)
-			if err != nil {
-				errs = append(errs, err)
-				return
-			}
-			if !aUrl.IsAbs() {
-				aUrl = reqUrl.ResolveReference(aUrl)
-			}
-			httpReq, err := http.NewRequest("GET", aUrl.String(), nil)
-			if err != nil {
-				errs = append(errs, err)
-			} else {
-				req := base.NewRequest(httpReq, respDepth)
-				dataList = append(dataList, req)
-			}
-		}
 		text := strings.TrimSpace(selection.Text())
 		if text != "" {
 			imap := make(map[string]interface{})
 			imap["a.text"] = text
 			imap["parent_url"] = reqUrl
 			item := base.Item(imap)
+			item.SetType("anchor")
 			dataList = append(dataList, &item)
 		}
 	})
@@ -0,0 +1,320 @@
+package downloadder
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//http缓存的接口类型
+//网页下载器用它保存响应的验证器(ETag/Last-Modified)和响应体,
+//缓存仍然新鲜时直接使用缓存中的响应,否则发送条件请求,收到304时使用缓存中的响应体
+type HTTPCache interface {
+	//获取缓存条目,不存在时返回(nil, nil)
+	Get(key string) (*CacheEntry, error)
+	//保存缓存条目
+	Put(key string, entry *CacheEntry) error
+	//获取摘要信息
+	Summary() string
+}
+
+//缓存条目
+type CacheEntry struct {
+	//url
+	URL string
+	//状态
+	Status string
+	//状态码
+	StatusCode int
+	//响应头
+	Header http.Header
+	//响应体
+	Body []byte
+	//被保存(或最近一次被验证)的时间
+	StoredAt time.Time
+}
+
+//启发式新鲜期的上限
+const maxHeuristicLifetime = 24 * time.Hour
+
+//获得缓存条目在索引中的键
+func cacheKey(reqUrl *url.URL) string {
+	u := *reqUrl
+	u.Fragment = ""
+	sum := sha1.Sum([]byte(u.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+//判断请求是否可以使用缓存,只有GET请求会被缓存
+func cacheableRequest(httpReq *http.Request) bool {
+	if httpReq.Method != "" && httpReq.Method != "GET" {
+		return false
+	}
+	return !hasCacheDirective(httpReq.Header, "no-store")
+}
+
+//判断响应是否可以被缓存
+//只缓存状态码为200且带有验证器或者新鲜期的响应,按Accept-Encoding以外的头部区分的响应不会被缓存
+func cacheableResponse(httpResp *http.Response) bool {
+	if httpResp.StatusCode != http.StatusOK || hasCacheDirective(httpResp.Header, "no-store") {
+		return false
+	}
+	for _, vary := range httpResp.Header["Vary"] {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	if httpResp.Header.Get("ETag") != "" || httpResp.Header.Get("Last-Modified") != "" {
+		return true
+	}
+	return freshnessLifetime(httpResp.Header, time.Now()) > 0
+}
+
+//创建缓存条目
+func newCacheEntry(httpResp *http.Response, body []byte) *CacheEntry {
+	header := make(http.Header, len(httpResp.Header))
+	for k, v := range httpResp.Header {
+		header[k] = v
+	}
+	//响应体已经被http客户端解码,这里记录的是解码之后的长度
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &CacheEntry{
+		URL:        httpResp.Request.URL.String(),
+		Status:     httpResp.Status,
+		StatusCode: httpResp.StatusCode,
+		Header:     header,
+		Body:       body,
+		StoredAt:   time.Now(),
+	}
+}
+
+//判断缓存条目是否仍然新鲜
+func (entry *CacheEntry) Fresh(now time.Time) bool {
+	if hasCacheDirective(entry.Header, "no-cache") {
+		return false
+	}
+	age := now.Sub(entry.StoredAt)
+	if seconds, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age < freshnessLifetime(entry.Header, entry.StoredAt)
+}
+
+//根据验证的结果(304响应的头部)更新缓存条目
+func (entry *CacheEntry) revalidated(header http.Header) {
+	for k, v := range header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		entry.Header[k] = v
+	}
+	entry.StoredAt = time.Now()
+}
+
+//给请求加上条件请求的头部
+//返回的是请求的副本,原请求不会被修改
+func (entry *CacheEntry) conditional(httpReq *http.Request) *http.Request {
+	condReq := httpReq.Clone(httpReq.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		condReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		condReq.Header.Set("If-Modified-Since", lastModified)
+	}
+	return condReq
+}
+
+//根据缓存条目生成http响应
+func (entry *CacheEntry) response(httpReq *http.Request) *http.Response {
+	header := make(http.Header, len(entry.Header))
+	for k, v := range entry.Header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        entry.Status,
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       httpReq,
+	}
+}
+
+//计算新鲜期
+//优先使用Cache-Control的max-age,其次是Expires与Date的差,
+//都没有时使用Last-Modified至今的时长的10%(不超过24小时)
+func freshnessLifetime(header http.Header, storedAt time.Time) time.Duration {
+	if hasCacheDirective(header, "no-cache") {
+		return 0
+	}
+	if value, ok := cacheDirective(header, "max-age"); ok {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date := storedAt
+	if t, err := http.ParseTime(header.Get("Date")); err == nil {
+		date = t
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(t) {
+		lifetime := date.Sub(t) / 10
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		return lifetime
+	}
+	return 0
+}
+
+//获取Cache-Control中的指令的值
+func cacheDirective(header http.Header, name string) (string, bool) {
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			key, arg := directive, ""
+			if index := strings.Index(directive, "="); index >= 0 {
+				key, arg = strings.TrimSpace(directive[:index]), strings.Trim(strings.TrimSpace(directive[index+1:]), `"`)
+			}
+			if strings.EqualFold(key, name) {
+				return arg, true
+			}
+		}
+	}
+	return "", false
+}
+
+//判断是否有Cache-Control指令,Pragma: no-cache等同于Cache-Control: no-cache
+func hasCacheDirective(header http.Header, name string) bool {
+	if _, ok := cacheDirective(header, name); ok {
+		return true
+	}
+	return name == "no-cache" && strings.EqualFold(strings.TrimSpace(header.Get("Pragma")), "no-cache")
+}
+
+//磁盘缓存的实现类型
+//每个条目保存为一个文件,先写入临时文件再重命名,中途失败不会留下不完整的条目
+type myDiskCache struct {
+	//缓存目录
+	dir string
+	//读取的次数
+	gets uint64
+	//读取到条目的次数
+	found uint64
+	//保存的次数
+	puts uint64
+	//互斥锁,只保护计数
+	mutex sync.Mutex
+}
+
+var diskCacheSummaryTemplate = "{dir:%s, gets:%d, found:%d, puts:%d}"
+
+//创建磁盘缓存,目录不存在时会被创建
+func NewDiskCache(dir string) (HTTPCache, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, errors.New("The cache directory can not be empty!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &myDiskCache{dir: dir}, nil
+}
+
+//获得条目的文件路径,按键的前两个字符分目录
+func (cache *myDiskCache) path(key string) string {
+	return filepath.Join(cache.dir, key[:2], key+".cache")
+}
+
+func (cache *myDiskCache) Get(key string) (*CacheEntry, error) {
+	if len(key) < 2 {
+		return nil, errors.New(fmt.Sprintf("Invalid cache key '%s'!", key))
+	}
+	cache.count(&cache.gets)
+	content, err := ioutil.ReadFile(cache.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &CacheEntry{}
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(entry); err != nil {
+		return nil, errors.New(fmt.Sprintf("Decode cache entry %s failing: %s", key, err))
+	}
+	cache.count(&cache.found)
+	return entry, nil
+}
+
+func (cache *myDiskCache) Put(key string, entry *CacheEntry) error {
+	if len(key) < 2 {
+		return errors.New(fmt.Sprintf("Invalid cache key '%s'!", key))
+	}
+	path := cache.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	cache.count(&cache.puts)
+	return nil
+}
+
+//计数
+func (cache *myDiskCache) count(counter *uint64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	*counter++
+}
+
+func (cache *myDiskCache) Summary() string {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return fmt.Sprintf(diskCacheSummaryTemplate, cache.dir, cache.gets, cache.found, cache.puts)
+}
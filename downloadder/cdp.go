@@ -0,0 +1,331 @@
+package downloadder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//通过DevTools协议驱动无头浏览器的渲染器
+//每次渲染都会打开一个新的标签页,渲染结束后关闭它,因此可以被多个网页下载器并发地使用
+type cdpRenderer struct {
+	//浏览器的调试地址,如http://127.0.0.1:9222
+	endpoint string
+	//单次渲染的超时时间
+	timeout time.Duration
+	//页面加载完成之后再等待的时间,留给异步脚本生成内容
+	settle time.Duration
+	//访问调试地址使用的http客户端
+	client *http.Client
+}
+
+//DevTools协议中的标签页信息
+type cdpTarget struct {
+	Id                   string `json:"id"`
+	WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+}
+
+//DevTools协议中的消息
+type cdpMessage struct {
+	Id     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+//DevTools协议中的Network.responseReceived事件
+type cdpResponseReceived struct {
+	Type     string `json:"type"`
+	FrameId  string `json:"frameId"`
+	Response struct {
+		Url     string            `json:"url"`
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	} `json:"response"`
+}
+
+//DevTools协议中单条消息的最大长度
+var cdpMaxMessageSize int64 = 64 << 20
+
+//获取渲染结果的脚本
+const cdpRenderScript = "({url: location.href, html: document.documentElement.outerHTML})"
+
+//创建DevTools协议渲染器
+//参数endpoint是以--remote-debugging-port启动的浏览器的调试地址,
+//参数timeout是单次渲染的超时时间,参数settle是页面加载完成之后再等待的时间
+func NewCDPRenderer(endpoint string, timeout time.Duration, settle time.Duration) (Renderer, error) {
+	endpointUrl, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpointUrl.Scheme != "http" && endpointUrl.Scheme != "https" {
+		return nil, errors.New(fmt.Sprintf("Invalid devtools endpoint '%s'!", endpoint))
+	}
+	if timeout <= 0 {
+		return nil, errors.New("The render timeout must be positive!")
+	}
+	return &cdpRenderer{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		timeout:  timeout,
+		settle:   settle,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (renderer *cdpRenderer) Render(httpReq *http.Request, jar http.CookieJar) (*http.Response, error) {
+	target, err := renderer.newTarget()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Open devtools target failing: %s", err))
+	}
+	defer renderer.closeTarget(target)
+	deadline := time.Now().Add(renderer.timeout)
+	dialer := &websocket.Dialer{HandshakeTimeout: renderer.timeout}
+	ws, _, err := dialer.Dial(target.WebSocketDebuggerUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		ws.Close()
+	}()
+	ws.SetReadLimit(cdpMaxMessageSize)
+	ws.SetReadDeadline(deadline)
+	ws.SetWriteDeadline(deadline)
+	session := &cdpSession{ws: ws, events: make(map[string]bool), documents: make(map[string]*cdpResponseReceived)}
+	if _, err := session.call("Page.enable", nil); err != nil {
+		return nil, err
+	}
+	//启用网络事件以获得主文档的响应,并带上请求的头部和cookie,以便渲染需要登录的网页
+	if _, err := session.call("Network.enable", nil); err != nil {
+		return nil, err
+	}
+	if headers := cdpHeaders(httpReq.Header); len(headers) > 0 {
+		if _, err := session.call("Network.setExtraHTTPHeaders", map[string]interface{}{"headers": headers}); err != nil {
+			return nil, err
+		}
+	}
+	if cookies := cdpCookies(jar, httpReq.URL); len(cookies) > 0 {
+		if _, err := session.call("Network.setCookies", map[string]interface{}{"cookies": cookies}); err != nil {
+			return nil, err
+		}
+	}
+	navResult, err := session.call("Page.navigate", map[string]interface{}{"url": httpReq.URL.String()})
+	if err != nil {
+		return nil, err
+	}
+	var nav struct {
+		FrameId   string `json:"frameId"`
+		ErrorText string `json:"errorText"`
+	}
+	if err := json.Unmarshal(navResult, &nav); err == nil && nav.ErrorText != "" {
+		return nil, errors.New(fmt.Sprintf("Navigate to '%s' failing: %s", httpReq.URL, nav.ErrorText))
+	}
+	if err := session.waitEvent("Page.loadEventFired"); err != nil {
+		return nil, err
+	}
+	if renderer.settle > 0 {
+		time.Sleep(renderer.settle)
+	}
+	evalResult, err := session.call("Runtime.evaluate", map[string]interface{}{
+		"expression":    cdpRenderScript,
+		"returnByValue": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var eval struct {
+		Result struct {
+			Value struct {
+				Url  string `json:"url"`
+				Html string `json:"html"`
+			} `json:"value"`
+		} `json:"result"`
+		ExceptionDetails interface{} `json:"exceptionDetails"`
+	}
+	if err := json.Unmarshal(evalResult, &eval); err != nil {
+		return nil, err
+	}
+	if eval.ExceptionDetails != nil {
+		return nil, errors.New(fmt.Sprintf("Evaluate render script failing: %v", eval.ExceptionDetails))
+	}
+	var statusCode int
+	var header http.Header
+	if document := session.documents[nav.FrameId]; document != nil {
+		statusCode = document.Response.Status
+		header = make(http.Header, len(document.Response.Headers))
+		for name, value := range document.Response.Headers {
+			//DevTools协议用换行符连接同名头部的多个值
+			for _, v := range strings.Split(value, "\n") {
+				header.Add(name, v)
+			}
+		}
+	}
+	return newRenderedResponse(httpReq, eval.Result.Value.Url, statusCode, header,
+		[]byte("<!DOCTYPE html>\n"+eval.Result.Value.Html))
+}
+
+//把请求的头部转换为Network.setExtraHTTPHeaders的参数,同名头部的多个值用逗号连接
+func cdpHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if len(values) == 0 {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+//把cookie容器中对应url的cookie转换为Network.setCookies的参数
+func cdpCookies(jar http.CookieJar, reqUrl *url.URL) []map[string]interface{} {
+	if jar == nil {
+		return nil
+	}
+	cookies := jar.Cookies(reqUrl)
+	params := make([]map[string]interface{}, 0, len(cookies))
+	for _, cookie := range cookies {
+		params = append(params, map[string]interface{}{
+			"name":  cookie.Name,
+			"value": cookie.Value,
+			"url":   reqUrl.String(),
+		})
+	}
+	return params
+}
+
+//打开一个新的标签页
+//新版本的浏览器要求使用PUT方法,旧版本只支持GET方法
+func (renderer *cdpRenderer) newTarget() (*cdpTarget, error) {
+	newUrl := renderer.endpoint + "/json/new?about:blank"
+	var lastErr error
+	for _, method := range []string{"PUT", "GET"} {
+		req, err := http.NewRequest(method, newUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := renderer.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			lastErr = errors.New(fmt.Sprintf("%s %s: %s", method, newUrl, resp.Status))
+			continue
+		}
+		target := &cdpTarget{}
+		if err := json.Unmarshal(body, target); err != nil {
+			return nil, err
+		}
+		if target.WebSocketDebuggerUrl == "" {
+			return nil, errors.New("The devtools target has no websocket url!")
+		}
+		return target, nil
+	}
+	return nil, lastErr
+}
+
+//关闭标签页
+func (renderer *cdpRenderer) closeTarget(target *cdpTarget) {
+	resp, err := renderer.client.Get(renderer.endpoint + "/json/close/" + target.Id)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (renderer *cdpRenderer) Close() error {
+	return nil
+}
+
+//DevTools协议的会话
+type cdpSession struct {
+	//WebSocket连接
+	ws *websocket.Conn
+	//下一个消息的ID
+	nextId int64
+	//已收到的事件的集合
+	events map[string]bool
+	//框架ID到该框架的文档响应的映射,跳转时后收到的响应会覆盖之前的
+	documents map[string]*cdpResponseReceived
+}
+
+//调用方法并等待结果,等待期间收到的事件会被记录下来
+func (session *cdpSession) call(method string, params interface{}) (json.RawMessage, error) {
+	session.nextId++
+	id := session.nextId
+	msg := cdpMessage{Id: id, Method: method}
+	if params != nil {
+		rawParams, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		msg.Params = rawParams
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.ws.WriteMessage(websocket.TextMessage, content); err != nil {
+		return nil, err
+	}
+	for {
+		msg, err := session.read()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Id != id {
+			continue
+		}
+		if msg.Error != nil {
+			return nil, errors.New(fmt.Sprintf("Devtools method '%s' failing: %s", method, msg.Error.Message))
+		}
+		return msg.Result, nil
+	}
+}
+
+//等待事件
+func (session *cdpSession) waitEvent(method string) error {
+	for !session.events[method] {
+		if _, err := session.read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//读取一条消息
+func (session *cdpSession) read() (*cdpMessage, error) {
+	_, content, err := session.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg := &cdpMessage{}
+	if err := json.Unmarshal(content, msg); err != nil {
+		return nil, err
+	}
+	if msg.Method != "" {
+		session.events[msg.Method] = true
+	}
+	if msg.Method == "Network.responseReceived" {
+		received := &cdpResponseReceived{}
+		if err := json.Unmarshal(msg.Params, received); err == nil && received.Type == "Document" {
+			session.documents[received.FrameId] = received
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,136 @@
+package downloadder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+//cookie容器的接口类型
+//同一次爬取中的所有网页下载器共享同一个cookie容器,它必须是并发安全的
+type CookieJar interface {
+	http.CookieJar
+	//把cookie保存到持久化文件.没有指定持久化文件时什么都不做
+	Save() error
+}
+
+//cookie容器的实现类型
+type myCookieJar struct {
+	//实际存储cookie的容器
+	jar *cookiejar.Jar
+	//持久化文件的路径,为空表示不持久化
+	path string
+	//被设置过的cookie的记录,用于持久化
+	records map[string]cookieRecord
+	//针对记录的互斥锁
+	mutex sync.Mutex
+}
+
+//被持久化的cookie记录
+type cookieRecord struct {
+	//设置该cookie的url
+	URL string `json:"url"`
+	//cookie
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+//创建cookie容器
+//参数path代表持久化文件的路径,为空表示不持久化.如果文件已存在,其中的cookie会被加载
+func NewCookieJar(path string) (CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	cj := &myCookieJar{
+		jar:     jar,
+		path:    path,
+		records: make(map[string]cookieRecord),
+	}
+	if path != "" {
+		if err := cj.load(); err != nil {
+			return nil, err
+		}
+	}
+	return cj, nil
+}
+
+func (cj *myCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	cj.jar.SetCookies(u, cookies)
+	if cj.path == "" {
+		return
+	}
+	cj.mutex.Lock()
+	defer cj.mutex.Unlock()
+	for _, cookie := range cookies {
+		cj.records[genCookieKey(u, cookie)] = cookieRecord{URL: u.String(), Cookie: cookie}
+	}
+}
+
+func (cj *myCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return cj.jar.Cookies(u)
+}
+
+func (cj *myCookieJar) Save() error {
+	if cj.path == "" {
+		return nil
+	}
+	cj.mutex.Lock()
+	records := make([]cookieRecord, 0, len(cj.records))
+	now := time.Now()
+	for key, record := range cj.records {
+		//过期或者被删除的cookie不再保存
+		if record.Cookie.MaxAge < 0 ||
+			(!record.Cookie.Expires.IsZero() && record.Cookie.Expires.Before(now)) {
+			delete(cj.records, key)
+			continue
+		}
+		records = append(records, record)
+	}
+	cj.mutex.Unlock()
+	content, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	//先写临时文件再改名,避免保存中途出错破坏原有的文件
+	tempPath := cj.path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, cj.path)
+}
+
+//从持久化文件中加载cookie
+func (cj *myCookieJar) load() error {
+	content, err := ioutil.ReadFile(cj.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var records []cookieRecord
+	if err := json.Unmarshal(content, &records); err != nil {
+		errMsg := fmt.Sprintf("Invalid cookie file (path=%s): %s\n", cj.path, err)
+		return errors.New(errMsg)
+	}
+	for _, record := range records {
+		u, err := url.Parse(record.URL)
+		if err != nil || record.Cookie == nil {
+			continue
+		}
+		cj.SetCookies(u, []*http.Cookie{record.Cookie})
+	}
+	return nil
+}
+
+//生成cookie记录的键.同一主机下域,路径和名称都相同的cookie会相互覆盖
+func genCookieKey(u *url.URL, cookie *http.Cookie) string {
+	return fmt.Sprintf("%s|%s|%s|%s", u.Host, cookie.Domain, cookie.Path, cookie.Name)
+}
@@ -4,6 +4,15 @@ import (
 	"summerWebCrawler/base"
 	"net/http"
 	"summerWebCrawler/middleware"
+	"fmt"
+	"regexp"
+	"io/ioutil"
+	"bytes"
+	"io"
+	"net/url"
+	"time"
+	"summerWebCrawler/logging"
+	"errors"
 )
 
 //网页下载器的接口类型
@@ -14,15 +23,56 @@ type PageDownloader interface {
 	Download(req base.Request) (*base.Response, error)
 }
 
+//网页下载器的附加参数
+//同一个调度器中的所有网页下载器共享同一份附加参数
+type DownloaderArgs struct {
+	//内容过滤器,为nil时不做过滤
+	Filter *ContentFilter
+	//共享的cookie容器,为nil时使用http客户端自己的设置
+	Jar http.CookieJar
+	//渲染器,为nil时不做渲染
+	Renderer Renderer
+	//需要被渲染的url的模式,为空时所有url都会被渲染
+	RenderURLs []*regexp.Regexp
+	//响应记录器,为nil时不做记录
+	Recorder ResponseRecorder
+	//被记录的响应体的最大尺寸,单位:字节.响应体超过它的响应不会被记录,0表示使用DefaultRecordMaxSize
+	RecordMaxSize int64
+	//http缓存,为nil时不做缓存
+	Cache HTTPCache
+	//被缓存的响应体的最大尺寸,单位:字节.响应体超过它的响应不会被缓存,0表示使用DefaultCacheMaxSize
+	CacheMaxSize int64
+}
+
+//响应记录器的接口类型
+//网页下载器产生的每个响应都会被交给响应记录器,如写入WARC归档.
+//跳转途中的每个响应也会被单独记录,以便回放时可以从原来的url跟随到跳转之后的url
+type ResponseRecorder interface {
+	//记录响应,参数body是响应体的全部内容
+	RecordResponse(httpResp *http.Response, body []byte) error
+	//记录渲染器返回的响应,参数body是渲染之后的网页.它不是网络上收到的响应体,记录器应该把它和一般的响应区分开
+	RecordRendered(httpResp *http.Response, body []byte) error
+	//关闭响应记录器
+	Close() error
+}
+
 type myPageDownloader struct {
 	//http客户端
 	httpClient http.Client
 	//Id
 	id uint32
+	//附加参数
+	args DownloaderArgs
 }
 
 var (
+	//被记录的响应体的默认最大尺寸
+	DefaultRecordMaxSize int64 = 32 << 20
+	//被缓存的响应体的默认最大尺寸
+	DefaultCacheMaxSize int64 = 32 << 20
 	downloaderIdGenertor middleware.IdGenertor = middleware.NewIdGenertor()
+	//日志记录器
+	logger logging.Logger = base.NewLogger()
 )
 
 //生成Id
@@ -32,28 +82,277 @@ func genDownloaderId() uint32 {
 
 //创建网页下载器
 func NewPageDownloader(client *http.Client) PageDownloader {
+	return NewPageDownloaderWithArgs(client, DownloaderArgs{})
+}
+
+//根据附加参数创建网页下载器
+func NewPageDownloaderWithArgs(client *http.Client, args DownloaderArgs) PageDownloader {
 	id := genDownloaderId()
 	//如果没有提供client,初始化一个
 	if client == nil {
 		client = &http.Client{}
 	}
-	return &myPageDownloader{
+	dl := &myPageDownloader{
 		id:         id,
 		httpClient: *client,
+		args:       args,
+	}
+	//所有下载器共享同一个cookie容器,一个下载器收到的cookie对其他下载器同样可见
+	if args.Jar != nil {
+		dl.httpClient.Jar = args.Jar
+	}
+	if args.Recorder != nil {
+		dl.httpClient.CheckRedirect = dl.recordRedirect(client.CheckRedirect)
+	}
+	return dl
+}
+
+//生成记录跳转响应的跳转策略,参数checkRedirect是原来的跳转策略,为nil时使用http客户端的默认策略
+//只有会被跟随的跳转响应才在这里记录,否则它会作为最终的响应被记录
+func (dl *myPageDownloader) recordRedirect(checkRedirect func(req *http.Request, via []*http.Request) error) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		var err error
+		if checkRedirect != nil {
+			err = checkRedirect(req, via)
+		} else if len(via) >= 10 {
+			err = errors.New("stopped after 10 redirects")
+		}
+		if err == nil && req.Response != nil {
+			//跳转响应的响应体在跳转策略返回之后才会被http客户端关闭
+			if recordErr := dl.record(req.Response, req.Response.Request.URL, false); recordErr != nil {
+				logger.Errorf("Record redirect response failing: %s (requestUrl=%s)\n", recordErr, req.Response.Request.URL)
+			}
+		}
+		return err
 	}
 }
 
+//根据http客户端和附加参数生成网页下载器的函数类型
+//NewPageDownloaderWithArgs即是默认的生成函数
+type PageDownloaderGenerator func(client *http.Client, args DownloaderArgs) PageDownloader
+
 func (dl *myPageDownloader) Id() uint32 {
 	return dl.id
 }
 
 func (dl *myPageDownloader) Download(req base.Request) (*base.Response, error) {
 	httpReq := req.HttpReq()
+	filter := dl.args.Filter
+	//需要渲染的网页交给渲染器,不使用缓存
+	rendered := dl.args.needRender(httpReq.URL)
+	var entry *CacheEntry
+	if !rendered {
+		entry = dl.lookupCache(httpReq)
+	}
+	//下载前根据url判断是否需要下载,已被缓存的url不需要再发送HEAD请求
+	if filter != nil {
+		if err := filter.CheckURL(httpReq.URL); err != nil {
+			return nil, err
+		}
+		if entry == nil && filter.NeedHead(httpReq.URL) {
+			if err := dl.checkByHead(httpReq); err != nil {
+				return nil, err
+			}
+		}
+	}
 	//获取响应
-	httpResp, err := dl.httpClient.Do(httpReq)
+	var httpResp *http.Response
+	var cacheStatus string
+	var err error
+	if rendered {
+		httpResp, err = dl.args.Renderer.Render(httpReq, dl.httpClient.Jar)
+	} else {
+		httpResp, cacheStatus, err = dl.fetch(httpReq, entry)
+	}
 	if err != nil {
 		return nil, err
 	}
-	//返回一个响应
-	return base.NewResponse(httpResp, req.Depth()), nil
+	//收到响应头之后再检查一次
+	if filter != nil {
+		if err := filter.CheckHeader(httpResp); err != nil {
+			httpResp.Body.Close()
+			return nil, err
+		}
+		if err := filter.LimitBody(httpResp); err != nil {
+			return nil, err
+		}
+	}
+	//通过过滤的新响应才会被缓存.缓存条目按原来的url存储,命中时无法还原跳转之后的url,因此发生过跳转的响应不被缓存
+	if cacheStatus == base.CACHE_MISS && cacheableResponse(httpResp) && !redirected(httpReq, httpResp) {
+		if err := dl.cache(httpResp, httpReq); err != nil {
+			return nil, err
+		}
+	}
+	//记录响应,响应体被读出之后会被重置,以便分析器再次读取
+	//来自缓存的响应在第一次被下载时已经被记录过,不再重复记录.
+	//写入记录失败不影响下载,响应仍然会被交给分析器
+	if dl.args.Recorder != nil && cacheStatus != base.CACHE_HIT && cacheStatus != base.CACHE_REVALIDATED {
+		if err := dl.record(httpResp, httpReq.URL, rendered); err != nil {
+			return nil, err
+		}
+	}
+	//返回一个响应,请求的元数据会被复制到响应中
+	meta := req.Meta().Copy()
+	if rendered {
+		meta[base.META_RENDERED] = true
+	}
+	if cacheStatus != "" {
+		meta[base.META_CACHE_STATUS] = cacheStatus
+	}
+	return base.NewResponseWithMeta(httpResp, req.Depth(), meta), nil
+}
+
+//查找请求对应的缓存条目,没有缓存、请求不可缓存或者条目读取失败时返回nil
+func (dl *myPageDownloader) lookupCache(httpReq *http.Request) *CacheEntry {
+	if dl.args.Cache == nil || !cacheableRequest(httpReq) {
+		return nil
+	}
+	entry, err := dl.args.Cache.Get(cacheKey(httpReq.URL))
+	if err != nil {
+		//损坏的条目会在下载之后被覆盖
+		logger.Warnf("Get cache entry failing: %s (requestUrl=%s)\n", err, httpReq.URL)
+		return nil
+	}
+	return entry
+}
+
+//通过网络或者缓存获取响应,同时返回缓存状态,不使用缓存时缓存状态为空字符串
+//缓存条目仍然新鲜时直接使用它,否则发送条件请求,收到304时使用缓存条目中的响应体
+func (dl *myPageDownloader) fetch(httpReq *http.Request, entry *CacheEntry) (*http.Response, string, error) {
+	if dl.args.Cache == nil || !cacheableRequest(httpReq) {
+		httpResp, err := dl.httpClient.Do(httpReq)
+		return httpResp, "", err
+	}
+	if entry == nil {
+		httpResp, err := dl.httpClient.Do(httpReq)
+		return httpResp, base.CACHE_MISS, err
+	}
+	if !hasCacheDirective(httpReq.Header, "no-cache") && entry.Fresh(time.Now()) {
+		return entry.response(httpReq), base.CACHE_HIT, nil
+	}
+	httpResp, err := dl.httpClient.Do(entry.conditional(httpReq))
+	if err != nil {
+		return nil, "", err
+	}
+	if httpResp.StatusCode != http.StatusNotModified {
+		//响应的Request字段换回原请求的头部,以免条件请求的头部被记录下来.发生过跳转时保留跳转之后的url
+		finalReq := httpResp.Request.Clone(httpReq.Context())
+		finalReq.Header = httpReq.Header.Clone()
+		httpResp.Request = finalReq
+		return httpResp, base.CACHE_MISS, nil
+	}
+	httpResp.Body.Close()
+	entry.revalidated(httpResp.Header)
+	dl.storeCache(httpReq, entry)
+	return entry.response(httpReq), base.CACHE_REVALIDATED, nil
+}
+
+//判断响应是否来自跳转之后的url
+func redirected(httpReq *http.Request, httpResp *http.Response) bool {
+	return httpResp.Request != nil && httpResp.Request.URL.String() != httpReq.URL.String()
+}
+
+//存储缓存条目,失败时只输出日志,该url下次会被重新下载
+func (dl *myPageDownloader) storeCache(httpReq *http.Request, entry *CacheEntry) {
+	if err := dl.args.Cache.Put(cacheKey(httpReq.URL), entry); err != nil {
+		logger.Warnf("Store cache entry failing: %s (requestUrl=%s)\n", err, httpReq.URL)
+	}
+}
+
+//缓存新下载的响应,只有读取响应体失败时才返回错误
+//响应体超过最大尺寸时只输出日志,响应仍然会被完整地交给分析器
+func (dl *myPageDownloader) cache(httpResp *http.Response, httpReq *http.Request) error {
+	maxSize := dl.args.CacheMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultCacheMaxSize
+	}
+	body, complete, err := readBodyLimited(httpResp, maxSize)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		logger.Warnf("Skip caching the response whose body is greater than %d (requestUrl=%s)\n", maxSize, httpReq.URL)
+		return nil
+	}
+	dl.storeCache(httpReq, newCacheEntry(httpResp, body))
+	return nil
+}
+
+//记录响应,参数rendered表示响应是否来自渲染器.只有读取响应体失败时才返回错误
+//响应体超过最大尺寸或者写入记录失败时只输出日志
+func (dl *myPageDownloader) record(httpResp *http.Response, reqUrl *url.URL, rendered bool) error {
+	maxSize := dl.args.RecordMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultRecordMaxSize
+	}
+	body, complete, err := readBodyLimited(httpResp, maxSize)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		logger.Warnf("Skip recording the response whose body is greater than %d (requestUrl=%s)\n", maxSize, reqUrl)
+		return nil
+	}
+	if rendered {
+		err = dl.args.Recorder.RecordRendered(httpResp, body)
+	} else {
+		err = dl.args.Recorder.RecordResponse(httpResp, body)
+	}
+	if err != nil {
+		logger.Errorf("Record response failing: %s (requestUrl=%s)\n", err, reqUrl)
+	}
+	return nil
+}
+
+//最多读出响应体的limit个字节,结果值complete表示响应体是否被完整地读出
+//完整读出时响应体会被重置以便再次读取;否则已读出的部分会被放回响应体之前,响应体的其余部分不会被读取
+func readBodyLimited(httpResp *http.Response, limit int64) (body []byte, complete bool, err error) {
+	original := httpResp.Body
+	//多读一个字节用来判断是否超限
+	content, err := ioutil.ReadAll(io.LimitReader(original, limit+1))
+	if err != nil {
+		original.Close()
+		return nil, false, err
+	}
+	if int64(len(content)) > limit {
+		httpResp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(content), original), original}
+		return nil, false, nil
+	}
+	original.Close()
+	httpResp.Body = ioutil.NopCloser(bytes.NewReader(content))
+	return content, true, nil
+}
+
+//发送HEAD请求并根据响应头判断是否需要下载
+//HEAD请求本身失败时不做判断,交给后续的GET请求处理
+func (dl *myPageDownloader) checkByHead(httpReq *http.Request) error {
+	headReq, err := http.NewRequest("HEAD", httpReq.URL.String(), nil)
+	if err != nil {
+		return nil
+	}
+	for k, v := range httpReq.Header {
+		headReq.Header[k] = v
+	}
+	headResp, err := dl.httpClient.Do(headReq)
+	if err != nil {
+		return nil
+	}
+	defer headResp.Body.Close()
+	if headResp.StatusCode != http.StatusOK {
+		return nil
+	}
+	return dl.args.Filter.CheckHeader(headResp)
+}
+
+//获取附加参数的字符串表现形式
+func (args DownloaderArgs) String() string {
+	filter := "<nil>"
+	if args.Filter != nil {
+		filter = args.Filter.String()
+	}
+	return fmt.Sprintf("{filter:%s, cookieJar:%v, renderer:%v, renderUrls:%v, recorder:%v, cache:%v, cacheMaxSize:%d}",
+		filter, args.Jar != nil, args.Renderer != nil, args.RenderURLs, args.Recorder != nil, args.Cache != nil, args.CacheMaxSize)
 }
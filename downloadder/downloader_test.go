@@ -0,0 +1,288 @@
+package downloadder
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//模拟的响应记录器
+type fakeRecorder struct {
+	//写入记录时返回的错误
+	err error
+	//已记录的响应体
+	bodies [][]byte
+	//已记录的响应的url和状态码
+	responses []string
+	//已记录的渲染结果
+	rendered [][]byte
+}
+
+func (recorder *fakeRecorder) RecordResponse(httpResp *http.Response, body []byte) error {
+	if recorder.err != nil {
+		return recorder.err
+	}
+	recorder.bodies = append(recorder.bodies, body)
+	recorder.responses = append(recorder.responses, fmt.Sprintf("%s %d", httpResp.Request.URL.Path, httpResp.StatusCode))
+	return nil
+}
+
+func (recorder *fakeRecorder) RecordRendered(httpResp *http.Response, body []byte) error {
+	if recorder.err != nil {
+		return recorder.err
+	}
+	recorder.rendered = append(recorder.rendered, body)
+	return nil
+}
+
+func (recorder *fakeRecorder) Close() error {
+	return nil
+}
+
+//启动返回固定内容的服务器
+func newPageServer(content string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(content))
+	}))
+}
+
+//下载并读出响应体
+func downloadBody(t *testing.T, dl PageDownloader, rawUrl string) string {
+	httpReq, _ := http.NewRequest("GET", rawUrl, nil)
+	resp, err := dl.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		t.Fatalf("An error occurs when downloading: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.HttpResp().Body)
+	resp.HttpResp().Body.Close()
+	if err != nil {
+		t.Fatalf("An error occurs when reading the body: %s", err)
+	}
+	return string(body)
+}
+
+//写入记录失败不应导致下载失败
+func TestDownloadRecorderFailure(t *testing.T) {
+	content := "<html>page</html>"
+	server := newPageServer(content)
+	defer server.Close()
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Recorder: &fakeRecorder{err: errors.New("disk full")}})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+}
+
+//响应体超过最大尺寸的响应不被记录,但仍然被完整地交给分析器
+func TestDownloadRecordMaxSize(t *testing.T) {
+	content := strings.Repeat("x", 100)
+	server := newPageServer(content)
+	defer server.Close()
+	recorder := &fakeRecorder{}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Recorder: recorder, RecordMaxSize: 10})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+	if len(recorder.bodies) != 0 {
+		t.Errorf("The oversized response is recorded")
+	}
+	dl = NewPageDownloaderWithArgs(nil, DownloaderArgs{Recorder: recorder, RecordMaxSize: 100})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+	if len(recorder.bodies) != 1 || string(recorder.bodies[0]) != content {
+		t.Errorf("The recorded bodies are %q", recorder.bodies)
+	}
+}
+
+//模拟的http缓存
+type fakeCache struct {
+	//保存缓存条目时返回的错误
+	putErr error
+	//缓存条目
+	entries map[string]*CacheEntry
+}
+
+func (cache *fakeCache) Get(key string) (*CacheEntry, error) {
+	return cache.entries[key], nil
+}
+
+func (cache *fakeCache) Put(key string, entry *CacheEntry) error {
+	if cache.putErr != nil {
+		return cache.putErr
+	}
+	cache.entries[key] = entry
+	return nil
+}
+
+func (cache *fakeCache) Summary() string {
+	return ""
+}
+
+//启动带有缓存头部的服务器,参数maxAge为0时每次都需要重新验证.收到匹配的If-None-Match时返回304
+func newCachedPageServer(content string, maxAge string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age="+maxAge)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(content))
+	}))
+}
+
+//保存缓存条目失败不应导致下载失败
+func TestDownloadCachePutFailure(t *testing.T) {
+	content := "<html>page</html>"
+	server := newCachedPageServer(content, "60")
+	defer server.Close()
+	cache := &fakeCache{putErr: errors.New("disk full"), entries: make(map[string]*CacheEntry)}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Cache: cache})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+	//重新验证之后保存失败同样不影响下载
+	cache.putErr = nil
+	downloadBody(t, dl, server.URL)
+	for _, entry := range cache.entries {
+		entry.Header.Set("Cache-Control", "max-age=0")
+	}
+	cache.putErr = errors.New("disk full")
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+}
+
+//来自缓存的响应不应被重复记录
+func TestDownloadCachedResponseNotRecorded(t *testing.T) {
+	content := "<html>page</html>"
+	for _, maxAge := range []string{"60", "0"} {
+		server := newCachedPageServer(content, maxAge)
+		cache := &fakeCache{entries: make(map[string]*CacheEntry)}
+		recorder := &fakeRecorder{}
+		dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Cache: cache, Recorder: recorder})
+		for i := 0; i < 3; i++ {
+			if body := downloadBody(t, dl, server.URL); body != content {
+				t.Errorf("The body is %q, but expected %q (maxAge=%s)", body, content, maxAge)
+			}
+		}
+		if len(recorder.bodies) != 1 {
+			t.Errorf("The response is recorded %d times, but expected once (maxAge=%s)", len(recorder.bodies), maxAge)
+		}
+		server.Close()
+	}
+}
+
+//响应体超过最大尺寸的响应不被缓存,但仍然被完整地交给分析器
+func TestDownloadCacheMaxSize(t *testing.T) {
+	content := strings.Repeat("x", 100)
+	server := newCachedPageServer(content, "60")
+	defer server.Close()
+	cache := &fakeCache{entries: make(map[string]*CacheEntry)}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Cache: cache, CacheMaxSize: 10})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("The oversized response is cached")
+	}
+	dl = NewPageDownloaderWithArgs(nil, DownloaderArgs{Cache: cache, CacheMaxSize: 100})
+	if body := downloadBody(t, dl, server.URL); body != content {
+		t.Errorf("The body is %q, but expected %q", body, content)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("The response is not cached")
+	}
+	for _, entry := range cache.entries {
+		if string(entry.Body) != content {
+			t.Errorf("The cached body is %q, but expected %q", entry.Body, content)
+		}
+	}
+}
+
+//重新验证时发生跳转,响应的Request字段保留跳转之后的url,但不带条件请求的头部
+func TestDownloadRevalidationRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"new"`)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("new page"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	cache := &fakeCache{entries: make(map[string]*CacheEntry)}
+	movedUrl, _ := url.Parse(server.URL + "/moved")
+	cache.entries[cacheKey(movedUrl)] = &CacheEntry{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Etag": {`"old"`}, "Cache-Control": {"max-age=0"}},
+		Body:       []byte("old page"),
+	}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Cache: cache})
+	httpReq, _ := http.NewRequest("GET", movedUrl.String(), nil)
+	resp, err := dl.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		t.Fatalf("An error occurs when downloading: %s", err)
+	}
+	httpResp := resp.HttpResp()
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	if string(body) != "new page" {
+		t.Errorf("The body is %q, but expected %q", body, "new page")
+	}
+	if finalUrl := httpResp.Request.URL.String(); finalUrl != server.URL+"/new" {
+		t.Errorf("The final url is %s, but expected %s", finalUrl, server.URL+"/new")
+	}
+	if etag := httpResp.Request.Header.Get("If-None-Match"); etag != "" {
+		t.Errorf("The request has the conditional header If-None-Match: %s", etag)
+	}
+	//跳转之后的响应不覆盖原来的url的缓存条目
+	if entry := cache.entries[cacheKey(movedUrl)]; string(entry.Body) != "old page" {
+		t.Errorf("The cached body is %q, but expected %q", entry.Body, "old page")
+	}
+}
+
+//跳转途中的每个响应都会被单独记录
+func TestDownloadRecordRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new page"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	recorder := &fakeRecorder{}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Recorder: recorder})
+	if body := downloadBody(t, dl, server.URL+"/old"); body != "new page" {
+		t.Errorf("The body is %q, but expected %q", body, "new page")
+	}
+	want := "/old 301,/middle 302,/new 200"
+	if got := strings.Join(recorder.responses, ","); got != want {
+		t.Errorf("The recorded responses are %q, but expected %q", got, want)
+	}
+	//原来的跳转策略仍然有效
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	recorder = &fakeRecorder{}
+	dl = NewPageDownloaderWithArgs(client, DownloaderArgs{Recorder: recorder})
+	downloadBody(t, dl, server.URL+"/old")
+	if got := strings.Join(recorder.responses, ","); got != "/old 301" {
+		t.Errorf("The recorded responses are %q, but expected %q", got, "/old 301")
+	}
+}
@@ -0,0 +1,193 @@
+package downloadder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"summerWebCrawler/base"
+)
+
+//内容过滤器
+//在下载前根据url的扩展名(必要时发送HEAD请求)判断是否需要下载,
+//在收到响应头之后再根据Content-Type和Content-Length做一次判断,
+//并在读取响应体时限制其最大尺寸
+type ContentFilter struct {
+	//需要直接跳过的扩展名(小写,包含".")
+	skipExts map[string]bool
+	//允许的Content-Type,为空表示不做限制
+	allowedTypes []string
+	//响应体的最大尺寸,单位:字节.0表示不做限制
+	maxBodySize int64
+	//对于无法根据扩展名判断内容类型的url,是否先发送HEAD请求
+	headCheck bool
+	//描述
+	description string
+}
+
+//被跳过的url的错误类型
+//下载器用它来告诉调用方这个url是被主动过滤掉的,而不是下载失败
+type SkipError struct {
+	//被跳过的url
+	url string
+	//跳过的原因
+	reason string
+}
+
+var (
+	//默认跳过的扩展名
+	DefaultSkipExts = []string{
+		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+		".zip", ".rar", ".7z", ".gz", ".tar", ".bz2", ".exe", ".apk", ".dmg", ".iso",
+		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".ico", ".svg", ".webp",
+		".mp3", ".wav", ".flac", ".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv",
+		".css", ".js", ".woff", ".woff2", ".ttf",
+	}
+	//可以确定是网页的扩展名,这些url不需要发送HEAD请求
+	pageExts = map[string]bool{
+		"": true, ".html": true, ".htm": true, ".shtml": true, ".xhtml": true,
+		".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".do": true, ".action": true,
+	}
+	//内容过滤器的描述模板
+	contentFilterTemplate = "{skipExts:%d, allowedTypes:%v, maxBodySize:%d, headCheck:%v}"
+)
+
+//创建内容过滤器
+//参数skipExts代表需要直接跳过的扩展名,如".pdf"
+//参数allowedTypes代表允许的Content-Type,支持"text/*"这样的通配形式.为空表示不做限制
+//参数maxBodySize代表响应体的最大尺寸,单位:字节.0表示不做限制
+//参数headCheck代表对于无法根据扩展名判断内容类型的url,是否先发送HEAD请求
+func NewContentFilter(skipExts []string, allowedTypes []string, maxBodySize int64, headCheck bool) *ContentFilter {
+	exts := make(map[string]bool)
+	for _, ext := range skipExts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	if maxBodySize < 0 {
+		maxBodySize = 0
+	}
+	//过滤器会被多个网页下载器并发使用,描述在创建时生成
+	return &ContentFilter{
+		skipExts:     exts,
+		allowedTypes: allowedTypes,
+		maxBodySize:  maxBodySize,
+		headCheck:    headCheck,
+		description: fmt.Sprintf(contentFilterTemplate,
+			len(exts),
+			allowedTypes,
+			maxBodySize,
+			headCheck),
+	}
+}
+
+//获取url路径的扩展名(小写)
+func urlExt(reqUrl *url.URL) string {
+	if reqUrl == nil {
+		return ""
+	}
+	return strings.ToLower(path.Ext(reqUrl.Path))
+}
+
+//根据url的扩展名检查是否需要下载
+func (filter *ContentFilter) CheckURL(reqUrl *url.URL) error {
+	ext := urlExt(reqUrl)
+	if filter.skipExts[ext] {
+		return newSkipError(reqUrl, fmt.Sprintf("the extension '%s' is skipped", ext))
+	}
+	return nil
+}
+
+//判断是否需要先发送HEAD请求
+func (filter *ContentFilter) NeedHead(reqUrl *url.URL) bool {
+	if !filter.headCheck {
+		return false
+	}
+	return !pageExts[urlExt(reqUrl)]
+}
+
+//根据响应头检查内容类型和尺寸
+func (filter *ContentFilter) CheckHeader(httpResp *http.Response) error {
+	var reqUrl *url.URL
+	if httpResp.Request != nil {
+		reqUrl = httpResp.Request.URL
+	}
+	contentType := httpResp.Header.Get("Content-Type")
+	//没有Content-Type的响应交给后续的解析函数自行判断
+	if len(filter.allowedTypes) > 0 && contentType != "" &&
+		!base.MatchMediaType(contentType, filter.allowedTypes) {
+		return newSkipError(reqUrl, fmt.Sprintf("the content type '%s' is not allowed", contentType))
+	}
+	if filter.maxBodySize > 0 && httpResp.ContentLength > filter.maxBodySize {
+		return newSkipError(reqUrl, fmt.Sprintf("the content length %d is greater than %d",
+			httpResp.ContentLength, filter.maxBodySize))
+	}
+	return nil
+}
+
+//按最大尺寸读取响应体
+//一旦超过最大尺寸就会中止传输并返回跳过错误,否则响应体会被替换成已读取的内容
+func (filter *ContentFilter) LimitBody(httpResp *http.Response) error {
+	if filter.maxBodySize <= 0 || httpResp.Body == nil {
+		return nil
+	}
+	body := httpResp.Body
+	defer body.Close()
+	//多读一个字节用来判断是否超限
+	content, err := ioutil.ReadAll(io.LimitReader(body, filter.maxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) > filter.maxBodySize {
+		var reqUrl *url.URL
+		if httpResp.Request != nil {
+			reqUrl = httpResp.Request.URL
+		}
+		return newSkipError(reqUrl, fmt.Sprintf("the body size is greater than %d", filter.maxBodySize))
+	}
+	httpResp.Body = ioutil.NopCloser(bytes.NewReader(content))
+	httpResp.ContentLength = int64(len(content))
+	return nil
+}
+
+func (filter *ContentFilter) String() string {
+	return filter.description
+}
+
+//创建跳过错误
+func newSkipError(reqUrl *url.URL, reason string) *SkipError {
+	var urlStr string
+	if reqUrl != nil {
+		urlStr = reqUrl.String()
+	}
+	return &SkipError{url: urlStr, reason: reason}
+}
+
+//获取被跳过的url
+func (se *SkipError) URL() string {
+	return se.url
+}
+
+//获取跳过的原因
+func (se *SkipError) Reason() string {
+	return se.reason
+}
+
+func (se *SkipError) Error() string {
+	return fmt.Sprintf("Skip the url (url=%s): %s", se.url, se.reason)
+}
+
+//判断错误是否代表url被跳过
+func IsSkipError(err error) bool {
+	_, ok := err.(*SkipError)
+	return ok
+}
@@ -1,10 +1,12 @@
 package downloadder
 
 import (
+	"context"
 	"summerWebCrawler/middleware"
 	"reflect"
 	"fmt"
 	"errors"
+	"time"
 )
 
 //网页下载池的接口类型
@@ -12,12 +14,24 @@ import (
 type PageDownloaderPool interface {
 	//从池中取出一个网页下载器
 	Take() (PageDownloader, error)
+	//从池中取出一个网页下载器,超过参数timeout仍没有空闲的网页下载器时返回错误
+	TakeWithTimeout(timeout time.Duration) (PageDownloader, error)
+	//从池中取出一个网页下载器,参数ctx被取消时返回它的错误
+	TakeContext(ctx context.Context) (PageDownloader, error)
 	//把一个网页下载器归还给池
 	Return(dl PageDownloader) error
 	//获取池的总容量
 	Total() uint32
 	//获得正在被使用的网页下载器的数据
 	Used() uint32
+	//调整池的总容量,缩小时正在被使用的网页下载器会在被归还时淘汰
+	Resize(total uint32) error
+	//设置网页下载器的健康检查函数,归还时检查失败的网页下载器会被替换为新生成的
+	SetHealthCheck(check func(PageDownloader) bool)
+	//获得被取出的时间超过参数threshold且尚未归还的网页下载器
+	Leaks(threshold time.Duration) []middleware.Leak
+	//获得因健康检查失败而被替换的网页下载器的数量
+	Replaced() uint32
 }
 
 //网页下载器池的实现类型
@@ -52,7 +66,19 @@ func NewPageDownloaderPool(total uint32, gen GenPageDownlaoder) (PageDownloaderP
 
 func (dlPool *myDownloaderPool) Take() (PageDownloader, error) {
 	//从网页下载器池取出一个实体
-	entity, err := dlPool.pool.Take()
+	return dlPool.convert(dlPool.pool.Take())
+}
+
+func (dlPool *myDownloaderPool) TakeWithTimeout(timeout time.Duration) (PageDownloader, error) {
+	return dlPool.convert(dlPool.pool.TakeWithTimeout(timeout))
+}
+
+func (dlPool *myDownloaderPool) TakeContext(ctx context.Context) (PageDownloader, error) {
+	return dlPool.convert(dlPool.pool.TakeContext(ctx))
+}
+
+//把取出的实体转换为网页下载器
+func (dlPool *myDownloaderPool) convert(entity middleware.Entity, err error) (PageDownloader, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -77,3 +103,26 @@ func (dlPool *myDownloaderPool) Total() uint32 {
 func (dlPool *myDownloaderPool) Used() uint32 {
 	return dlPool.pool.Used()
 }
+
+func (dlPool *myDownloaderPool) Resize(total uint32) error {
+	return dlPool.pool.Resize(total)
+}
+
+func (dlPool *myDownloaderPool) SetHealthCheck(check func(PageDownloader) bool) {
+	if check == nil {
+		dlPool.pool.SetHealthCheck(nil)
+		return
+	}
+	dlPool.pool.SetHealthCheck(func(entity middleware.Entity) bool {
+		dl, ok := entity.(PageDownloader)
+		return ok && check(dl)
+	})
+}
+
+func (dlPool *myDownloaderPool) Leaks(threshold time.Duration) []middleware.Leak {
+	return dlPool.pool.Leaks(threshold)
+}
+
+func (dlPool *myDownloaderPool) Replaced() uint32 {
+	return dlPool.pool.Replaced()
+}
@@ -0,0 +1,112 @@
+package downloadder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+//渲染器的接口类型
+//渲染器会执行网页中的JavaScript,并把渲染之后的DOM作为HTML响应返回.
+//结果值会像普通的响应一样经过内容过滤器并被交给分析器
+type Renderer interface {
+	//渲染请求对应的网页,渲染时应带上请求的头部和参数jar中对应的cookie(参数jar可以为nil)
+	//结果值的状态码和头部来自主文档的响应,其中的Request字段对应渲染结束时所在的url(可能因为跳转而与请求不同)
+	Render(httpReq *http.Request, jar http.CookieJar) (*http.Response, error)
+	//关闭渲染器
+	Close() error
+}
+
+//判断请求是否需要被渲染
+//设置了渲染器但是没有设置RenderURLs时,所有请求都会被渲染
+func (args DownloaderArgs) needRender(reqUrl *url.URL) bool {
+	if args.Renderer == nil {
+		return false
+	}
+	if len(args.RenderURLs) == 0 {
+		return true
+	}
+	for _, pattern := range args.RenderURLs {
+		if pattern.MatchString(reqUrl.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+//根据渲染出的HTML生成响应
+//参数statusCode和header来自主文档的响应,状态码为0时视为200.
+//渲染出的HTML已经是解码之后的UTF-8文本,所以Content-Type中的字符集会被改为utf-8,
+//与原始响应体有关的头部(如Content-Length和Content-Encoding)会被删除
+func newRenderedResponse(httpReq *http.Request, finalUrl string, statusCode int, header http.Header, html []byte) (*http.Response, error) {
+	req := httpReq
+	if finalUrl != "" && finalUrl != httpReq.URL.String() {
+		parsed, err := url.Parse(finalUrl)
+		if err != nil {
+			return nil, err
+		}
+		req = new(http.Request)
+		*req = *httpReq
+		req.URL = parsed
+	}
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	renderedHeader := make(http.Header, len(header)+1)
+	for k, v := range header {
+		renderedHeader[k] = append([]string(nil), v...)
+	}
+	for _, key := range []string{"Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		renderedHeader.Del(key)
+	}
+	mediaType, params, err := mime.ParseMediaType(renderedHeader.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/html", make(map[string]string)
+	}
+	params["charset"] = "utf-8"
+	renderedHeader.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        renderedHeader,
+		Body:          ioutil.NopCloser(bytes.NewReader(html)),
+		ContentLength: int64(len(html)),
+		Request:       req,
+	}, nil
+}
+
+//静态渲染器,直接返回事先设置好的HTML
+//它不需要浏览器,可以在测试和调试时代替真正的渲染器
+type staticRenderer struct {
+	//url到HTML的映射,创建之后不会再被修改
+	pages map[string]string
+}
+
+//创建静态渲染器
+//参数pages的键为url,值为该url渲染之后的HTML
+func NewStaticRenderer(pages map[string]string) Renderer {
+	copied := make(map[string]string, len(pages))
+	for k, v := range pages {
+		copied[k] = v
+	}
+	return &staticRenderer{pages: copied}
+}
+
+func (renderer *staticRenderer) Render(httpReq *http.Request, jar http.CookieJar) (*http.Response, error) {
+	html, ok := renderer.pages[httpReq.URL.String()]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No rendered page for url '%s'!", httpReq.URL))
+	}
+	return newRenderedResponse(httpReq, "", http.StatusOK, nil, []byte(html))
+}
+
+func (renderer *staticRenderer) Close() error {
+	return nil
+}
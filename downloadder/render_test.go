@@ -0,0 +1,234 @@
+package downloadder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//模拟的渲染器,记录渲染时收到的请求头部和cookie,并返回事先设置好的响应
+type fakeRenderer struct {
+	//返回的状态码
+	statusCode int
+	//返回的头部
+	header http.Header
+	//返回的HTML
+	html string
+	//渲染时收到的请求头部
+	gotHeader http.Header
+	//渲染时cookie容器中对应url的cookie
+	gotCookies []*http.Cookie
+}
+
+func (renderer *fakeRenderer) Render(httpReq *http.Request, jar http.CookieJar) (*http.Response, error) {
+	renderer.gotHeader = httpReq.Header
+	if jar != nil {
+		renderer.gotCookies = jar.Cookies(httpReq.URL)
+	}
+	return newRenderedResponse(httpReq, "", renderer.statusCode, renderer.header, []byte(renderer.html))
+}
+
+func (renderer *fakeRenderer) Close() error {
+	return nil
+}
+
+//通过Download使用静态渲染器
+func TestDownloadStaticRenderer(t *testing.T) {
+	pageUrl := "http://example.com/app"
+	html := "<html><body><a href=\"/next\">next</a></body></html>"
+	recorder := &fakeRecorder{}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{
+		Filter:   NewContentFilter(DefaultSkipExts, []string{"text/html"}, 1<<20, false),
+		Renderer: NewStaticRenderer(map[string]string{pageUrl: html}),
+		Recorder: recorder,
+	})
+	httpReq, _ := http.NewRequest("GET", pageUrl, nil)
+	resp, err := dl.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		t.Fatalf("An error occurs when downloading: %s", err)
+	}
+	if rendered, _ := resp.Meta()[base.META_RENDERED].(bool); !rendered {
+		t.Errorf("The response is not marked as rendered: %v", resp.Meta())
+	}
+	httpResp := resp.HttpResp()
+	if httpResp.StatusCode != http.StatusOK {
+		t.Errorf("The status code is %d, but expected %d", httpResp.StatusCode, http.StatusOK)
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("The content type is %q, but expected %q", contentType, "text/html; charset=utf-8")
+	}
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	if string(body) != html {
+		t.Errorf("The body is %q, but expected %q", body, html)
+	}
+	//渲染结果不能被当作网络上收到的响应体记录下来
+	if len(recorder.bodies) != 0 || len(recorder.rendered) != 1 || string(recorder.rendered[0]) != html {
+		t.Errorf("The recorded responses are %q and the recorded renderings are %q", recorder.bodies, recorder.rendered)
+	}
+}
+
+//渲染出的响应应带有主文档的状态码和头部,渲染器应收到请求的头部和cookie容器
+func TestDownloadRendererStatusAndSession(t *testing.T) {
+	pageUrl, _ := url.Parse("http://example.com/private")
+	jar, _ := NewCookieJar("")
+	jar.SetCookies(pageUrl, []*http.Cookie{{Name: "session", Value: "secret"}})
+	header := make(http.Header)
+	header.Set("Content-Type", "text/html; charset=gbk")
+	header.Set("Content-Length", "12345")
+	header.Set("X-Served-By", "backend-1")
+	renderer := &fakeRenderer{statusCode: http.StatusNotFound, header: header, html: "<html>not found</html>"}
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{Jar: jar, Renderer: renderer})
+	httpReq, _ := http.NewRequest("GET", pageUrl.String(), nil)
+	httpReq.Header.Set("Accept-Language", "zh-CN")
+	resp, err := dl.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		t.Fatalf("An error occurs when downloading: %s", err)
+	}
+	httpResp := resp.HttpResp()
+	if httpResp.StatusCode != http.StatusNotFound {
+		t.Errorf("The status code is %d, but expected %d", httpResp.StatusCode, http.StatusNotFound)
+	}
+	if httpResp.Header.Get("X-Served-By") != "backend-1" {
+		t.Errorf("The header of the main document is lost: %v", httpResp.Header)
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("The content type is %q, but expected %q", contentType, "text/html; charset=utf-8")
+	}
+	if httpResp.Header.Get("Content-Length") != "" {
+		t.Errorf("The content length of the original body is kept: %v", httpResp.Header)
+	}
+	if renderer.gotHeader.Get("Accept-Language") != "zh-CN" {
+		t.Errorf("The request header is not passed to the renderer: %v", renderer.gotHeader)
+	}
+	if len(renderer.gotCookies) != 1 || renderer.gotCookies[0].Value != "secret" {
+		t.Errorf("The cookies are not passed to the renderer: %v", renderer.gotCookies)
+	}
+}
+
+//请求的头部和cookie会被转换为DevTools协议的参数
+func TestCDPHeadersAndCookies(t *testing.T) {
+	pageUrl, _ := url.Parse("http://example.com/private")
+	header := make(http.Header)
+	header.Add("Accept", "text/html")
+	header.Add("Accept", "application/xhtml+xml")
+	headers := cdpHeaders(header)
+	if headers["Accept"] != "text/html, application/xhtml+xml" {
+		t.Errorf("The headers are %v", headers)
+	}
+	jar, _ := NewCookieJar("")
+	jar.SetCookies(pageUrl, []*http.Cookie{{Name: "session", Value: "secret"}})
+	cookies := cdpCookies(jar, pageUrl)
+	if len(cookies) != 1 || cookies[0]["name"] != "session" ||
+		cookies[0]["value"] != "secret" || cookies[0]["url"] != pageUrl.String() {
+		t.Errorf("The cookies are %v", cookies)
+	}
+	if cdpCookies(nil, pageUrl) != nil {
+		t.Errorf("The cookies of nil jar should be nil")
+	}
+}
+
+//启动模拟的DevTools调试地址,它会打开一个标签页并按照协议返回渲染结果
+//参数methods会收到每个被调用的方法
+func newDevtoolsServer(t *testing.T, pageUrl string, html string, methods chan<- string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	var server *httptest.Server
+	mux.HandleFunc("/json/new", func(w http.ResponseWriter, r *http.Request) {
+		wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/devtools/page/1"
+		json.NewEncoder(w).Encode(map[string]string{"id": "1", "webSocketDebuggerUrl": wsUrl})
+	})
+	mux.HandleFunc("/json/close/1", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/devtools/page/1", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failing: %s", err)
+			return
+		}
+		defer conn.Close()
+		//先发送一个ping,客户端应该自动回复
+		conn.WriteMessage(websocket.PingMessage, []byte("ping"))
+		for {
+			var msg cdpMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			methods <- msg.Method
+			result := map[string]interface{}{}
+			switch msg.Method {
+			case "Page.navigate":
+				result["frameId"] = "frame"
+				conn.WriteJSON(map[string]interface{}{
+					"method": "Network.responseReceived",
+					"params": map[string]interface{}{
+						"type":    "Document",
+						"frameId": "frame",
+						"response": map[string]interface{}{
+							"url":     pageUrl,
+							"status":  203,
+							"headers": map[string]string{"Content-Type": "text/html; charset=gbk", "X-Test": "a\nb"},
+						},
+					},
+				})
+				conn.WriteJSON(map[string]interface{}{"method": "Page.loadEventFired", "params": map[string]interface{}{}})
+			case "Runtime.evaluate":
+				result["result"] = map[string]interface{}{
+					"value": map[string]string{"url": pageUrl, "html": html},
+				}
+			}
+			if err := conn.WriteJSON(map[string]interface{}{"id": msg.Id, "result": result}); err != nil {
+				return
+			}
+		}
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+//通过WebSocket与DevTools协议通信完成渲染
+func TestCDPRenderer(t *testing.T) {
+	pageUrl := "http://example.com/app"
+	html := "<html><body>rendered</body></html>"
+	methods := make(chan string, 16)
+	server := newDevtoolsServer(t, pageUrl, html, methods)
+	defer server.Close()
+	renderer, err := NewCDPRenderer(server.URL, 5*time.Second, 0)
+	if err != nil {
+		t.Fatalf("Create renderer failing: %s", err)
+	}
+	httpReq, _ := http.NewRequest("GET", pageUrl, nil)
+	httpResp, err := renderer.Render(httpReq, nil)
+	if err != nil {
+		t.Fatalf("Render failing: %s", err)
+	}
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	if want := "<!DOCTYPE html>\n" + html; string(body) != want {
+		t.Errorf("The body is %q, want %q", body, want)
+	}
+	//状态码和头部来自主文档的响应,内容已经被转换为UTF-8
+	if httpResp.StatusCode != 203 {
+		t.Errorf("The status code is %d, want 203", httpResp.StatusCode)
+	}
+	if got := httpResp.Header.Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("The content type is %q", got)
+	}
+	if got := httpResp.Header["X-Test"]; len(got) != 2 {
+		t.Errorf("The header X-Test is %q, want 2 values", got)
+	}
+	close(methods)
+	var called []string
+	for method := range methods {
+		called = append(called, method)
+	}
+	want := []string{"Page.enable", "Network.enable", "Page.navigate", "Runtime.evaluate"}
+	if strings.Join(called, ",") != strings.Join(want, ",") {
+		t.Errorf("The called methods are %v, want %v", called, want)
+	}
+}
@@ -0,0 +1,111 @@
+package downloadder
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"summerWebCrawler/base"
+)
+
+//响应存储的接口类型
+//回放下载器从响应存储中查找响应,而不是访问网络,如按url索引的WARC归档
+type ResponseStore interface {
+	//查找与请求对应的响应,未找到时返回(nil, nil)
+	//响应的Request字段应该是参数httpReq,如果存储跟随了跳转,应该是以跳转之后的url为地址的副本.为nil时会被设置为httpReq
+	Lookup(httpReq *http.Request) (*http.Response, error)
+}
+
+//响应存储中找不到响应时的处理方式
+type MissPolicy int
+
+const (
+	//作为错误上报
+	MISS_ERROR MissPolicy = iota
+	//跳过该url,只做计数
+	MISS_SKIP
+	//交给网络下载器下载
+	MISS_NETWORK
+)
+
+func (policy MissPolicy) String() string {
+	switch policy {
+	case MISS_ERROR:
+		return "error"
+	case MISS_SKIP:
+		return "skip"
+	case MISS_NETWORK:
+		return "network"
+	}
+	return fmt.Sprintf("unknown(%d)", int(policy))
+}
+
+//回放下载器的实现类型
+type myReplayDownloader struct {
+	//Id
+	id uint32
+	//响应存储
+	store ResponseStore
+	//找不到响应时的处理方式
+	policy MissPolicy
+	//网络下载器,仅在处理方式为MISS_NETWORK时使用
+	network PageDownloader
+}
+
+//创建回放下载器
+//回放下载器从响应存储中取得响应,配合正常的调度器使用时可以离线地、确定地重新执行整个爬取流程,
+//如修改解析函数之后重新分析已归档的网页
+//参数network仅在参数policy为MISS_NETWORK时使用,为nil时会使用默认的网页下载器
+func NewReplayDownloader(store ResponseStore, policy MissPolicy, network PageDownloader) PageDownloader {
+	if policy == MISS_NETWORK && network == nil {
+		network = NewPageDownloader(nil)
+	}
+	return &myReplayDownloader{
+		id:      genDownloaderId(),
+		store:   store,
+		policy:  policy,
+		network: network,
+	}
+}
+
+//创建回放下载器的生成函数
+//找不到响应时交给网络的回放下载器会使用调度器提供的http客户端和附加参数创建网络下载器
+func NewReplayDownloaderGenerator(store ResponseStore, policy MissPolicy) PageDownloaderGenerator {
+	return func(client *http.Client, args DownloaderArgs) PageDownloader {
+		var network PageDownloader
+		if policy == MISS_NETWORK {
+			network = NewPageDownloaderWithArgs(client, args)
+		}
+		return NewReplayDownloader(store, policy, network)
+	}
+}
+
+func (dl *myReplayDownloader) Id() uint32 {
+	return dl.id
+}
+
+func (dl *myReplayDownloader) Download(req base.Request) (*base.Response, error) {
+	httpReq := req.HttpReq()
+	if dl.store == nil {
+		return nil, errors.New("The response store is invalid!")
+	}
+	httpResp, err := dl.store.Lookup(httpReq)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Lookup response failing: %s (requestUrl=%s)", err, httpReq.URL))
+	}
+	if httpResp == nil {
+		switch dl.policy {
+		case MISS_SKIP:
+			return nil, newSkipError(httpReq.URL, "the response is not in the store")
+		case MISS_NETWORK:
+			return dl.network.Download(req)
+		default:
+			return nil, errors.New(fmt.Sprintf("The response is not in the store! (requestUrl=%s)", httpReq.URL))
+		}
+	}
+	if httpResp.Request == nil {
+		httpResp.Request = httpReq
+	}
+	meta := req.Meta().Copy()
+	meta[base.META_REPLAYED] = true
+	return base.NewResponseWithMeta(httpResp, req.Depth(), meta), nil
+}
@@ -0,0 +1,119 @@
+package downloadder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"summerWebCrawler/base"
+	"summerWebCrawler/warc"
+	"testing"
+)
+
+//启动带有跳转和缓存头部的服务器
+//"/old"跳转到"/middle","/middle"跳转到"/new","/cached"带有验证器,收到匹配的If-None-Match时返回304
+func newArchiveServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("new page"))
+	})
+	mux.HandleFunc("/cached", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("cached page"))
+	})
+	return httptest.NewServer(mux)
+}
+
+//下载并返回响应
+func download(t *testing.T, dl PageDownloader, rawUrl string) *base.Response {
+	httpReq, _ := http.NewRequest("GET", rawUrl, nil)
+	resp, err := dl.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		t.Fatalf("An error occurs when downloading %s: %s", rawUrl, err)
+	}
+	return resp
+}
+
+//下载时写入WARC归档,再从归档中回放.跳转、缓存的响应和渲染的网页都能被回放
+func TestReplayArchiveRoundTrip(t *testing.T) {
+	server := newArchiveServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Create temp dir failing: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writer, err := warc.NewWriter(warc.NewWriterArgs(filepath.Join(dir, "crawl"), true, 0, nil))
+	if err != nil {
+		t.Fatalf("Create warc writer failing: %s", err)
+	}
+	renderedUrl := server.URL + "/app"
+	dl := NewPageDownloaderWithArgs(nil, DownloaderArgs{
+		Recorder:   writer,
+		Cache:      &fakeCache{entries: make(map[string]*CacheEntry)},
+		Renderer:   NewStaticRenderer(map[string]string{renderedUrl: "<html>rendered</html>"}),
+		RenderURLs: []*regexp.Regexp{regexp.MustCompile(`/app$`)},
+	})
+	resp := download(t, dl, server.URL+"/old")
+	if finalUrl := resp.HttpResp().Request.URL.String(); finalUrl != server.URL+"/new" {
+		t.Errorf("The final url is %s, want %s", finalUrl, server.URL+"/new")
+	}
+	resp.HttpResp().Body.Close()
+	//第二次下载时重新验证,响应来自缓存,不会被再次记录
+	for i := 0; i < 2; i++ {
+		resp = download(t, dl, server.URL+"/cached")
+		resp.HttpResp().Body.Close()
+	}
+	if status := resp.Meta()[base.META_CACHE_STATUS]; status != base.CACHE_REVALIDATED {
+		t.Errorf("The cache status is %v, want %s", status, base.CACHE_REVALIDATED)
+	}
+	download(t, dl, renderedUrl).HttpResp().Body.Close()
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close warc writer failing: %s", err)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "crawl-*.warc.gz"))
+	store, err := warc.NewStore(paths...)
+	if err != nil {
+		t.Fatalf("Create store failing: %s", err)
+	}
+	replay := NewReplayDownloader(store, MISS_SKIP, nil)
+	cases := []struct {
+		path     string
+		finalUrl string
+		body     string
+	}{
+		{"/old", server.URL + "/new", "new page"},
+		{"/middle", server.URL + "/new", "new page"},
+		{"/new", server.URL + "/new", "new page"},
+		{"/cached", server.URL + "/cached", "cached page"},
+		{"/app", renderedUrl, "<html>rendered</html>"},
+	}
+	for _, c := range cases {
+		resp := download(t, replay, server.URL+c.path)
+		httpResp := resp.HttpResp()
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		if string(body) != c.body {
+			t.Errorf("The replayed body of %s is %q, want %q", c.path, body, c.body)
+		}
+		if finalUrl := httpResp.Request.URL.String(); finalUrl != c.finalUrl {
+			t.Errorf("The replayed url of %s is %s, want %s", c.path, finalUrl, c.finalUrl)
+		}
+	}
+}
+
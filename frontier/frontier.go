@@ -0,0 +1,82 @@
+package frontier
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"summerWebCrawler/base"
+)
+
+//共享的爬取边界的接口类型
+//多个调度器(工作节点)通过它共享待抓取的请求和已见过的url的集合.
+//请求按照主机名的哈希值被划分到各个工作节点,同一个主机的请求总是由同一个工作节点抓取,
+//因此各工作节点自己的礼貌策略(如并发数和抓取间隔)仍然有效
+type Frontier interface {
+	//加入请求,已见过的url会被忽略,返回实际被加入的请求的数量
+	Push(entries []Entry) (int, error)
+	//为工作节点取出最多max个属于它的分区的请求
+	Pop(worker uint32, max int) ([]Entry, error)
+	//报告工作节点的状态,参数idle代表工作节点是否空闲,参数summary是它的摘要信息
+	//工作节点应定期报告,报告空闲即确认已取出的请求都已处理完毕.长时间不报告的工作节点的请求会被重新分配
+	Report(worker uint32, idle bool, summary string) error
+	//获得工作节点(分区)的数量,工作节点的编号应小于它
+	Workers() (uint32, error)
+	//判断整个爬取是否空闲,即已经加入过请求,所有分区都没有待抓取的请求且所有工作节点都已报告空闲
+	Idle() (bool, error)
+	//获取摘要信息,包含各工作节点报告的摘要信息
+	Summary() (string, error)
+	//关闭爬取边界(对于远程的爬取边界只会关闭连接)
+	Close() error
+}
+
+//爬取边界中的条目,即可以在进程之间传递的请求
+type Entry struct {
+	//url
+	URL string
+	//深度
+	Depth uint32
+	//元数据,只保留字符串、布尔和数值类型的值
+	Meta map[string]interface{}
+}
+
+//根据请求创建条目
+func NewEntry(req base.Request) Entry {
+	meta := make(map[string]interface{})
+	for k, v := range req.Meta() {
+		switch v.(type) {
+		case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			meta[k] = v
+		}
+	}
+	return Entry{URL: req.HttpReq().URL.String(), Depth: req.Depth(), Meta: meta}
+}
+
+//根据条目创建GET请求
+func (entry Entry) Request() (*base.Request, error) {
+	httpReq, err := http.NewRequest("GET", entry.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return base.NewRequestWithMeta(httpReq, entry.Depth, base.Meta(entry.Meta)), nil
+}
+
+//计算url所属的分区
+func Partition(rawUrl string, workers uint32) (uint32, error) {
+	if workers == 0 {
+		return 0, errors.New("The number of workers can not be 0!")
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return 0, err
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return 0, errors.New(fmt.Sprintf("The url '%s' has no host!", rawUrl))
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(host))
+	return hash.Sum32() % workers, nil
+}
@@ -0,0 +1,235 @@
+package frontier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//工作节点的状态
+type workerState struct {
+	//是否取出了请求且尚未报告空闲
+	busy bool
+	//最近一次报告的摘要信息
+	summary string
+	//最近一次报告的时间
+	reportedAt time.Time
+	//最近一次取出请求或者报告的时间,租约从这时开始计算
+	seenAt time.Time
+	//已被取出但还未被确认的条目,工作节点报告空闲时它们被确认
+	leased []Entry
+	//租约是否已过期
+	expired bool
+}
+
+//本地爬取边界的实现类型
+//它既可以被同一个进程中的多个调度器直接共享,也可以通过ServeFrontier提供给其他进程
+type myLocalFrontier struct {
+	//工作节点的数量
+	workers uint32
+	//每个分区的待抓取的条目
+	queues [][]Entry
+	//已见过的url的集合
+	seen map[string]bool
+	//工作节点的状态
+	states []workerState
+	//加入的条目的数量
+	pushed uint64
+	//取出的条目的数量
+	popped uint64
+	//租约的时长
+	leaseTimeout time.Duration
+	//因租约过期而被重新加入的条目的数量
+	requeued uint64
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var localFrontierSummaryTemplate = "{workers:%d, seen:%d, queued:%d, pushed:%d, popped:%d, requeued:%d, idle:%v}"
+
+//默认的租约时长
+var DefaultLeaseTimeout = time.Minute
+
+//创建本地爬取边界,参数workers代表工作节点(分区)的数量,租约时长为DefaultLeaseTimeout
+func NewLocalFrontier(workers uint32) (Frontier, error) {
+	return NewLocalFrontierWithLease(workers, DefaultLeaseTimeout)
+}
+
+//创建指定租约时长的本地爬取边界
+//被取出的条目被租给取出它们的工作节点,工作节点报告空闲时它们被确认.
+//工作节点在参数leaseTimeout内既没有取出请求也没有报告时,它的租约过期:
+//未被确认的条目会被重新加入它的分区,它也不再被视为忙碌.重新启动的工作节点会再次取出这些条目
+func NewLocalFrontierWithLease(workers uint32, leaseTimeout time.Duration) (Frontier, error) {
+	if workers == 0 {
+		return nil, errors.New("The number of workers can not be 0!")
+	}
+	if leaseTimeout <= 0 {
+		return nil, errors.New("The lease timeout must be greater than 0!")
+	}
+	return &myLocalFrontier{
+		workers:      workers,
+		queues:       make([][]Entry, workers),
+		seen:         make(map[string]bool),
+		states:       make([]workerState, workers),
+		leaseTimeout: leaseTimeout,
+	}, nil
+}
+
+func (frontier *myLocalFrontier) Workers() (uint32, error) {
+	return frontier.workers, nil
+}
+
+func (frontier *myLocalFrontier) Push(entries []Entry) (int, error) {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	if frontier.closed {
+		return 0, errors.New("The frontier has been closed!")
+	}
+	added := 0
+	for _, entry := range entries {
+		if frontier.seen[entry.URL] {
+			continue
+		}
+		partition, err := Partition(entry.URL, frontier.workers)
+		if err != nil {
+			return added, err
+		}
+		frontier.seen[entry.URL] = true
+		frontier.queues[partition] = append(frontier.queues[partition], entry)
+		added++
+	}
+	frontier.pushed += uint64(added)
+	return added, nil
+}
+
+func (frontier *myLocalFrontier) Pop(worker uint32, max int) ([]Entry, error) {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	if err := frontier.checkWorker(worker); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	frontier.expire(now)
+	state := &frontier.states[worker]
+	state.seenAt = now
+	state.expired = false
+	queue := frontier.queues[worker]
+	if max <= 0 || len(queue) == 0 {
+		return nil, nil
+	}
+	if max > len(queue) {
+		max = len(queue)
+	}
+	entries := make([]Entry, max)
+	copy(entries, queue)
+	frontier.queues[worker] = queue[max:]
+	frontier.popped += uint64(max)
+	//取出请求的工作节点在下一次报告空闲之前被视为忙碌
+	state.busy = true
+	state.leased = append(state.leased, entries...)
+	return entries, nil
+}
+
+//使过期的租约失效,调用方需持有锁
+func (frontier *myLocalFrontier) expire(now time.Time) {
+	for i := range frontier.states {
+		state := &frontier.states[i]
+		if !state.busy || now.Sub(state.seenAt) < frontier.leaseTimeout {
+			continue
+		}
+		//未被确认的条目排在分区的最前面
+		frontier.queues[i] = append(state.leased, frontier.queues[i]...)
+		frontier.requeued += uint64(len(state.leased))
+		state.leased = nil
+		state.busy = false
+		state.expired = true
+	}
+}
+
+func (frontier *myLocalFrontier) Report(worker uint32, idle bool, summary string) error {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	if err := frontier.checkWorker(worker); err != nil {
+		return err
+	}
+	now := time.Now()
+	frontier.expire(now)
+	state := &frontier.states[worker]
+	state.busy = !idle
+	state.summary = summary
+	state.reportedAt = now
+	state.seenAt = now
+	state.expired = false
+	//空闲的工作节点已经处理完了取出的所有条目
+	if idle {
+		state.leased = nil
+	}
+	return nil
+}
+
+//检查工作节点的编号,调用方需持有锁
+func (frontier *myLocalFrontier) checkWorker(worker uint32) error {
+	if frontier.closed {
+		return errors.New("The frontier has been closed!")
+	}
+	if worker >= frontier.workers {
+		return errors.New(fmt.Sprintf("The worker %d is out of range [0, %d)!", worker, frontier.workers))
+	}
+	return nil
+}
+
+func (frontier *myLocalFrontier) Idle() (bool, error) {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	frontier.expire(time.Now())
+	return frontier.idle(), nil
+}
+
+//判断是否空闲,调用方需持有锁
+//尚未加入任何请求时爬取还没有开始,不被视为空闲
+func (frontier *myLocalFrontier) idle() bool {
+	if frontier.pushed == 0 {
+		return false
+	}
+	for i := range frontier.queues {
+		if len(frontier.queues[i]) > 0 || frontier.states[i].busy {
+			return false
+		}
+	}
+	return true
+}
+
+func (frontier *myLocalFrontier) Summary() (string, error) {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	frontier.expire(time.Now())
+	queued := 0
+	for _, queue := range frontier.queues {
+		queued += len(queue)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(localFrontierSummaryTemplate,
+		frontier.workers, len(frontier.seen), queued, frontier.pushed, frontier.popped, frontier.requeued, frontier.idle()))
+	buffer.WriteByte('\n')
+	for i, state := range frontier.states {
+		if state.reportedAt.IsZero() {
+			buffer.WriteString(fmt.Sprintf("Worker %d (queued:%d): <not reported>\n", i, len(frontier.queues[i])))
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("Worker %d (queued:%d, busy:%v, leased:%d, expired:%v, reported %s ago):\n%s",
+			i, len(frontier.queues[i]), state.busy, len(state.leased), state.expired,
+			time.Since(state.reportedAt).Truncate(time.Millisecond), state.summary))
+	}
+	return buffer.String(), nil
+}
+
+func (frontier *myLocalFrontier) Close() error {
+	frontier.mutex.Lock()
+	defer frontier.mutex.Unlock()
+	frontier.closed = true
+	return nil
+}
@@ -0,0 +1,182 @@
+package frontier
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+//在回环地址上提供本地爬取边界,返回协调节点一方的爬取边界和连接它的函数
+func serveLocal(t *testing.T, workers uint32, leaseTimeout time.Duration) (Frontier, func() Frontier) {
+	local, err := NewLocalFrontierWithLease(workers, leaseTimeout)
+	if err != nil {
+		t.Fatalf("Create local frontier failing: %s", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failing: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go ServeFrontier(local, listener)
+	dial := func() Frontier {
+		remote, err := DialFrontier(listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial frontier failing: %s", err)
+		}
+		t.Cleanup(func() { remote.Close() })
+		return remote
+	}
+	return local, dial
+}
+
+//生成分别属于两个分区的url
+func partitionedUrls(t *testing.T, workers uint32, perWorker int) map[uint32][]string {
+	urls := make(map[uint32][]string)
+	for i := 0; len(urls[0]) < perWorker || len(urls[1]) < perWorker; i++ {
+		rawUrl := fmt.Sprintf("http://host%d.example.com/", i)
+		partition, err := Partition(rawUrl, workers)
+		if err != nil {
+			t.Fatalf("Partition failing: %s", err)
+		}
+		if len(urls[partition]) < perWorker {
+			urls[partition] = append(urls[partition], rawUrl)
+		}
+	}
+	return urls
+}
+
+//推入url
+func pushUrls(t *testing.T, frontier Frontier, urls []string) {
+	entries := make([]Entry, 0, len(urls))
+	for _, rawUrl := range urls {
+		entries = append(entries, Entry{URL: rawUrl})
+	}
+	if _, err := frontier.Push(entries); err != nil {
+		t.Fatalf("Push failing: %s", err)
+	}
+}
+
+//判断是否空闲
+func isIdle(t *testing.T, frontier Frontier) bool {
+	idle, err := frontier.Idle()
+	if err != nil {
+		t.Fatalf("Query idle failing: %s", err)
+	}
+	return idle
+}
+
+//两个工作节点各自取出并确认自己分区的请求之后,整个爬取变为空闲
+func TestFrontierTwoWorkers(t *testing.T) {
+	_, dial := serveLocal(t, 2, time.Minute)
+	worker0, worker1 := dial(), dial()
+	urls := partitionedUrls(t, 2, 3)
+	pushUrls(t, worker0, append(urls[0], urls[1]...))
+	//重复的url会被忽略
+	if added, err := worker1.Push([]Entry{{URL: urls[0][0]}}); err != nil || added != 0 {
+		t.Errorf("The duplicated url is added: added=%d, err=%v", added, err)
+	}
+	for worker, client := range []Frontier{worker0, worker1} {
+		entries, err := client.Pop(uint32(worker), 10)
+		if err != nil {
+			t.Fatalf("Pop failing: %s", err)
+		}
+		if len(entries) != 3 {
+			t.Errorf("Worker %d popped %d entries, but expected 3", worker, len(entries))
+		}
+		for _, entry := range entries {
+			if partition, _ := Partition(entry.URL, 2); partition != uint32(worker) {
+				t.Errorf("Worker %d popped the url %s of partition %d", worker, entry.URL, partition)
+			}
+		}
+	}
+	if isIdle(t, worker0) {
+		t.Errorf("The frontier is idle while the workers are busy")
+	}
+	worker0.Report(0, true, "done")
+	if isIdle(t, worker0) {
+		t.Errorf("The frontier is idle while worker 1 is busy")
+	}
+	worker1.Report(1, true, "done")
+	if !isIdle(t, worker0) {
+		t.Errorf("The frontier is not idle after all workers reported idle")
+	}
+}
+
+//停止报告的工作节点的租约过期之后,它未确认的请求会被重新加入,重新启动的工作节点可以再次取出它们
+func TestFrontierLeaseExpiry(t *testing.T) {
+	local, dial := serveLocal(t, 2, 100*time.Millisecond)
+	crashed := dial()
+	urls := partitionedUrls(t, 2, 2)
+	pushUrls(t, crashed, urls[0])
+	entries, err := crashed.Pop(0, 10)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Pop failing: entries=%v, err=%v", entries, err)
+	}
+	//工作节点崩溃,既不报告也不关闭连接
+	if isIdle(t, local) {
+		t.Errorf("The frontier is idle before the lease expires")
+	}
+	time.Sleep(150 * time.Millisecond)
+	restarted := dial()
+	again, err := restarted.Pop(0, 10)
+	if err != nil {
+		t.Fatalf("Pop failing: %s", err)
+	}
+	if len(again) != 2 || again[0].URL != entries[0].URL || again[1].URL != entries[1].URL {
+		t.Errorf("The requeued entries are %v, but expected %v", again, entries)
+	}
+	//持续报告的工作节点的租约不会过期
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		if err := restarted.Report(0, false, "working"); err != nil {
+			t.Fatalf("Report failing: %s", err)
+		}
+	}
+	if more, _ := restarted.Pop(0, 10); len(more) != 0 {
+		t.Errorf("The entries of a live worker are requeued: %v", more)
+	}
+	restarted.Report(0, true, "done")
+	if !isIdle(t, local) {
+		t.Errorf("The frontier is not idle after the restarted worker reported idle")
+	}
+}
+
+//报告忙碌之后停止报告的工作节点在租约过期之后不再被视为忙碌
+func TestFrontierExpiredWorkerNotBusy(t *testing.T) {
+	local, dial := serveLocal(t, 2, 100*time.Millisecond)
+	client := dial()
+	urls := partitionedUrls(t, 2, 1)
+	pushUrls(t, client, urls[1])
+	if entries, _ := client.Pop(1, 10); len(entries) != 1 {
+		t.Fatalf("Pop failing: %v", entries)
+	}
+	//另一个工作节点报告忙碌之后崩溃,它没有取出任何请求
+	if err := client.Report(0, false, "working"); err != nil {
+		t.Fatalf("Report failing: %s", err)
+	}
+	client.Report(1, true, "done")
+	if isIdle(t, local) {
+		t.Errorf("The frontier is idle while worker 0 is busy")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !isIdle(t, local) {
+		t.Errorf("The frontier is not idle after the lease of worker 0 expired")
+	}
+}
+
+//超出范围的工作节点编号
+func TestFrontierWorkerOutOfRange(t *testing.T) {
+	_, dial := serveLocal(t, 2, time.Minute)
+	client := dial()
+	workers, err := client.Workers()
+	if err != nil || workers != 2 {
+		t.Errorf("The number of workers is %d (err=%v), but expected 2", workers, err)
+	}
+	if _, err := client.Pop(2, 10); err == nil {
+		t.Errorf("Pop with an out of range worker should fail")
+	}
+	if err := client.Report(2, true, ""); err == nil {
+		t.Errorf("Report with an out of range worker should fail")
+	}
+}
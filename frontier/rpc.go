@@ -0,0 +1,132 @@
+package frontier
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+)
+
+//爬取边界的rpc服务名
+const rpcServiceName = "Frontier"
+
+//Pop方法的rpc参数
+type PopArgs struct {
+	Worker uint32
+	Max    int
+}
+
+//Report方法的rpc参数
+type ReportArgs struct {
+	Worker  uint32
+	Idle    bool
+	Summary string
+}
+
+//爬取边界的rpc服务
+//net/rpc要求方法的形式为func (t *T) Method(args A, reply *R) error
+type frontierService struct {
+	frontier Frontier
+}
+
+func (service *frontierService) Push(entries []Entry, added *int) error {
+	n, err := service.frontier.Push(entries)
+	*added = n
+	return err
+}
+
+func (service *frontierService) Pop(args PopArgs, entries *[]Entry) error {
+	popped, err := service.frontier.Pop(args.Worker, args.Max)
+	*entries = popped
+	return err
+}
+
+func (service *frontierService) Report(args ReportArgs, reply *bool) error {
+	*reply = true
+	return service.frontier.Report(args.Worker, args.Idle, args.Summary)
+}
+
+func (service *frontierService) Workers(args int, workers *uint32) error {
+	result, err := service.frontier.Workers()
+	*workers = result
+	return err
+}
+
+func (service *frontierService) Idle(args int, idle *bool) error {
+	result, err := service.frontier.Idle()
+	*idle = result
+	return err
+}
+
+func (service *frontierService) Summary(args int, summary *string) error {
+	result, err := service.frontier.Summary()
+	*summary = result
+	return err
+}
+
+//通过TCP提供爬取边界,该方法会一直阻塞到监听器被关闭
+//协调节点通常用本地爬取边界调用该方法,工作节点再用DialFrontier连接它
+func ServeFrontier(frontier Frontier, listener net.Listener) error {
+	if frontier == nil {
+		return errors.New("The frontier is invalid!")
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, &frontierService{frontier: frontier}); err != nil {
+		return err
+	}
+	server.Accept(listener)
+	return nil
+}
+
+//远程爬取边界的实现类型
+type myRemoteFrontier struct {
+	//rpc客户端
+	client *rpc.Client
+}
+
+//连接由ServeFrontier提供的爬取边界
+func DialFrontier(address string) (Frontier, error) {
+	client, err := rpc.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &myRemoteFrontier{client: client}, nil
+}
+
+func (frontier *myRemoteFrontier) Push(entries []Entry) (int, error) {
+	var added int
+	err := frontier.client.Call(rpcServiceName+".Push", entries, &added)
+	return added, err
+}
+
+func (frontier *myRemoteFrontier) Pop(worker uint32, max int) ([]Entry, error) {
+	var entries []Entry
+	err := frontier.client.Call(rpcServiceName+".Pop", PopArgs{Worker: worker, Max: max}, &entries)
+	return entries, err
+}
+
+func (frontier *myRemoteFrontier) Report(worker uint32, idle bool, summary string) error {
+	var reply bool
+	return frontier.client.Call(rpcServiceName+".Report", ReportArgs{Worker: worker, Idle: idle, Summary: summary}, &reply)
+}
+
+func (frontier *myRemoteFrontier) Workers() (uint32, error) {
+	var workers uint32
+	err := frontier.client.Call(rpcServiceName+".Workers", 0, &workers)
+	return workers, err
+}
+
+func (frontier *myRemoteFrontier) Idle() (bool, error) {
+	var idle bool
+	err := frontier.client.Call(rpcServiceName+".Idle", 0, &idle)
+	return idle, err
+}
+
+func (frontier *myRemoteFrontier) Summary() (string, error) {
+	var summary string
+	err := frontier.client.Call(rpcServiceName+".Summary", 0, &summary)
+	return summary, err
+}
+
+func (frontier *myRemoteFrontier) Close() error {
+	return frontier.client.Close()
+}
@@ -0,0 +1,285 @@
+package itempipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"summerWebCrawler/base"
+	"sync"
+)
+
+//CSV输出器
+//列是根据条目中出现过的键自动发现的.出现新的列时,当前文件会被重写:表头中会加入新的列,
+//已写出的行会在新的列上补上空的单元格.文件只会因为超过尺寸上限而轮转
+type csvSink struct {
+	//参数
+	args SinkArgs
+	//输出文件
+	file *rotatingFile
+	//列的列表
+	columns []string
+	//列的集合
+	columnSet map[string]bool
+	//缓冲中的条目
+	buffer []base.Item
+	//已被写出或者在缓冲中的条目的ID的集合
+	ids map[string]bool
+	//已写出的条目的数量
+	written uint64
+	//因已被写出过而被跳过的条目的数量
+	skipped uint64
+	//还未被报告的被丢弃的条目的错误
+	pending []error
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var csvSummaryTemplate = "{type:csv, file:%s, files:%d, columns:%d, written:%d, skipped:%d, buffered:%d, closed:%v}"
+
+//创建CSV输出器
+//已存在的输出文件不会被改写,新的条目会被写入新的文件.这些文件中的条目ID会被载入,对应的条目不会被再次写出
+func NewCSVSink(args SinkArgs) (ItemSink, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	ids, err := loadWrittenIds(args.Path(), loadCSVIds)
+	if err != nil {
+		return nil, err
+	}
+	sink := &csvSink{
+		args:      args,
+		columns:   make([]string, 0),
+		columnSet: make(map[string]bool),
+		buffer:    make([]base.Item, 0, args.BatchSize()),
+		ids:       ids,
+	}
+	sink.file = newRotatingFile(args.Path(), args.MaxFileSize(), sink.header)
+	return sink, nil
+}
+
+func (sink *csvSink) Process(item base.Item) (result base.Item, err error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil, errSinkClosed
+	}
+	if id := item.ID(); id != "" {
+		if sink.ids[id] {
+			//已被写出过的条目不会被重复写出
+			sink.skipped++
+			return item, nil
+		}
+		sink.ids[id] = true
+	}
+	sink.buffer = append(sink.buffer, copyItem(item))
+	if uint32(len(sink.buffer)) >= sink.args.BatchSize() {
+		current := len(sink.buffer) - 1
+		failed, err := sink.flush()
+		itemErr := settleErrors(failed, current, &sink.pending)
+		if err != nil {
+			return item, err
+		}
+		if itemErr != nil {
+			return item, itemErr
+		}
+	}
+	return item, nil
+}
+
+func (sink *csvSink) Flush() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return errSinkClosed
+	}
+	failed, err := sink.flush()
+	return takeErrors(failed, err, &sink.pending)
+}
+
+//写出缓冲中的条目,调用方需持有锁
+//任何条目都可以被转换为CSV记录,因此结果值failed总是空的,err是使剩余的条目无法被写出的错误
+func (sink *csvSink) flush() (failed map[int]error, err error) {
+	failed = make(map[int]error)
+	if len(sink.buffer) == 0 {
+		return
+	}
+	//发现新的列
+	newColumns := make([]string, 0)
+	for _, item := range sink.buffer {
+		for k := range item {
+			if !sink.columnSet[k] {
+				sink.columnSet[k] = true
+				newColumns = append(newColumns, k)
+			}
+		}
+	}
+	if len(newColumns) > 0 {
+		sort.Strings(newColumns)
+		sink.columns = append(sink.columns, newColumns...)
+		//已写入的表头不再完整,用新的表头重写当前文件
+		if err := sink.file.rewrite(sink.extendRows); err != nil {
+			//重写失败时撤销新的列,下一次写出时再重试
+			sink.columns = sink.columns[:len(sink.columns)-len(newColumns)]
+			for _, column := range newColumns {
+				delete(sink.columnSet, column)
+			}
+			return failed, err
+		}
+	}
+	for i, item := range sink.buffer {
+		row := make([]string, len(sink.columns))
+		for j, column := range sink.columns {
+			row[j] = formatCell(item[column])
+		}
+		if err := sink.file.write(encodeCSVRecord(row)); err != nil {
+			//写文件失败时保留未写出的条目,等待下一次写出
+			sink.buffer = sink.buffer[i:]
+			return failed, err
+		}
+		sink.written++
+	}
+	sink.buffer = sink.buffer[:0]
+	return failed, sink.file.sync()
+}
+
+//用当前的表头替换文件内容中的表头,并在已写出的行的末尾为新的列补上空的单元格
+func (sink *csvSink) extendRows(content []byte) ([]byte, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	buffer.Write(sink.header())
+	for i, record := range records {
+		//第一行是旧的表头
+		if i == 0 {
+			continue
+		}
+		row := make([]string, len(sink.columns))
+		copy(row, record)
+		buffer.Write(encodeCSVRecord(row))
+	}
+	return buffer.Bytes(), nil
+}
+
+//生成表头
+func (sink *csvSink) header() []byte {
+	return encodeCSVRecord(sink.columns)
+}
+
+func (sink *csvSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil
+	}
+	failed, err := sink.flush()
+	err = takeErrors(failed, err, &sink.pending)
+	sink.closed = true
+	if closeErr := sink.file.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (sink *csvSink) Summary() string {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return fmt.Sprintf(csvSummaryTemplate,
+		sink.file.currentPath(), sink.file.fileCount, len(sink.columns),
+		sink.written, sink.skipped, len(sink.buffer), sink.closed)
+}
+
+//读取CSV文件中ITEM_ID_KEY列的值
+//被中断的写入留下的不完整的最后一条记录(没有换行符或者引号没有闭合)会被截掉
+func loadCSVIds(path string) ([]string, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	endsWithNewline := false
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return nil, err
+		}
+		endsWithNewline = last[0] == '\n'
+	}
+	ids := make([]string, 0)
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	idColumn := -1
+	//最后一条完整的记录之后的位置
+	var complete int64
+	for header := true; ; header = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return ids, nil
+		}
+		offset := reader.InputOffset()
+		if offset == size && (err != nil || !endsWithNewline) {
+			return ids, truncateFile(file, complete)
+		}
+		if err != nil {
+			return nil, err
+		}
+		complete = offset
+		if header {
+			for i, column := range record {
+				if column == base.ITEM_ID_KEY {
+					idColumn = i
+				}
+			}
+			continue
+		}
+		if idColumn >= 0 && idColumn < len(record) && record[idColumn] != "" {
+			ids = append(ids, record[idColumn])
+		}
+	}
+}
+
+//把一行编码为CSV记录
+func encodeCSVRecord(row []string) []byte {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write(row)
+	writer.Flush()
+	return buffer.Bytes()
+}
+
+//把值转换为单元格的内容,复合类型会被编码为JSON
+func formatCell(value interface{}) string {
+	value = normalizeValue(value)
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(content)
+}
@@ -0,0 +1,179 @@
+package itempipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"summerWebCrawler/base"
+	"sync"
+)
+
+//JSON Lines输出器,每个条目占一行
+type jsonLinesSink struct {
+	//参数
+	args SinkArgs
+	//输出文件
+	file *rotatingFile
+	//缓冲中的条目
+	buffer []base.Item
+	//已被写出或者在缓冲中的条目的ID的集合
+	ids map[string]bool
+	//已写出的条目的数量
+	written uint64
+	//因已被写出过而被跳过的条目的数量
+	skipped uint64
+	//因无法被写出而被丢弃的条目的数量
+	failed uint64
+	//还未被报告的被丢弃的条目的错误
+	pending []error
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var jsonLinesSummaryTemplate = "{type:jsonl, file:%s, files:%d, written:%d, skipped:%d, failed:%d, buffered:%d, closed:%v}"
+
+//创建JSON Lines输出器
+//已存在的输出文件不会被改写,新的条目会被写入新的文件.这些文件中的条目ID会被载入,对应的条目不会被再次写出
+func NewJSONLinesSink(args SinkArgs) (ItemSink, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	ids, err := loadWrittenIds(args.Path(), loadJSONLinesIds)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLinesSink{
+		args:   args,
+		file:   newRotatingFile(args.Path(), args.MaxFileSize(), nil),
+		buffer: make([]base.Item, 0, args.BatchSize()),
+		ids:    ids,
+	}, nil
+}
+
+//读取JSON Lines文件中的条目ID,被中断的写入留下的没有换行符的最后一行会被截掉
+func loadJSONLinesIds(path string) ([]string, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ids := make([]string, 0)
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return ids, truncateFile(file, offset)
+			}
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		var item base.Item
+		if json.Unmarshal(line, &item) == nil {
+			if id := item.ID(); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+}
+
+func (sink *jsonLinesSink) Process(item base.Item) (result base.Item, err error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil, errSinkClosed
+	}
+	if id := item.ID(); id != "" {
+		if sink.ids[id] {
+			//已被写出过的条目不会被重复写出
+			sink.skipped++
+			return item, nil
+		}
+		sink.ids[id] = true
+	}
+	sink.buffer = append(sink.buffer, copyItem(item))
+	if uint32(len(sink.buffer)) >= sink.args.BatchSize() {
+		current := len(sink.buffer) - 1
+		failed, err := sink.flush()
+		itemErr := settleErrors(failed, current, &sink.pending)
+		if err != nil {
+			return item, err
+		}
+		if itemErr != nil {
+			return item, itemErr
+		}
+	}
+	return item, nil
+}
+
+func (sink *jsonLinesSink) Flush() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return errSinkClosed
+	}
+	failed, err := sink.flush()
+	return takeErrors(failed, err, &sink.pending)
+}
+
+//写出缓冲中的条目,调用方需持有锁
+//结果值failed中是被丢弃的条目在缓冲中的序号和错误,err是使剩余的条目无法被写出的错误
+func (sink *jsonLinesSink) flush() (failed map[int]error, err error) {
+	failed = make(map[int]error)
+	if len(sink.buffer) == 0 {
+		return
+	}
+	for i, item := range sink.buffer {
+		line, err := json.Marshal(normalizeItem(item))
+		if err != nil {
+			//无法被编码的条目会被丢弃
+			failed[i] = itemWriteError(item, err)
+			delete(sink.ids, item.ID())
+			sink.failed++
+			continue
+		}
+		if err := sink.file.write(append(line, '\n')); err != nil {
+			//写文件失败时保留未写出的条目,等待下一次写出
+			sink.buffer = sink.buffer[i:]
+			return failed, err
+		}
+		sink.written++
+	}
+	sink.buffer = sink.buffer[:0]
+	return failed, sink.file.sync()
+}
+
+func (sink *jsonLinesSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil
+	}
+	failed, err := sink.flush()
+	err = takeErrors(failed, err, &sink.pending)
+	sink.closed = true
+	if closeErr := sink.file.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (sink *jsonLinesSink) Summary() string {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return fmt.Sprintf(jsonLinesSummaryTemplate,
+		sink.file.currentPath(), sink.file.fileCount,
+		sink.written, sink.skipped, sink.failed, len(sink.buffer), sink.closed)
+}
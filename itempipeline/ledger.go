@@ -0,0 +1,182 @@
+package itempipeline
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"summerWebCrawler/base"
+	"sync"
+)
+
+//条目账本的接口类型
+//账本记录已被持久地处理过的条目的ID.条目处理管道会跳过账本中已存在的条目.
+//条目的ID只在检查点才被记入账本,而条目输出器在缓冲满时就会写出条目,因此进程在两个检查点之间意外退出时,
+//上一个检查点之后写出的条目不在账本中,它们会在下次运行时被再次交给条目输出器.
+//条目输出器按条目ID(base.ITEM_ID_KEY)跳过已被写出过的条目,被中断的写入留下的不完整的记录会在输出器创建时被截掉,
+//所以账本和条目输出器一起提供"恰好一次"的保证:重新运行的爬取既不会产生重复的条目,也不会遗漏条目.
+//自定义的条目输出器需要同样按条目ID去重,见ItemSink
+type ItemLedger interface {
+	//判断条目是否已被持久地处理过
+	Seen(id string) bool
+	//把条目标记为已被持久地处理过,该方法返回时标记已被持久化
+	Mark(ids ...string) error
+	//获得账本中的条目的数量
+	Count() uint64
+	//关闭账本
+	Close() error
+	//获取摘要信息
+	Summary() string
+}
+
+//基于文件的条目账本,每行记录一个条目ID
+type fileLedger struct {
+	//文件路径
+	path string
+	//文件
+	file *os.File
+	//已记录的条目ID的集合
+	ids map[string]bool
+	//启动时从文件中载入的条目ID的数量
+	loaded uint64
+	//是否已关闭
+	closed bool
+	//读写锁
+	rwmutex sync.RWMutex
+}
+
+var ledgerSummaryTemplate = "{file:%s, loaded:%d, total:%d, closed:%v}"
+
+//创建基于文件的条目账本
+//文件已存在时会先载入其中的条目ID,之后标记的条目ID会被追加到该文件中
+func NewItemLedger(path string) (ItemLedger, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, errors.New("The ledger path can not be empty!")
+	}
+	ledger := &fileLedger{path: path, ids: make(map[string]bool)}
+	if err := ledger.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ledger.file = file
+	return ledger, nil
+}
+
+//从文件中载入条目ID
+func (ledger *fileLedger) load() error {
+	file, err := os.Open(ledger.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		//被中断的写入可能留下不完整的最后一行,它对应的条目会被重新处理
+		id := strings.TrimSpace(scanner.Text())
+		if id != "" {
+			ledger.ids[id] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.New(fmt.Sprintf("Load ledger '%s' failing: %s", ledger.path, err))
+	}
+	ledger.loaded = uint64(len(ledger.ids))
+	return nil
+}
+
+func (ledger *fileLedger) Seen(id string) bool {
+	ledger.rwmutex.RLock()
+	defer ledger.rwmutex.RUnlock()
+	return ledger.ids[id]
+}
+
+func (ledger *fileLedger) Mark(ids ...string) error {
+	ledger.rwmutex.Lock()
+	defer ledger.rwmutex.Unlock()
+	if ledger.closed {
+		return errors.New("The item ledger has been closed!")
+	}
+	var buf strings.Builder
+	newIds := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || ledger.ids[id] {
+			continue
+		}
+		buf.WriteString(id)
+		buf.WriteByte('\n')
+		newIds = append(newIds, id)
+	}
+	if len(newIds) == 0 {
+		return nil
+	}
+	if _, err := ledger.file.WriteString(buf.String()); err != nil {
+		return err
+	}
+	if err := ledger.file.Sync(); err != nil {
+		return err
+	}
+	for _, id := range newIds {
+		ledger.ids[id] = true
+	}
+	return nil
+}
+
+func (ledger *fileLedger) Count() uint64 {
+	ledger.rwmutex.RLock()
+	defer ledger.rwmutex.RUnlock()
+	return uint64(len(ledger.ids))
+}
+
+func (ledger *fileLedger) Close() error {
+	ledger.rwmutex.Lock()
+	defer ledger.rwmutex.Unlock()
+	if ledger.closed {
+		return nil
+	}
+	ledger.closed = true
+	return ledger.file.Close()
+}
+
+func (ledger *fileLedger) Summary() string {
+	ledger.rwmutex.RLock()
+	defer ledger.rwmutex.RUnlock()
+	return fmt.Sprintf(ledgerSummaryTemplate, ledger.path, ledger.loaded, len(ledger.ids), ledger.closed)
+}
+
+//生成条目ID
+//条目ID由条目的来源url和条目内容的摘要构成.以"_"开头的键(条目类型除外)是管道附加的信息,不参与计算
+func NewItemID(item base.Item) string {
+	content := make(base.Item, len(item))
+	for k, v := range item {
+		if strings.HasPrefix(k, "_") && k != base.ITEM_TYPE_KEY {
+			continue
+		}
+		content[k] = v
+	}
+	//json编码会按键排序,因此相同内容的条目总会得到相同的结果
+	encoded, err := json.Marshal(normalizeItem(content))
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%v", content))
+	}
+	sourceUrl, _ := item[base.ITEM_SOURCE_URL_KEY].(string)
+	hash := sha1.New()
+	hash.Write([]byte(sourceUrl))
+	hash.Write([]byte{0})
+	hash.Write(encoded)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//生成被拆分出的条目的ID
+func splitItemID(parentId string, index int) string {
+	return fmt.Sprintf("%s#%d", parentId, index)
+}
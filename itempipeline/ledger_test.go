@@ -0,0 +1,201 @@
+package itempipeline
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//读取所有输出文件中的条目ID,重复的ID会出现多次
+type readIds func(t *testing.T, path string) []string
+
+//依次读取每个输出文件
+func readRotated(t *testing.T, path string, read func(path string) []string) []string {
+	ids := make([]string, 0)
+	for seq := 0; ; seq++ {
+		filePath := rotatedPath(path, seq)
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			return ids
+		}
+		ids = append(ids, read(filePath)...)
+	}
+}
+
+func readJSONLinesIds(t *testing.T, path string) []string {
+	return readRotated(t, path, func(path string) []string {
+		ids := make([]string, 0)
+		for _, line := range readLines(t, path) {
+			var item base.Item
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("The line %q of %s is invalid: %s", line, path, err)
+			}
+			ids = append(ids, item.ID())
+		}
+		return ids
+	})
+}
+
+func readCSVIds(t *testing.T, path string) []string {
+	return readRotated(t, path, func(path string) []string {
+		file, _ := os.Open(path)
+		defer file.Close()
+		records, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatalf("The file %s is invalid: %s", path, err)
+		}
+		ids := make([]string, 0)
+		idColumn := -1
+		for i, record := range records {
+			for j, column := range record {
+				if i == 0 && column == base.ITEM_ID_KEY {
+					idColumn = j
+				}
+			}
+			if i > 0 {
+				ids = append(ids, record[idColumn])
+			}
+		}
+		return ids
+	})
+}
+
+func readSQLiteIds(t *testing.T, path string) []string {
+	return readRotated(t, path, func(path string) []string {
+		db, _ := sql.Open("sqlite3", path)
+		defer db.Close()
+		rows, err := db.Query(`SELECT "_id" FROM "items"`)
+		if err != nil {
+			t.Fatalf("Query %s failing: %s", path, err)
+		}
+		defer rows.Close()
+		ids := make([]string, 0)
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		return ids
+	})
+}
+
+//生成参数count个内容不同的条目,每次调用都会得到相同的条目ID
+func newCrawlItems(count int) []base.Item {
+	items := make([]base.Item, count)
+	for i := range items {
+		items[i] = base.Item{base.ITEM_SOURCE_URL_KEY: "http://example.com/", "n": i, "text": fmt.Sprintf("item,\"%d\"\nend", i)}
+	}
+	return items
+}
+
+//使用dir中的账本和输出文件运行管道,发送前checkpointAfter个条目之后会设置检查点
+//参数crash为true时,所有条目被处理之后不关闭管道,以此模拟进程意外退出:
+//缓冲中的条目丢失,最后一个检查点之后写出的条目不在账本中
+func runWithLedger(t *testing.T, dir string, create func(args SinkArgs) (ItemSink, error),
+	items []base.Item, checkpointAfter int, crash bool) ItemSink {
+	ledger, err := NewItemLedger(filepath.Join(dir, "items.ledger"))
+	if err != nil {
+		t.Fatalf("Create ledger failing: %s", err)
+	}
+	sink, err := create(NewSinkArgs(filepath.Join(dir, "items"), 2, 0))
+	if err != nil {
+		t.Fatalf("Create sink failing: %s", err)
+	}
+	pipeline := NewItemPipeline([]ItemStage{NewItemStage("a", traceProcessor("a"), 2, 2)}, ErrorPolicy{})
+	pipeline.AddSink(sink)
+	pipeline.SetLedger(ledger, 0)
+	wait := collectErrors(pipeline)
+	for i, item := range items {
+		if i == checkpointAfter {
+			waitFor(t, "the items processed", func() bool { return pipeline.ProcessingNumber() == 0 })
+			if err := pipeline.Checkpoint(); err != nil {
+				t.Fatalf("Checkpoint failing: %s", err)
+			}
+		}
+		pipeline.Send(item)
+	}
+	waitFor(t, "the items processed", func() bool { return pipeline.ProcessingNumber() == 0 })
+	if crash {
+		ledger.Close()
+		return sink
+	}
+	if errs := pipeline.Close(); len(errs) > 0 {
+		t.Fatalf("An error occurs when closing: %v", errs)
+	}
+	if errs := wait(); len(errs) > 0 {
+		t.Fatalf("The errors are %v", errs)
+	}
+	return sink
+}
+
+//进程在两个检查点之间意外退出之后重新运行,输出中既没有重复的条目也没有遗漏的条目
+func TestLedgerCrashRecovery(t *testing.T) {
+	kinds := []struct {
+		name string
+		ext  string
+		//创建输出器
+		create func(args SinkArgs) (ItemSink, error)
+		//被中断的写入留下的不完整的记录,为空时不追加
+		partial string
+		//读取输出中的条目ID
+		read readIds
+	}{
+		{"jsonl", ".jsonl", NewJSONLinesSink, `{"_id":"partial","n":`, readJSONLinesIds},
+		{"csv", ".csv", NewCSVSink, "\"partial,\"\"quoted\nfield", readCSVIds},
+		{"csv unquoted", ".csv", NewCSVSink, "partial,record", readCSVIds},
+		{"sqlite", ".db", func(args SinkArgs) (ItemSink, error) { return NewSQLiteSink(args, "items") }, "", readSQLiteIds},
+	}
+	for _, kind := range kinds {
+		dir, cleanup := newTestDir(t)
+		create := func(args SinkArgs) (ItemSink, error) {
+			return kind.create(NewSinkArgs(args.Path()+kind.ext, args.BatchSize(), args.MaxFileSize()))
+		}
+		path := filepath.Join(dir, "items"+kind.ext)
+		//前3个条目被记入账本,之后写出的4个条目不在账本中,最后一个条目还在缓冲中
+		runWithLedger(t, dir, create, newCrawlItems(8), 3, true)
+		if ids := kind.read(t, path); len(ids) != 7 {
+			t.Fatalf("%s: %d items are written before crash, want 7", kind.name, len(ids))
+		}
+		if kind.partial != "" {
+			file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			file.WriteString(kind.partial)
+			file.Close()
+		}
+		sink := runWithLedger(t, dir, create, newCrawlItems(8), 0, false)
+		ids := kind.read(t, path)
+		counts := make(map[string]int)
+		for _, id := range ids {
+			counts[id]++
+		}
+		for _, item := range newCrawlItems(8) {
+			id := NewItemID(item)
+			if counts[id] != 1 {
+				t.Errorf("%s: the item %v is written %d times", kind.name, item["n"], counts[id])
+			}
+			delete(counts, id)
+		}
+		if len(counts) > 0 {
+			t.Errorf("%s: unexpected items are written: %v", kind.name, counts)
+		}
+		//账本跳过了3个条目,输出器跳过了已写出但不在账本中的4个条目
+		if summary := sink.Summary(); !contains(summary, "written:1,", "skipped:4,") {
+			t.Errorf("%s: the summary is %s", kind.name, summary)
+		}
+		cleanup()
+	}
+}
+
+//判断字符串是否包含所有的子串
+func contains(s string, substrs ...string) bool {
+	for _, substr := range substrs {
+		if !strings.Contains(s, substr) {
+			return false
+		}
+	}
+	return true
+}
@@ -5,103 +5,651 @@ import (
 	"errors"
 	"fmt"
 	"sync/atomic"
+	"sync"
+	"time"
 )
 
 //条目处理管道的接口类型
 type ItemPipeline interface {
 	//发送条目
+	//条目会被放入第一个阶段的队列,队列已满时该方法会被阻塞.
+	//结果值中只包含条目被放入队列之前出现的错误(如校验错误),处理过程中出现的错误会被发送到错误通道
 	Send(item base.Item) []error
 	//FailFast 方法会返回一个布尔值.该值标识当前的条目处理管道是否是快速失败的
 	//快速失败:只要对某个条目的处理流程在某一个步骤上出错
 	//那么条目处理管道就会忽略掉后续的所有处理步骤并报告错误
+	//该值由创建管道时指定的默认错误策略决定,只对没有设置错误策略的阶段有效
 	FailFast() bool
-	//设置是否快速失败
-	SetFailFast(failFast bool)
-	//获得已发送丶已接受和已处理的条目的计数值
-	//更确切的说,作为结果值的切片总会有3个元素值.这三个值会分别代表前述的3个计数
+	//获得默认的错误策略
+	DefaultErrorPolicy() ErrorPolicy
+	//获得已发送丶已接受丶已处理和已丢弃的条目的计数值
+	//更确切的说,作为结果值的切片总会有4个元素值.这四个值会分别代表前述的4个计数.
+	//被拆分出的条目会被分别计入已处理或已丢弃的计数
 	Count() []uint64
 	//获取正在被处理的条目的数量
 	ProcessingNumber() uint64
+	//设置模式注册表和拒收处理器
+	//设置之后,条目在被条目处理器处理之前会先根据模式注册表进行校验.
+	//校验失败的条目不会再被处理,它会被交给拒收处理器(可以为nil),同时Send方法会返回校验错误
+	SetValidator(registry SchemaRegistry, rejectSink ProcessItem)
+	//添加条目输出器,应在发送条目之前调用
+	//条目输出器会作为单独的阶段被放在所有阶段之后,按照添加的顺序依次处理条目
+	AddSink(sink ItemSink)
+	//获得错误通道,各个阶段在处理条目时出现的错误都会被发送到该通道
+	//使用方应该持续地从该通道接收错误,否则工作goroutine会被阻塞.该通道会在管道关闭之后被关闭
+	ErrorChan() <-chan error
+	//关闭条目处理管道
+	//该方法会等待已被接受的条目全部处理完毕,然后写出所有条目输出器缓冲中的条目.
+	//设置了条目账本时,该方法还会设置最后一个检查点并关闭账本
+	Close() []error
+	//设置条目账本和检查点的时间间隔,应在发送条目之前调用
+	//设置之后,条目在被接受时会被赋予ID(见NewItemID),账本中已存在的条目和本次运行中重复的条目会被跳过.
+	//检查点会先写出所有条目输出器缓冲中的条目,然后把已经处理完毕的条目记入账本.
+	//上一个检查点之后写出的条目在意外退出后会被再次交给条目输出器,由条目输出器按条目ID跳过,见ItemLedger
+	//参数checkpointInterval为0时只在调用Checkpoint方法和关闭管道时设置检查点
+	SetLedger(ledger ItemLedger, checkpointInterval time.Duration)
+	//设置检查点
+	Checkpoint() error
 	//获取摘要信息
 	Summary() string
 }
 
 //条目处理管道的实现类型
 type myItemPipeline struct {
-	//条目处理器的列表
-	itemProcessors []ProcessItem
-	//表示处理器是否需要快速失败的标志位
-	failFast bool
+	//主序列中的第一个阶段
+	head *stageRuntime
+	//主序列中的最后一个阶段
+	tail *stageRuntime
+	//所有阶段(包括路由分支中的阶段)的运行时的列表
+	//排在后面的阶段只会从排在前面的阶段接收条目,关闭管道时会按照该顺序关闭各阶段
+	stages []*stageRuntime
+	//默认的错误策略,被用于没有设置错误策略的阶段.创建之后不会再改变
+	defaultPolicy ErrorPolicy
 	//已被发送的条目的数量
 	sent uint64
 	//已被接受的条目的数量
 	accepted uint64
 	//已被处理条目的数量
 	processed uint64
+	//已被丢弃的条目的数量
+	dropped uint64
 	//正在被处理的条目的数量
 	processingNumer uint64
+	//模式注册表
+	registry SchemaRegistry
+	//拒收处理器
+	rejectSink ProcessItem
+	//校验失败的条目的数量
+	rejected uint64
+	//条目输出器的列表
+	sinks []ItemSink
+	//条目账本
+	ledger ItemLedger
+	//检查点的时间间隔
+	checkpointInterval time.Duration
+	//本次运行中已被接受的条目的ID的集合
+	acceptedIds map[string]bool
+	//已处理完毕但还未被记入账本的条目的ID的列表
+	completedIds []string
+	//因重复而被跳过的条目的数量
+	duplicated uint64
+	//保护acceptedIds和completedIds的互斥锁
+	ledgerMutex sync.Mutex
+	//保证同一时刻只有一个检查点在进行
+	checkpointMutex sync.Mutex
+	//停止定时检查点的信号
+	checkpointStop chan struct{}
+	//等待定时检查点的goroutine结束
+	checkpointWg sync.WaitGroup
+	//错误通道
+	errorCh chan error
+	//保证工作goroutine只被启动一次
+	startOnce sync.Once
+	//是否已启动
+	started bool
+	//是否已关闭
+	closed bool
+	//读写锁.发送条目时持有读锁,关闭管道时持有写锁
+	rwmutex sync.RWMutex
 }
 
-var summaryTemplate = "failFast: %v, processorNumber: %d," +
-	" sent: %d, accepted: %d, processed: %d, processingNumber: %d"
+//条目中记录字段违例的键,只会出现在交给拒收处理器的条目中
+const ITEM_VIOLATIONS_KEY = "_violations"
+
+var (
+	//错误通道的长度
+	errorChanLen = 100
+	summaryTemplate = "defaultPolicy: %s, stageNumber: %d," +
+		" sent: %d, accepted: %d, processed: %d, dropped: %d, rejected: %d, duplicated: %d, processingNumber: %d," +
+		" stages: %v, sinks: %v, ledger: %s"
+)
 
 //创建条目处理管道
-func NewItemPipeline(itemProcessors []ProcessItem) ItemPipeline {
-	if itemProcessors == nil {
-		//这里如果发现条目处理器列表为空直接panic结束程序
-		panic(errors.New(fmt.Sprintln("Invalid item processor list!")))
+//参数stages代表条目处理阶段的序列,条目会按照顺序经过每一个阶段.
+//路由阶段会把条目交给匹配的分支,条目经过分支中的阶段之后再回到路由阶段的下一个阶段
+//参数defaultPolicy代表默认的错误策略,它会被用于没有设置错误策略的阶段.
+//零值(ERROR_POLICY_DEFAULT)表示快速失败
+func NewItemPipeline(stages []ItemStage, defaultPolicy ErrorPolicy) ItemPipeline {
+	if stages == nil {
+		//这里如果发现阶段列表为空直接panic结束程序
+		panic(errors.New(fmt.Sprintln("Invalid item stage list!")))
+	}
+	if err := defaultPolicy.Check(); err != nil {
+		panic(errors.New(fmt.Sprintf("Invalid default error policy: %s\n", err)))
+	}
+	if defaultPolicy.kind == ERROR_POLICY_DEFAULT {
+		defaultPolicy = NewFailFastPolicy()
+	}
+	ip := &myItemPipeline{
+		stages:        make([]*stageRuntime, 0, len(stages)),
+		defaultPolicy: defaultPolicy,
+		errorCh:       make(chan error, errorChanLen),
 	}
-	innerItemProcessors := make([]ProcessItem, 0)
 	//过滤参数避免异常
-	for i, ip := range itemProcessors {
-		if ip == nil {
-			panic(errors.New(fmt.Sprintf("Invalid item processor[%d]!\n", i)))
+	for i, stage := range stages {
+		if err := stage.Check(); err != nil {
+			panic(errors.New(fmt.Sprintf("Invalid item stage[%d]: %s\n", i, err)))
+		}
+	}
+	ip.head, ip.tail = ip.buildChain(stages, nil)
+	return ip
+}
+
+//为阶段的序列创建运行时并依次链接起来,结果值为序列中的第一个和最后一个阶段的运行时
+//参数exit代表序列所属的路由阶段,主序列的exit为nil
+func (maPool *myItemPipeline) buildChain(stages []ItemStage, exit *stageRuntime) (head *stageRuntime, tail *stageRuntime) {
+	for _, stage := range stages {
+		runtime := maPool.newRuntime(stage, exit)
+		if tail == nil {
+			head = runtime
+		} else {
+			tail.next = runtime
+		}
+		tail = runtime
+	}
+	return
+}
+
+//创建阶段的运行时并登记,路由阶段的各个分支也会被一并创建
+//没有设置错误策略的阶段会使用默认的错误策略
+func (maPool *myItemPipeline) newRuntime(stage ItemStage, exit *stageRuntime) *stageRuntime {
+	if stage.policy.kind == ERROR_POLICY_DEFAULT {
+		stage.policy = maPool.defaultPolicy
+	}
+	runtime := newStageRuntime(stage)
+	runtime.exit = exit
+	maPool.stages = append(maPool.stages, runtime)
+	maPool.addSinkToClose(stage.sink)
+	maPool.addSinkToClose(stage.policy.deadLetter)
+	for _, route := range stage.routes {
+		branchHead, _ := maPool.buildChain(route.Stages, runtime)
+		runtime.routes = append(runtime.routes, &routeRuntime{route: route, head: branchHead})
+	}
+	return runtime
+}
+
+//登记需要在管道关闭时关闭的条目输出器,同一个输出器只会被登记一次
+func (maPool *myItemPipeline) addSinkToClose(sink ItemSink) {
+	if sink == nil {
+		return
+	}
+	for _, existing := range maPool.sinks {
+		if existing == sink {
+			return
+		}
+	}
+	maPool.sinks = append(maPool.sinks, sink)
+}
+
+//启动所有阶段的工作goroutine
+func (maPool *myItemPipeline) start() {
+	for _, stage := range maPool.stages {
+		for j := uint32(0); j < stage.stage.workers; j++ {
+			stage.wg.Add(1)
+			go maPool.work(stage)
+		}
+	}
+	if maPool.ledger != nil && maPool.checkpointInterval > 0 {
+		maPool.checkpointStop = make(chan struct{})
+		maPool.checkpointWg.Add(1)
+		go maPool.checkpointLoop()
+	}
+	maPool.started = true
+}
+
+//定时设置检查点
+func (maPool *myItemPipeline) checkpointLoop() {
+	defer maPool.checkpointWg.Done()
+	ticker := time.NewTicker(maPool.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-maPool.checkpointStop:
+			return
+		case <-ticker.C:
+			if err := maPool.Checkpoint(); err != nil {
+				maPool.errorCh <- errors.New(fmt.Sprintf("Checkpoint failing: %s", err))
+			}
+		}
+	}
+}
+
+//工作goroutine,从阶段的队列中接收并处理条目
+func (maPool *myItemPipeline) work(stage *stageRuntime) {
+	defer stage.wg.Done()
+	switch {
+	case stage.stage.Batched():
+		maPool.workBatch(stage)
+		return
+	case stage.stage.Routed():
+		maPool.workRoute(stage)
+		return
+	case stage.stage.splitter != nil:
+		maPool.workSplit(stage)
+		return
+	}
+	for env := range stage.queue {
+		result, err := stage.processWithRetry(env.item)
+		if err == ErrDropItem {
+			maPool.drop(env)
+			continue
+		}
+		if err != nil && !maPool.handleError(stage, env, err) {
+			continue
+		}
+		if result != nil {
+			env.item = result
+		}
+		maPool.forwardAfter(stage, env)
+	}
+}
+
+//路由阶段的工作goroutine,把条目交给第一个匹配的分支
+func (maPool *myItemPipeline) workRoute(stage *stageRuntime) {
+	for env := range stage.queue {
+		route, err := stage.route(env.item)
+		if err != nil && !maPool.handleError(stage, env, err) {
+			continue
+		}
+		if route != nil && route.head != nil {
+			route.head.queue <- env
+			continue
+		}
+		maPool.forwardAfter(stage, env)
+	}
+}
+
+//拆分阶段的工作goroutine,拆分出的条目会分别进入下一个阶段
+func (maPool *myItemPipeline) workSplit(stage *stageRuntime) {
+	for env := range stage.queue {
+		results, err := stage.splitWithRetry(env.item)
+		if err == ErrDropItem {
+			maPool.drop(env)
+			continue
+		}
+		if err != nil {
+			if maPool.handleError(stage, env, err) {
+				maPool.forwardAfter(stage, env)
+			}
+			continue
+		}
+		//多出的条目要先计入正在被处理的条目的数量
+		atomic.AddUint64(&maPool.processingNumer, uint64(len(results)-1))
+		for i, result := range results {
+			child := &envelope{item: result}
+			if env.id != "" {
+				//被拆分出的条目的ID由原条目的ID和序号构成,已被处理过的部分不会被重复处理
+				child.id = splitItemID(env.id, i)
+				result[base.ITEM_ID_KEY] = child.id
+				if maPool.ledger.Seen(child.id) {
+					maPool.skipDuplicate()
+					continue
+				}
+			}
+			maPool.forwardAfter(stage, child)
+		}
+	}
+}
+
+//批量处理的工作goroutine
+//条目凑满一批,等待超过写出间隔或者队列被关闭时,已收集的条目会被一起处理
+func (maPool *myItemPipeline) workBatch(stage *stageRuntime) {
+	batchSize := int(stage.stage.batchSize)
+	batch := make([]*envelope, 0, batchSize)
+	ticker := time.NewTicker(stage.stage.flushInterval)
+	defer ticker.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		items := make([]base.Item, len(batch))
+		for i, env := range batch {
+			items[i] = env.item
 		}
-		innerItemProcessors = append(innerItemProcessors, ip)
+		results, errs := stage.processBatchWithRetry(items)
+		for i, env := range batch {
+			if errs != nil && errs[i] == ErrDropItem {
+				maPool.drop(env)
+				continue
+			}
+			if errs != nil && errs[i] != nil {
+				err := errors.New(fmt.Sprintf("Item %d/%d of the batch failed (stage=%s): %s",
+					i+1, len(batch), stage.stage.name, errs[i]))
+				if !maPool.handleError(stage, env, err) {
+					continue
+				}
+			}
+			if results != nil && results[i] != nil {
+				env.item = results[i]
+			}
+			maPool.forwardAfter(stage, env)
+		}
+		batch = make([]*envelope, 0, batchSize)
+	}
+	for {
+		select {
+		case env, ok := <-stage.queue:
+			if !ok {
+				//管道关闭时处理剩余的条目
+				flush()
+				return
+			}
+			batch = append(batch, env)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+//按照阶段的错误策略处理出错的条目,结果值表示条目是否还应该进入后续的阶段
+//重试已经在调用该方法之前完成,这里只处理重试仍然失败的情况
+func (maPool *myItemPipeline) handleError(stage *stageRuntime, env *envelope, err error) bool {
+	policy := stage.stage.policy
+	switch policy.finalKind() {
+	case ERROR_POLICY_DEAD_LETTER:
+		//交给死信输出器的是条目的副本,其中附带了错误信息
+		deadItem := copyItem(env.item)
+		deadItem[ITEM_ERROR_KEY] = err.Error()
+		deadItem[ITEM_ERROR_STAGE_KEY] = stage.stage.name
+		atomic.AddUint64(&stage.deadLettered, 1)
+		if _, sinkErr := policy.deadLetter.Process(deadItem); sinkErr != nil {
+			maPool.errorCh <- errors.New(fmt.Sprintf("%s (dead letter sink error: %s)", err, sinkErr))
+			maPool.finish()
+			return false
+		}
+		maPool.complete(env)
+		return false
+	case ERROR_POLICY_CONTINUE:
+		maPool.errorCh <- err
+		return true
+	default:
+		maPool.errorCh <- err
+		maPool.finish()
+		return false
 	}
-	return &myItemPipeline{itemProcessors: itemProcessors}
+}
+
+//把条目交给stage之后的阶段
+//所在序列已经结束时,条目会回到该序列所属的路由阶段的下一个阶段.所有阶段都已经处理过的条目会被标记为处理完毕
+func (maPool *myItemPipeline) forwardAfter(stage *stageRuntime, env *envelope) {
+	for current := stage; current != nil; current = current.exit {
+		if current.next != nil {
+			current.next.queue <- env
+			return
+		}
+	}
+	maPool.complete(env)
+}
+
+//标记一个条目成功地经过了所有阶段,它的ID会在下一个检查点被记入账本
+func (maPool *myItemPipeline) complete(env *envelope) {
+	maPool.recordCompleted(env)
+	maPool.finish()
+}
+
+//记录已处理完毕的条目的ID
+func (maPool *myItemPipeline) recordCompleted(env *envelope) {
+	if env.id == "" {
+		return
+	}
+	maPool.ledgerMutex.Lock()
+	maPool.completedIds = append(maPool.completedIds, env.id)
+	maPool.ledgerMutex.Unlock()
+}
+
+//标记一个条目处理完毕
+func (maPool *myItemPipeline) finish() {
+	atomic.AddUint64(&maPool.processed, 1)
+	atomic.AddUint64(&maPool.processingNumer, ^uint64(0))
+}
+
+//标记一个条目被丢弃,被丢弃的条目同样会被记入账本
+func (maPool *myItemPipeline) drop(env *envelope) {
+	maPool.recordCompleted(env)
+	atomic.AddUint64(&maPool.dropped, 1)
+	atomic.AddUint64(&maPool.processingNumer, ^uint64(0))
+}
+
+//跳过一个重复的条目
+func (maPool *myItemPipeline) skipDuplicate() {
+	atomic.AddUint64(&maPool.duplicated, 1)
+	atomic.AddUint64(&maPool.processingNumer, ^uint64(0))
+}
+
+//为条目赋予ID并检查它是否重复,结果值为条目ID和条目是否重复
+func (maPool *myItemPipeline) identify(item base.Item) (string, bool) {
+	id := item.ID()
+	if id == "" {
+		id = NewItemID(item)
+		item[base.ITEM_ID_KEY] = id
+	}
+	if maPool.ledger.Seen(id) {
+		return id, true
+	}
+	maPool.ledgerMutex.Lock()
+	defer maPool.ledgerMutex.Unlock()
+	if maPool.acceptedIds[id] {
+		return id, true
+	}
+	maPool.acceptedIds[id] = true
+	return id, false
 }
 
 func (maPool *myItemPipeline) Send(item base.Item) []error {
-	atomic.AddUint64(&maPool.processingNumer, 1)
-	defer atomic.AddUint64(&maPool.processingNumer, ^uint64(0))
 	atomic.AddUint64(&maPool.sent, 1)
 	errs := make([]error, 0)
 	if item == nil {
 		errs = append(errs, errors.New("The item is invalid!"))
 		return errs
 	}
-	var currentItem base.Item = item
+	maPool.rwmutex.RLock()
+	defer maPool.rwmutex.RUnlock()
+	if maPool.closed {
+		errs = append(errs, errors.New("The item pipeline has been closed!"))
+		return errs
+	}
+	maPool.startOnce.Do(maPool.start)
+	env := &envelope{item: item}
+	if maPool.ledger != nil {
+		id, duplicated := maPool.identify(item)
+		if duplicated {
+			atomic.AddUint64(&maPool.duplicated, 1)
+			return errs
+		}
+		env.id = id
+	}
 	atomic.AddUint64(&maPool.accepted, 1)
-	for _, itemProcessor := range maPool.itemProcessors {
-		processedItem, err := itemProcessor(currentItem)
-		if err != nil {
+	atomic.AddUint64(&maPool.processingNumer, 1)
+	//先校验再处理
+	if err := maPool.validate(item); err != nil {
+		errs = append(errs, err)
+		maPool.finish()
+		return errs
+	}
+	if maPool.head == nil {
+		maPool.finish()
+		return errs
+	}
+	maPool.head.queue <- env
+	return errs
+}
+
+//校验条目,校验失败的条目会被交给拒收处理器
+func (maPool *myItemPipeline) validate(item base.Item) error {
+	if maPool.registry == nil {
+		return nil
+	}
+	verr := maPool.registry.Validate(item)
+	if verr == nil {
+		return nil
+	}
+	atomic.AddUint64(&maPool.rejected, 1)
+	if maPool.rejectSink != nil {
+		//交给拒收处理器的是条目的副本,其中附带了字段违例的列表
+		rejectedItem := make(base.Item, len(item)+1)
+		for k, v := range item {
+			rejectedItem[k] = v
+		}
+		violations := make([]string, 0, len(verr.Violations()))
+		for _, violation := range verr.Violations() {
+			violations = append(violations, violation.String())
+		}
+		rejectedItem[ITEM_VIOLATIONS_KEY] = violations
+		if _, err := maPool.rejectSink(rejectedItem); err != nil {
+			return errors.New(fmt.Sprintf("%s (reject sink error: %s)", verr, err))
+		}
+	}
+	return verr
+}
+
+func (maPool *myItemPipeline) SetValidator(registry SchemaRegistry, rejectSink ProcessItem) {
+	maPool.registry = registry
+	maPool.rejectSink = rejectSink
+}
+
+func (maPool *myItemPipeline) AddSink(sink ItemSink) {
+	if sink == nil {
+		panic(errors.New("Invalid item sink!"))
+	}
+	maPool.rwmutex.Lock()
+	defer maPool.rwmutex.Unlock()
+	if maPool.started || maPool.closed {
+		panic(errors.New("The item sink must be added before sending items!"))
+	}
+	runtime := maPool.newRuntime(NewSinkStage(sink, 1, 0), nil)
+	if maPool.tail == nil {
+		maPool.head = runtime
+	} else {
+		maPool.tail.next = runtime
+	}
+	maPool.tail = runtime
+}
+
+func (maPool *myItemPipeline) SetLedger(ledger ItemLedger, checkpointInterval time.Duration) {
+	maPool.rwmutex.Lock()
+	defer maPool.rwmutex.Unlock()
+	if maPool.started || maPool.closed {
+		panic(errors.New("The item ledger must be set before sending items!"))
+	}
+	maPool.ledger = ledger
+	maPool.checkpointInterval = checkpointInterval
+	maPool.acceptedIds = make(map[string]bool)
+}
+
+func (maPool *myItemPipeline) Checkpoint() error {
+	if maPool.ledger == nil {
+		return nil
+	}
+	maPool.checkpointMutex.Lock()
+	defer maPool.checkpointMutex.Unlock()
+	//先取出已处理完毕的条目的ID再写出条目输出器,这样被记入账本的条目一定已被写出
+	maPool.ledgerMutex.Lock()
+	ids := maPool.completedIds
+	maPool.completedIds = nil
+	maPool.ledgerMutex.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	err := maPool.flushSinks()
+	if err == nil {
+		err = maPool.ledger.Mark(ids...)
+	}
+	if err != nil {
+		//未能记入账本的条目ID留待下一个检查点
+		maPool.ledgerMutex.Lock()
+		maPool.completedIds = append(ids, maPool.completedIds...)
+		maPool.ledgerMutex.Unlock()
+	}
+	return err
+}
+
+//写出所有条目输出器缓冲中的条目
+func (maPool *myItemPipeline) flushSinks() error {
+	for _, sink := range maPool.sinks {
+		if err := sink.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (maPool *myItemPipeline) ErrorChan() <-chan error {
+	return maPool.errorCh
+}
+
+func (maPool *myItemPipeline) Close() []error {
+	maPool.rwmutex.Lock()
+	if maPool.closed {
+		maPool.rwmutex.Unlock()
+		return nil
+	}
+	maPool.closed = true
+	maPool.rwmutex.Unlock()
+	//逐个关闭各阶段的队列,前一个阶段的工作goroutine全部结束之后才能关闭后一个阶段的队列
+	for _, stage := range maPool.stages {
+		close(stage.queue)
+		stage.wg.Wait()
+	}
+	if maPool.checkpointStop != nil {
+		close(maPool.checkpointStop)
+		maPool.checkpointWg.Wait()
+	}
+	close(maPool.errorCh)
+	errs := make([]error, 0)
+	//最后一个检查点,写出失败的条目不会被记入账本
+	if err := maPool.Checkpoint(); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("Checkpoint failing: %s", err)))
+	}
+	for _, sink := range maPool.sinks {
+		if err := sink.Close(); err != nil {
 			errs = append(errs, err)
-			if maPool.failFast {
-				break
-			}
 		}
-		if processedItem != nil {
-			currentItem = processedItem
+	}
+	if maPool.ledger != nil {
+		if err := maPool.ledger.Close(); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	atomic.AddUint64(&maPool.processed, 1)
 	return errs
 }
 
 func (maPool *myItemPipeline) FailFast() bool {
-	return maPool.failFast
+	return maPool.defaultPolicy.finalKind() == ERROR_POLICY_FAIL_FAST
 }
 
-func (maPool *myItemPipeline) SetFailFast(failFast bool) {
-	maPool.failFast = failFast
+func (maPool *myItemPipeline) DefaultErrorPolicy() ErrorPolicy {
+	return maPool.defaultPolicy
 }
 
 func (maPool *myItemPipeline) Count() []uint64 {
-	counts := make([]uint64, 3)
+	counts := make([]uint64, 4)
 	counts[0] = atomic.LoadUint64(&maPool.sent)
 	counts[1] = atomic.LoadUint64(&maPool.accepted)
 	counts[2] = atomic.LoadUint64(&maPool.processed)
+	counts[3] = atomic.LoadUint64(&maPool.dropped)
 	return counts
 }
 
@@ -111,8 +659,23 @@ func (maPool *myItemPipeline) ProcessingNumber() uint64 {
 
 func (ip *myItemPipeline) Summary() string {
 	counts := ip.Count()
+	//路由分支中的阶段的摘要会被包含在路由阶段的摘要中
+	stageSummaries := make([]string, 0, len(ip.stages))
+	for stage := ip.head; stage != nil; stage = stage.next {
+		stageSummaries = append(stageSummaries, stage.summary())
+	}
+	sinkSummaries := make([]string, 0, len(ip.sinks))
+	for _, sink := range ip.sinks {
+		sinkSummaries = append(sinkSummaries, sink.Summary())
+	}
+	ledgerSummary := "none"
+	if ip.ledger != nil {
+		ledgerSummary = ip.ledger.Summary()
+	}
 	summary := fmt.Sprintf(summaryTemplate,
-		ip.failFast, len(ip.itemProcessors),
-		counts[0], counts[1], counts[2], ip.ProcessingNumber())
+		ip.defaultPolicy, len(ip.stages),
+		counts[0], counts[1], counts[2], counts[3],
+		atomic.LoadUint64(&ip.rejected), atomic.LoadUint64(&ip.duplicated), ip.ProcessingNumber(),
+		stageSummaries, sinkSummaries, ledgerSummary)
 	return summary
 }
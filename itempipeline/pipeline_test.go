@@ -0,0 +1,466 @@
+package itempipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"summerWebCrawler/base"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//在内存中保存条目的条目输出器
+type memSink struct {
+	//已写入的条目
+	items []base.Item
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+func (sink *memSink) Process(item base.Item) (result base.Item, err error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil, errSinkClosed
+	}
+	sink.items = append(sink.items, item)
+	return item, nil
+}
+
+func (sink *memSink) Flush() error {
+	return nil
+}
+
+func (sink *memSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.closed = true
+	return nil
+}
+
+func (sink *memSink) Summary() string {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return fmt.Sprintf("{type:mem, written:%d}", len(sink.items))
+}
+
+//获得已写入的条目中键key的值,按写入的顺序排列
+func (sink *memSink) values(key string) []string {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	values := make([]string, len(sink.items))
+	for i, item := range sink.items {
+		values[i] = fmt.Sprint(item[key])
+	}
+	return values
+}
+
+//在后台接收管道的错误,返回的函数会等待错误通道被关闭并返回收到的所有错误
+func collectErrors(pipeline ItemPipeline) func() []error {
+	done := make(chan []error, 1)
+	go func() {
+		errs := make([]error, 0)
+		for err := range pipeline.ErrorChan() {
+			errs = append(errs, err)
+		}
+		done <- errs
+	}()
+	return func() []error {
+		return <-done
+	}
+}
+
+//把名称追加到条目的trace键中的条目处理器
+func traceProcessor(name string) ProcessItem {
+	return func(item base.Item) (base.Item, error) {
+		trace, _ := item["trace"].(string)
+		item["trace"] = trace + name
+		return item, nil
+	}
+}
+
+//前failures次调用都失败的条目处理器
+func flakyProcessor(failures int32) ProcessItem {
+	var calls int32
+	return func(item base.Item) (base.Item, error) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			return nil, errors.New("flaky")
+		}
+		return traceProcessor("f")(item)
+	}
+}
+
+//运行管道:发送条目,关闭管道,返回输出器中的条目和收到的错误
+func runPipeline(t *testing.T, pipeline ItemPipeline, items ...base.Item) (*memSink, []error) {
+	sink := &memSink{}
+	pipeline.AddSink(sink)
+	wait := collectErrors(pipeline)
+	for _, item := range items {
+		if errs := pipeline.Send(item); len(errs) > 0 {
+			t.Fatalf("An error occurs when sending %v: %v", item, errs)
+		}
+	}
+	if errs := pipeline.Close(); len(errs) > 0 {
+		t.Fatalf("An error occurs when closing: %v", errs)
+	}
+	return sink, wait()
+}
+
+//每种错误策略下出错的条目的去向
+func TestPipelineErrorPolicies(t *testing.T) {
+	deadLetter := &memSink{}
+	cases := []struct {
+		name string
+		//出错的阶段的错误策略
+		policy ErrorPolicy
+		//管道的默认错误策略
+		defaultPolicy ErrorPolicy
+		//前几次调用失败
+		failures int32
+		//输出器中的条目的trace
+		want string
+		//错误通道中的错误的数量
+		wantErrors int
+	}{
+		{"default is fail fast", ErrorPolicy{}, ErrorPolicy{}, 1, "", 1},
+		{"fail fast", NewFailFastPolicy(), NewContinuePolicy(), 1, "", 1},
+		{"continue", NewContinuePolicy(), ErrorPolicy{}, 1, "ab", 1},
+		{"default continue", ErrorPolicy{}, NewContinuePolicy(), 1, "ab", 1},
+		{"retry succeeds", NewRetryPolicy(2, 0, NewFailFastPolicy()), ErrorPolicy{}, 2, "afb", 0},
+		{"retry then fail fast", NewRetryPolicy(2, 0, NewFailFastPolicy()), ErrorPolicy{}, 3, "", 1},
+		{"retry then continue", NewRetryPolicy(1, 0, NewContinuePolicy()), ErrorPolicy{}, 2, "ab", 1},
+		{"dead letter", NewDeadLetterPolicy(deadLetter), ErrorPolicy{}, 1, "", 0},
+	}
+	for _, c := range cases {
+		pipeline := NewItemPipeline([]ItemStage{
+			NewItemStage("a", traceProcessor("a"), 1, 0),
+			NewItemStage("flaky", flakyProcessor(c.failures), 1, 0).WithErrorPolicy(c.policy),
+			NewItemStage("b", traceProcessor("b"), 1, 0),
+		}, c.defaultPolicy)
+		sink, errs := runPipeline(t, pipeline, base.Item{"n": 1})
+		if got := strings.Join(sink.values("trace"), ","); got != c.want {
+			t.Errorf("%s: the traces are %q, want %q", c.name, got, c.want)
+		}
+		if len(errs) != c.wantErrors {
+			t.Errorf("%s: the errors are %v, want %d errors", c.name, errs, c.wantErrors)
+		}
+		if counts := pipeline.Count(); counts[2] != 1 || pipeline.ProcessingNumber() != 0 {
+			t.Errorf("%s: the counts are %v and the processing number is %d", c.name, counts, pipeline.ProcessingNumber())
+		}
+	}
+	//死信中的条目附带了错误信息
+	if len(deadLetter.items) != 1 ||
+		deadLetter.items[0][ITEM_ERROR_KEY] != "flaky" ||
+		deadLetter.items[0][ITEM_ERROR_STAGE_KEY] != "flaky" {
+		t.Errorf("The dead letters are %v", deadLetter.items)
+	}
+	if !deadLetter.closed {
+		t.Errorf("The dead letter sink is not closed with the pipeline")
+	}
+}
+
+//默认的错误策略在创建管道时确定,无效的策略会导致panic
+func TestPipelineDefaultErrorPolicy(t *testing.T) {
+	stages := []ItemStage{NewItemStage("a", traceProcessor("a"), 1, 0)}
+	if pipeline := NewItemPipeline(stages, ErrorPolicy{}); !pipeline.FailFast() ||
+		pipeline.DefaultErrorPolicy().Kind() != ERROR_POLICY_FAIL_FAST {
+		t.Errorf("The pipeline is not fail fast by default: %s", pipeline.Summary())
+	}
+	if pipeline := NewItemPipeline(stages, NewContinuePolicy()); pipeline.FailFast() {
+		t.Errorf("The pipeline with continue policy is fail fast")
+	}
+	defer func() {
+		if p := recover(); p == nil {
+			t.Errorf("No panic with an invalid default error policy")
+		}
+	}()
+	NewItemPipeline(stages, NewDeadLetterPolicy(nil))
+}
+
+//条目按照顺序经过每一个阶段,每个阶段的计数都出现在摘要信息中
+func TestPipelineStageOrder(t *testing.T) {
+	pipeline := NewItemPipeline([]ItemStage{
+		NewItemStage("a", traceProcessor("a"), 3, 2),
+		NewItemStage("b", traceProcessor("b"), 1, 0),
+		NewItemStage("c", traceProcessor("c"), 2, 5),
+	}, ErrorPolicy{})
+	items := make([]base.Item, 20)
+	for i := range items {
+		items[i] = base.Item{"n": i}
+	}
+	sink, errs := runPipeline(t, pipeline, items...)
+	if len(errs) > 0 {
+		t.Errorf("The errors are %v", errs)
+	}
+	traces := sink.values("trace")
+	if len(traces) != len(items) {
+		t.Fatalf("%d items are written, want %d", len(traces), len(items))
+	}
+	for _, trace := range traces {
+		if trace != "abc" {
+			t.Errorf("The trace is %q, want %q", trace, "abc")
+		}
+	}
+	if counts := pipeline.Count(); counts[0] != 20 || counts[1] != 20 || counts[2] != 20 || counts[3] != 0 {
+		t.Errorf("The counts are %v", counts)
+	}
+	summary := pipeline.Summary()
+	for _, want := range []string{"a{workers:3, queue:0/2, handled:20", "b{workers:1", "c{workers:2, queue:0/5"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("The summary %q does not contain %q", summary, want)
+		}
+	}
+}
+
+//条目处理器的序列可以被转换为阶段的序列
+func TestNewProcessorStages(t *testing.T) {
+	stages := NewProcessorStages([]ProcessItem{traceProcessor("a"), traceProcessor("b")})
+	if len(stages) != 2 || stages[0].Name() != "processor0" || stages[1].Name() != "processor1" {
+		t.Fatalf("The stages are %v", stages)
+	}
+	sink, errs := runPipeline(t, NewItemPipeline(stages, ErrorPolicy{}), base.Item{"n": 1}, base.Item{"n": 2})
+	if got := strings.Join(sink.values("trace"), ","); len(errs) > 0 || got != "ab,ab" {
+		t.Errorf("The traces are %q and the errors are %v", got, errs)
+	}
+	if stages := NewProcessorStages([]ProcessItem{nil}); stages[0].Check() == nil {
+		t.Errorf("The stage of nil processor is valid")
+	}
+}
+
+//队列已满时发送条目的一方会被阻塞
+func TestPipelineBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	pipeline := NewItemPipeline([]ItemStage{
+		NewItemStage("slow", func(item base.Item) (base.Item, error) {
+			<-release
+			return item, nil
+		}, 1, 1),
+	}, ErrorPolicy{})
+	wait := collectErrors(pipeline)
+	sent := make(chan int, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			pipeline.Send(base.Item{"n": i})
+			sent <- i
+		}
+	}()
+	//第一个条目被工作goroutine取走,第二个条目进入队列,第三个条目只能等待
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatalf("The item %d is not accepted", i)
+		}
+	}
+	select {
+	case <-sent:
+		t.Fatalf("The third item is accepted while the queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-sent
+	pipeline.Close()
+	if errs := wait(); len(errs) > 0 {
+		t.Errorf("The errors are %v", errs)
+	}
+	if counts := pipeline.Count(); counts[2] != 3 {
+		t.Errorf("The counts are %v", counts)
+	}
+}
+
+//记录每一批的大小的批量条目处理器,参数fail判断条目是否应该失败
+type batchRecorder struct {
+	//每一批的大小
+	sizes []int
+	//判断条目是否应该失败
+	fail func(item base.Item) bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+func (recorder *batchRecorder) process(items []base.Item) ([]base.Item, []error) {
+	recorder.mutex.Lock()
+	recorder.sizes = append(recorder.sizes, len(items))
+	recorder.mutex.Unlock()
+	var errs []error
+	for i, item := range items {
+		if recorder.fail != nil && recorder.fail(item) {
+			if errs == nil {
+				errs = make([]error, len(items))
+			}
+			errs[i] = errors.New(fmt.Sprintf("bad item %v", item["n"]))
+			continue
+		}
+		traceProcessor("B")(item)
+	}
+	return nil, errs
+}
+
+//获得每一批的大小
+func (recorder *batchRecorder) batchSizes() []int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]int(nil), recorder.sizes...)
+}
+
+//等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout when waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//凑满一批时立即处理,剩余的条目在管道关闭时被处理
+func TestBatchStageFlushByCountAndClose(t *testing.T) {
+	recorder := &batchRecorder{}
+	pipeline := NewItemPipeline([]ItemStage{
+		NewBatchItemStage("batch", recorder.process, 1, 10, 3, time.Hour),
+	}, ErrorPolicy{})
+	sink := &memSink{}
+	pipeline.AddSink(sink)
+	wait := collectErrors(pipeline)
+	for i := 0; i < 7; i++ {
+		pipeline.Send(base.Item{"n": i})
+	}
+	waitFor(t, "two full batches", func() bool { return len(recorder.batchSizes()) == 2 })
+	if got := fmt.Sprint(recorder.batchSizes()); got != "[3 3]" {
+		t.Errorf("The batch sizes before closing are %s", got)
+	}
+	pipeline.Close()
+	if got := fmt.Sprint(recorder.batchSizes()); got != "[3 3 1]" {
+		t.Errorf("The batch sizes after closing are %s", got)
+	}
+	if errs := wait(); len(errs) > 0 || len(sink.items) != 7 {
+		t.Errorf("%d items are written, the errors are %v", len(sink.items), errs)
+	}
+}
+
+//不满一批的条目在超过写出间隔之后被处理
+func TestBatchStageFlushByInterval(t *testing.T) {
+	recorder := &batchRecorder{}
+	pipeline := NewItemPipeline([]ItemStage{
+		NewBatchItemStage("batch", recorder.process, 1, 10, 100, 20*time.Millisecond),
+	}, ErrorPolicy{})
+	wait := collectErrors(pipeline)
+	pipeline.Send(base.Item{"n": 1})
+	pipeline.Send(base.Item{"n": 2})
+	waitFor(t, "the interval flush", func() bool { return len(recorder.batchSizes()) > 0 })
+	waitFor(t, "the items processed", func() bool { return pipeline.ProcessingNumber() == 0 })
+	pipeline.Close()
+	total := 0
+	for _, size := range recorder.batchSizes() {
+		total += size
+	}
+	if total != 2 {
+		t.Errorf("The batch sizes are %v", recorder.batchSizes())
+	}
+	wait()
+}
+
+//批量处理阶段与普通的阶段混合使用,失败的条目被逐个报告
+func TestBatchStagePartialFailure(t *testing.T) {
+	recorder := &batchRecorder{fail: func(item base.Item) bool { return item["n"].(int)%2 == 1 }}
+	pipeline := NewItemPipeline([]ItemStage{
+		NewItemStage("a", traceProcessor("a"), 2, 2),
+		NewBatchItemStage("batch", recorder.process, 1, 10, 4, time.Hour),
+		NewItemStage("c", traceProcessor("c"), 1, 0),
+	}, ErrorPolicy{})
+	items := make([]base.Item, 8)
+	for i := range items {
+		items[i] = base.Item{"n": i}
+	}
+	sink, errs := runPipeline(t, pipeline, items...)
+	if got := strings.Join(sink.values("trace"), ","); got != "aBc,aBc,aBc,aBc" {
+		t.Errorf("The traces are %q", got)
+	}
+	if len(errs) != 4 {
+		t.Fatalf("The errors are %v, want 4 errors", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "bad item") || !strings.Contains(err.Error(), "stage=batch") {
+			t.Errorf("The error is %q", err)
+		}
+	}
+	if counts := pipeline.Count(); counts[2] != 8 || pipeline.ProcessingNumber() != 0 {
+		t.Errorf("The counts are %v", counts)
+	}
+}
+
+//条目被交给第一个匹配的分支,经过分支中的阶段之后回到路由阶段的下一个阶段
+func TestRouterStage(t *testing.T) {
+	pipeline := NewItemPipeline([]ItemStage{
+		NewRouterStage("router", []Route{
+			{Name: "product", Match: MatchItemType("product"), Stages: []ItemStage{
+				NewItemStage("p1", traceProcessor("p"), 1, 0),
+				NewItemStage("p2", traceProcessor("P"), 1, 0),
+			}},
+			{Name: "news", Match: MatchSourceURL(regexp.MustCompile(`^http://news\.`)), Stages: []ItemStage{
+				NewItemStage("n", traceProcessor("n"), 1, 0),
+			}},
+			//匹配product的条目不会再进入这个分支
+			{Name: "all", Match: func(item base.Item) bool { return true }, Stages: []ItemStage{
+				NewItemStage("x", traceProcessor("x"), 1, 0),
+			}},
+		}, 2, 2),
+		NewItemStage("end", traceProcessor("."), 1, 0),
+	}, ErrorPolicy{})
+	sink, errs := runPipeline(t, pipeline,
+		base.Item{base.ITEM_TYPE_KEY: "product", "n": 1},
+		base.Item{base.ITEM_SOURCE_URL_KEY: "http://news.example.com/1", "n": 2},
+		base.Item{base.ITEM_TYPE_KEY: "product", base.ITEM_SOURCE_URL_KEY: "http://news.example.com/2", "n": 3},
+		base.Item{"n": 4})
+	if len(errs) > 0 {
+		t.Errorf("The errors are %v", errs)
+	}
+	traces := make(map[string]string)
+	for _, item := range sink.items {
+		traces[fmt.Sprint(item["n"])], _ = item["trace"].(string)
+	}
+	want := map[string]string{"1": "pP.", "2": "n.", "3": "pP.", "4": "x."}
+	if fmt.Sprint(traces) != fmt.Sprint(want) {
+		t.Errorf("The traces are %v, want %v", traces, want)
+	}
+	summary := pipeline.Summary()
+	for _, want := range []string{"product{matched:2", "news{matched:1", "all{matched:1"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("The summary %q does not contain %q", summary, want)
+		}
+	}
+}
+
+//拆分出的条目分别进入下一个阶段,被丢弃的条目只计入丢弃计数
+func TestSplitAndDropStages(t *testing.T) {
+	pipeline := NewItemPipeline([]ItemStage{
+		NewSplitStage("split", func(item base.Item) ([]base.Item, error) {
+			results := make([]base.Item, 0)
+			for _, tag := range item["tags"].([]string) {
+				results = append(results, base.Item{"tag": tag})
+			}
+			return results, nil
+		}, 1, 0),
+		NewDropStage("drop", func(item base.Item) bool { return item["tag"] == "spam" }, 1, 0),
+		NewItemStage("a", traceProcessor("a"), 1, 0),
+	}, ErrorPolicy{})
+	sink, errs := runPipeline(t, pipeline,
+		base.Item{"tags": []string{"go", "spam", "web"}},
+		base.Item{"tags": []string{}})
+	if len(errs) > 0 {
+		t.Errorf("The errors are %v", errs)
+	}
+	if got := strings.Join(sink.values("tag"), ","); got != "go,web" {
+		t.Errorf("The written tags are %q", got)
+	}
+	//空的拆分结果和被丢弃的spam计入丢弃计数,go和web计入已处理的计数
+	if counts := pipeline.Count(); counts[1] != 2 || counts[2] != 2 || counts[3] != 2 || pipeline.ProcessingNumber() != 0 {
+		t.Errorf("The counts are %v, the processing number is %d", counts, pipeline.ProcessingNumber())
+	}
+}
@@ -0,0 +1,161 @@
+package itempipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//错误策略的种类
+type ErrorPolicyKind int
+
+const (
+	//未设置,使用创建条目处理管道时指定的默认错误策略
+	ERROR_POLICY_DEFAULT ErrorPolicyKind = iota
+	//快速失败,报告错误并忽略后续的所有阶段
+	ERROR_POLICY_FAIL_FAST
+	//报告错误,然后把条目交给下一个阶段
+	ERROR_POLICY_CONTINUE
+	//重试若干次,仍然失败时按照后备策略处理
+	ERROR_POLICY_RETRY
+	//把条目交给死信输出器,不再进入后续的阶段
+	ERROR_POLICY_DEAD_LETTER
+)
+
+//交给死信输出器的条目中附带的键
+const (
+	//错误信息
+	ITEM_ERROR_KEY = "_error"
+	//出错的阶段的名称
+	ITEM_ERROR_STAGE_KEY = "_error_stage"
+)
+
+//重试间隔的上限
+var maxRetryBackoff = time.Minute
+
+//阶段的错误策略
+type ErrorPolicy struct {
+	//种类
+	kind ErrorPolicyKind
+	//重试次数
+	retries uint32
+	//首次重试之前的等待时间,之后每次重试的等待时间都会翻倍
+	backoff time.Duration
+	//重试仍然失败时使用的后备策略的种类
+	fallback ErrorPolicyKind
+	//死信输出器
+	deadLetter ItemSink
+}
+
+//创建快速失败的错误策略
+func NewFailFastPolicy() ErrorPolicy {
+	return ErrorPolicy{kind: ERROR_POLICY_FAIL_FAST}
+}
+
+//创建出错后继续的错误策略
+func NewContinuePolicy() ErrorPolicy {
+	return ErrorPolicy{kind: ERROR_POLICY_CONTINUE}
+}
+
+//创建重试的错误策略
+//参数fallback代表重试仍然失败时使用的策略,它不能是重试策略
+func NewRetryPolicy(retries uint32, backoff time.Duration, fallback ErrorPolicy) ErrorPolicy {
+	return ErrorPolicy{
+		kind:       ERROR_POLICY_RETRY,
+		retries:    retries,
+		backoff:    backoff,
+		fallback:   fallback.kind,
+		deadLetter: fallback.deadLetter,
+	}
+}
+
+//创建死信的错误策略
+//出错的条目会附带ITEM_ERROR_KEY和ITEM_ERROR_STAGE_KEY被交给sink,条目处理管道关闭时会关闭该输出器
+func NewDeadLetterPolicy(sink ItemSink) ErrorPolicy {
+	return ErrorPolicy{kind: ERROR_POLICY_DEAD_LETTER, deadLetter: sink}
+}
+
+//获得种类
+func (policy ErrorPolicy) Kind() ErrorPolicyKind {
+	return policy.kind
+}
+
+//获得重试次数
+func (policy ErrorPolicy) Retries() uint32 {
+	return policy.retries
+}
+
+//获得首次重试之前的等待时间
+func (policy ErrorPolicy) Backoff() time.Duration {
+	return policy.backoff
+}
+
+//获得死信输出器
+func (policy ErrorPolicy) DeadLetter() ItemSink {
+	return policy.deadLetter
+}
+
+//检查错误策略的有效性
+func (policy ErrorPolicy) Check() error {
+	if policy.kind < ERROR_POLICY_DEFAULT || policy.kind > ERROR_POLICY_DEAD_LETTER {
+		return errors.New(fmt.Sprintf("Unknown error policy kind: %d", policy.kind))
+	}
+	if policy.kind == ERROR_POLICY_RETRY {
+		if policy.retries == 0 {
+			return errors.New("The retry times of error policy can not be 0!")
+		}
+		if policy.backoff < 0 {
+			return errors.New("The retry backoff of error policy can not be negative!")
+		}
+		if policy.fallback == ERROR_POLICY_RETRY {
+			return errors.New("The fallback of retry policy can not be another retry policy!")
+		}
+	}
+	if policy.finalKind() == ERROR_POLICY_DEAD_LETTER && policy.deadLetter == nil {
+		return errors.New("The dead letter sink of error policy is invalid!")
+	}
+	return nil
+}
+
+//获得重试之后最终使用的策略的种类
+func (policy ErrorPolicy) finalKind() ErrorPolicyKind {
+	if policy.kind == ERROR_POLICY_RETRY {
+		return policy.fallback
+	}
+	return policy.kind
+}
+
+//获得第attempt次(从0开始)重试之前的等待时间
+func (policy ErrorPolicy) retryDelay(attempt uint32) time.Duration {
+	delay := policy.backoff
+	for i := uint32(0); i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+func (kind ErrorPolicyKind) String() string {
+	switch kind {
+	case ERROR_POLICY_DEFAULT:
+		return "default"
+	case ERROR_POLICY_FAIL_FAST:
+		return "failFast"
+	case ERROR_POLICY_CONTINUE:
+		return "continue"
+	case ERROR_POLICY_RETRY:
+		return "retry"
+	case ERROR_POLICY_DEAD_LETTER:
+		return "deadLetter"
+	}
+	return fmt.Sprintf("unknown(%d)", int(kind))
+}
+
+func (policy ErrorPolicy) String() string {
+	if policy.kind == ERROR_POLICY_RETRY {
+		return fmt.Sprintf("retry(times:%d, backoff:%v, then:%s)", policy.retries, policy.backoff, policy.fallback)
+	}
+	return policy.kind.String()
+}
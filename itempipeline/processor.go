@@ -1,6 +1,23 @@
 package itempipeline
 
-import "summerWebCrawler/base"
+import (
+	"errors"
+	"summerWebCrawler/base"
+)
 
 //被用来处理条目的函数类型
 type ProcessItem func(item base.Item) (result base.Item, err error)
+
+//被用来批量处理条目的函数类型
+//结果值results和errs中的元素都与参数items中的元素一一对应.
+//errs[i]不为nil代表第i个条目处理失败,errs为nil代表全部成功;
+//results为nil或者results[i]为nil代表对应的条目保持不变
+type BatchProcessItem func(items []base.Item) (results []base.Item, errs []error)
+
+//被用来把一个条目拆分为多个条目的函数类型
+//结果值为空时,条目会被当作被丢弃
+type SplitItem func(item base.Item) (results []base.Item, err error)
+
+//丢弃条目的信号
+//条目处理器返回该错误值时,条目会被丢弃,并且只计入丢弃计数而不会被当作错误报告
+var ErrDropItem = errors.New("drop the item")
@@ -0,0 +1,93 @@
+package itempipeline
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"summerWebCrawler/base"
+)
+
+//条目谓词的函数类型,被用来决定条目的去向
+type ItemPredicate func(item base.Item) bool
+
+//路由分支
+type Route struct {
+	//名称
+	Name string
+	//匹配条件
+	Match ItemPredicate
+	//该分支中的阶段的序列.这些阶段处理完条目之后,条目会回到路由阶段的下一个阶段
+	Stages []ItemStage
+}
+
+//创建路由阶段
+//条目会被交给第一个匹配的分支处理,没有匹配任何分支的条目直接进入路由阶段的下一个阶段
+func NewRouterStage(name string, routes []Route, workers uint32, queueLen uint32) ItemStage {
+	stage := NewItemStage(name, nil, workers, queueLen)
+	stage.routes = routes
+	return stage
+}
+
+//创建拆分阶段
+//splitter返回的每个条目都会作为单独的条目进入下一个阶段
+func NewSplitStage(name string, splitter SplitItem, workers uint32, queueLen uint32) ItemStage {
+	stage := NewItemStage(name, nil, workers, queueLen)
+	stage.splitter = splitter
+	return stage
+}
+
+//创建丢弃阶段
+//满足predicate的条目会被丢弃,它们只计入丢弃计数
+func NewDropStage(name string, predicate ItemPredicate, workers uint32, queueLen uint32) ItemStage {
+	var processor ProcessItem
+	if predicate != nil {
+		processor = func(item base.Item) (base.Item, error) {
+			if predicate(item) {
+				return nil, ErrDropItem
+			}
+			return item, nil
+		}
+	}
+	return NewItemStage(name, processor, workers, queueLen)
+}
+
+//检查路由分支的有效性
+func (route Route) check() error {
+	if route.Name == "" {
+		return errors.New("The name of route can not be empty!")
+	}
+	if route.Match == nil {
+		return errors.New(fmt.Sprintf("The predicate of route '%s' is invalid!", route.Name))
+	}
+	for i, stage := range route.Stages {
+		if err := stage.Check(); err != nil {
+			return errors.New(fmt.Sprintf("Invalid stage[%d] of route '%s': %s", i, route.Name, err))
+		}
+	}
+	return nil
+}
+
+//匹配条目类型的谓词
+func MatchItemType(typeNames ...string) ItemPredicate {
+	typeSet := make(map[string]bool)
+	for _, typeName := range typeNames {
+		typeSet[typeName] = true
+	}
+	return func(item base.Item) bool {
+		return typeSet[item.Type()]
+	}
+}
+
+//匹配条目来源url的谓词,来源url取自条目中ITEM_SOURCE_URL_KEY对应的值
+func MatchSourceURL(pattern *regexp.Regexp) ItemPredicate {
+	return func(item base.Item) bool {
+		switch sourceUrl := item[base.ITEM_SOURCE_URL_KEY].(type) {
+		case string:
+			return pattern.MatchString(sourceUrl)
+		case *url.URL:
+			return sourceUrl != nil && pattern.MatchString(sourceUrl.String())
+		}
+		return false
+	}
+}
@@ -0,0 +1,335 @@
+package itempipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"summerWebCrawler/base"
+	"sync"
+	"unicode/utf8"
+)
+
+//字段类型
+type FieldKind uint8
+
+//字段类型常量
+const (
+	//任意类型
+	FIELD_KIND_ANY FieldKind = 0
+	//字符串
+	FIELD_KIND_STRING FieldKind = 1
+	//整数
+	FIELD_KIND_INT FieldKind = 2
+	//浮点数(整数也被视为合法的浮点数)
+	FIELD_KIND_FLOAT FieldKind = 3
+	//布尔值
+	FIELD_KIND_BOOL FieldKind = 4
+	//绝对url,可以是字符串或者*url.URL
+	FIELD_KIND_URL FieldKind = 5
+	//切片或数组
+	FIELD_KIND_LIST FieldKind = 6
+	//字典
+	FIELD_KIND_MAP FieldKind = 7
+)
+
+var fieldKindNameMap = map[FieldKind]string{
+	FIELD_KIND_ANY:    "any",
+	FIELD_KIND_STRING: "string",
+	FIELD_KIND_INT:    "int",
+	FIELD_KIND_FLOAT:  "float",
+	FIELD_KIND_BOOL:   "bool",
+	FIELD_KIND_URL:    "url",
+	FIELD_KIND_LIST:   "list",
+	FIELD_KIND_MAP:    "map",
+}
+
+func (kind FieldKind) String() string {
+	if name, ok := fieldKindNameMap[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", kind)
+}
+
+//字段规则
+type FieldRule struct {
+	//字段名称
+	Name string
+	//字段类型
+	Kind FieldKind
+	//是否必须存在
+	Required bool
+	//字符串的最小长度(按字符计算)或者列表和字典的最小元素数量.0表示不限制
+	MinLen int
+	//字符串的最大长度(按字符计算)或者列表和字典的最大元素数量.0表示不限制
+	MaxLen int
+	//数值的最小值,nil表示不限制
+	Min *float64
+	//数值的最大值,nil表示不限制
+	Max *float64
+	//字符串需要匹配的正则表达式,nil表示不限制
+	Pattern *regexp.Regexp
+	//自定义的检查函数,nil表示不做检查
+	Check func(value interface{}) error
+}
+
+//条目的模式
+type Schema struct {
+	//条目的类型名称,与条目中ITEM_TYPE_KEY对应的值相同
+	TypeName string
+	//字段规则的列表
+	Fields []FieldRule
+	//是否禁止出现未声明的字段.以"_"开头的字段不受此限制
+	Strict bool
+}
+
+//字段违例
+type FieldViolation struct {
+	//字段名称
+	Field string
+	//违例的原因
+	Reason string
+}
+
+func (violation FieldViolation) String() string {
+	return fmt.Sprintf("%s: %s", violation.Field, violation.Reason)
+}
+
+//条目校验错误
+type ValidationError struct {
+	//条目的类型名称
+	typeName string
+	//字段违例的列表
+	violations []FieldViolation
+}
+
+//获取条目的类型名称
+func (ve *ValidationError) TypeName() string {
+	return ve.typeName
+}
+
+//获取字段违例的列表
+func (ve *ValidationError) Violations() []FieldViolation {
+	return ve.violations
+}
+
+func (ve *ValidationError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Invalid item (type=%s): ", ve.typeName))
+	for i, violation := range ve.violations {
+		if i > 0 {
+			buffer.WriteString("; ")
+		}
+		buffer.WriteString(violation.String())
+	}
+	return buffer.String()
+}
+
+//模式注册表的接口类型
+type SchemaRegistry interface {
+	//注册模式.同一个类型名称只能注册一次
+	Register(schema Schema) error
+	//根据类型名称获取模式
+	Get(typeName string) (Schema, bool)
+	//校验条目.没有类型名称或者类型未被注册的条目不做校验
+	//若结果值为nil,则说明条目有效
+	Validate(item base.Item) *ValidationError
+	//获取已注册的类型名称的列表
+	TypeNames() []string
+}
+
+//模式注册表的实现类型
+type mySchemaRegistry struct {
+	//模式的字典
+	schemaMap map[string]Schema
+	//读写锁
+	rwmutex sync.RWMutex
+}
+
+//创建模式注册表
+func NewSchemaRegistry() SchemaRegistry {
+	return &mySchemaRegistry{schemaMap: make(map[string]Schema)}
+}
+
+func (registry *mySchemaRegistry) Register(schema Schema) error {
+	if schema.TypeName == "" {
+		return errors.New("The type name of schema can not be empty!")
+	}
+	names := make(map[string]bool)
+	for i, field := range schema.Fields {
+		if field.Name == "" {
+			return errors.New(fmt.Sprintf("The name of field [%d] is empty! (type=%s)", i, schema.TypeName))
+		}
+		if names[field.Name] {
+			return errors.New(fmt.Sprintf("The field '%s' is repeated! (type=%s)", field.Name, schema.TypeName))
+		}
+		names[field.Name] = true
+	}
+	registry.rwmutex.Lock()
+	defer registry.rwmutex.Unlock()
+	if _, ok := registry.schemaMap[schema.TypeName]; ok {
+		return errors.New(fmt.Sprintf("The schema (type=%s) has been registered!", schema.TypeName))
+	}
+	registry.schemaMap[schema.TypeName] = schema
+	return nil
+}
+
+func (registry *mySchemaRegistry) Get(typeName string) (Schema, bool) {
+	registry.rwmutex.RLock()
+	defer registry.rwmutex.RUnlock()
+	schema, ok := registry.schemaMap[typeName]
+	return schema, ok
+}
+
+func (registry *mySchemaRegistry) Validate(item base.Item) *ValidationError {
+	typeName := item.Type()
+	if typeName == "" {
+		return nil
+	}
+	schema, ok := registry.Get(typeName)
+	if !ok {
+		return nil
+	}
+	violations := make([]FieldViolation, 0)
+	declared := make(map[string]bool)
+	for _, field := range schema.Fields {
+		declared[field.Name] = true
+		value, exists := item[field.Name]
+		if !exists || value == nil {
+			if field.Required {
+				violations = append(violations, FieldViolation{Field: field.Name, Reason: "is required"})
+			}
+			continue
+		}
+		if reason := checkField(field, value); reason != "" {
+			violations = append(violations, FieldViolation{Field: field.Name, Reason: reason})
+		}
+	}
+	if schema.Strict {
+		undeclared := make([]string, 0)
+		for k := range item {
+			if !declared[k] && (len(k) == 0 || k[0] != '_') {
+				undeclared = append(undeclared, k)
+			}
+		}
+		//保证违例的顺序稳定
+		sort.Strings(undeclared)
+		for _, k := range undeclared {
+			violations = append(violations, FieldViolation{Field: k, Reason: "is not declared"})
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{typeName: typeName, violations: violations}
+}
+
+func (registry *mySchemaRegistry) TypeNames() []string {
+	registry.rwmutex.RLock()
+	defer registry.rwmutex.RUnlock()
+	names := make([]string, 0, len(registry.schemaMap))
+	for name := range registry.schemaMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//检查字段的值,返回违例的原因.返回空字符串说明字段有效
+func checkField(field FieldRule, value interface{}) string {
+	v := reflect.ValueOf(value)
+	//长度,用于长度限制的检查.-1表示该类型没有长度
+	length := -1
+	//数值,用于范围限制的检查
+	var number float64
+	isNumber := false
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, isNumber = float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, isNumber = float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		number, isNumber = v.Float(), true
+	case reflect.String:
+		length = utf8.RuneCountInString(v.String())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		length = v.Len()
+	}
+	switch field.Kind {
+	case FIELD_KIND_STRING:
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("should be %s, but got %T", field.Kind, value)
+		}
+	case FIELD_KIND_INT:
+		//由JSON解码得到的整数是float64类型
+		if !isNumber || number != float64(int64(number)) {
+			return fmt.Sprintf("should be %s, but got %T(%v)", field.Kind, value, value)
+		}
+	case FIELD_KIND_FLOAT:
+		if !isNumber {
+			return fmt.Sprintf("should be %s, but got %T", field.Kind, value)
+		}
+	case FIELD_KIND_BOOL:
+		if v.Kind() != reflect.Bool {
+			return fmt.Sprintf("should be %s, but got %T", field.Kind, value)
+		}
+	case FIELD_KIND_URL:
+		if reason := checkURL(value); reason != "" {
+			return reason
+		}
+	case FIELD_KIND_LIST:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Sprintf("should be %s, but got %T", field.Kind, value)
+		}
+	case FIELD_KIND_MAP:
+		if v.Kind() != reflect.Map {
+			return fmt.Sprintf("should be %s, but got %T", field.Kind, value)
+		}
+	}
+	if length >= 0 {
+		if field.MinLen > 0 && length < field.MinLen {
+			return fmt.Sprintf("the length %d is less than %d", length, field.MinLen)
+		}
+		if field.MaxLen > 0 && length > field.MaxLen {
+			return fmt.Sprintf("the length %d is greater than %d", length, field.MaxLen)
+		}
+	}
+	if isNumber {
+		if field.Min != nil && number < *field.Min {
+			return fmt.Sprintf("the value %v is less than %v", number, *field.Min)
+		}
+		if field.Max != nil && number > *field.Max {
+			return fmt.Sprintf("the value %v is greater than %v", number, *field.Max)
+		}
+	}
+	if field.Pattern != nil && v.Kind() == reflect.String && !field.Pattern.MatchString(v.String()) {
+		return fmt.Sprintf("the value '%s' does not match the pattern '%s'", v.String(), field.Pattern)
+	}
+	if field.Check != nil {
+		if err := field.Check(value); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+//检查绝对url
+func checkURL(value interface{}) string {
+	switch u := value.(type) {
+	case *url.URL:
+		if u == nil || !u.IsAbs() {
+			return "should be an absolute url"
+		}
+	case string:
+		parsed, err := url.Parse(u)
+		if err != nil || !parsed.IsAbs() {
+			return fmt.Sprintf("the value '%s' is not an absolute url", u)
+		}
+	default:
+		return fmt.Sprintf("should be %s, but got %T", FIELD_KIND_URL, value)
+	}
+	return ""
+}
@@ -0,0 +1,409 @@
+package itempipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"summerWebCrawler/base"
+)
+
+//条目输出器的接口类型
+//条目输出器负责把条目持久化,它的Process方法可以直接作为条目处理器使用.
+//带有ID(base.ITEM_ID_KEY)的条目只应被写出一次,已被写出过的条目(包括之前的运行中写出的条目)应被跳过,
+//条目处理管道依靠这一点提供"恰好一次"的保证,见ItemLedger.
+//内置的输出器在创建时会从已存在的输出文件中载入已被写出的条目ID
+type ItemSink interface {
+	//写入条目.条目会先被放入缓冲,缓冲中的条目达到批量大小时才会被写出
+	//无法被写出的条目(如无法被编码的条目)会被丢弃,其它条目不受影响.
+	//结果值err只包含该条目自身的错误或者使整个缓冲都无法被写出的错误,
+	//同一批中其它条目的错误会在下一次调用Flush或Close时被报告
+	Process(item base.Item) (result base.Item, err error)
+	//把缓冲中的条目写出,结果值还包含之前被丢弃的条目的错误
+	Flush() error
+	//把缓冲中的条目写出并关闭输出器.关闭之后再写入条目会返回错误
+	Close() error
+	//获取摘要信息
+	Summary() string
+}
+
+//条目输出器的参数的容器
+type SinkArgs struct {
+	//输出文件的路径
+	path string
+	//批量大小
+	batchSize uint32
+	//单个文件的尺寸上限,单位:字节
+	maxFileSize int64
+	//描述
+	description string
+}
+
+var (
+	//条目输出器参数的容器的描述模板
+	sinkArgsTemplate = "{path:%s, batchSize:%d, maxFileSize:%d}"
+	//输出器已关闭的错误
+	errSinkClosed = errors.New("The item sink has been closed!")
+)
+
+//创建条目输出器的参数的容器
+//参数path代表输出文件的路径.发生轮转时,新文件的名称会在扩展名之前加上序号,如items.1.jsonl
+//参数batchSize代表批量大小,缓冲中的条目达到此数量时才会被写出.0和1都表示逐条写出
+//参数maxFileSize代表单个文件的尺寸上限,超过之后会轮转到新的文件.0表示不轮转
+func NewSinkArgs(path string, batchSize uint32, maxFileSize int64) SinkArgs {
+	return SinkArgs{
+		path:        path,
+		batchSize:   batchSize,
+		maxFileSize: maxFileSize,
+	}
+}
+
+//获得输出文件的路径
+func (args *SinkArgs) Path() string {
+	return args.path
+}
+
+//获得批量大小
+func (args *SinkArgs) BatchSize() uint32 {
+	if args.batchSize == 0 {
+		return 1
+	}
+	return args.batchSize
+}
+
+//获得单个文件的尺寸上限
+func (args *SinkArgs) MaxFileSize() int64 {
+	return args.maxFileSize
+}
+
+func (args *SinkArgs) Check() error {
+	if strings.TrimSpace(args.path) == "" {
+		return errors.New("The sink path can not be empty!\n")
+	}
+	if args.maxFileSize < 0 {
+		return errors.New("The max file size of sink can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *SinkArgs) String() string {
+	if args.description == "" {
+		args.description = fmt.Sprintf(sinkArgsTemplate,
+			args.path,
+			args.batchSize,
+			args.maxFileSize)
+	}
+	return args.description
+}
+
+//生成第seq个文件的路径.第0个文件就是原路径
+func rotatedPath(path string, seq int) string {
+	if seq == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), seq, ext)
+}
+
+//载入已存在的输出文件中的条目ID
+//文件会按照序号依次被参数load读取,直到遇到不存在的文件.load应截掉被中断的写入留下的不完整的记录
+func loadWrittenIds(path string, load func(path string) ([]string, error)) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	for seq := 0; ; seq++ {
+		filePath := rotatedPath(path, seq)
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			return ids, nil
+		}
+		fileIds, err := load(filePath)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Load the written item ids from '%s' failing: %s", filePath, err))
+		}
+		for _, id := range fileIds {
+			ids[id] = true
+		}
+	}
+}
+
+//把文件截断到参数size,被用来去掉不完整的最后一条记录
+func truncateFile(file *os.File, size int64) error {
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+//从序号seq开始查找还不存在的文件,避免覆盖之前的输出
+func nextFreeSeq(path string, seq int) int {
+	for {
+		if _, err := os.Stat(rotatedPath(path, seq)); os.IsNotExist(err) {
+			return seq
+		}
+		seq++
+	}
+}
+
+//把条目写出失败的错误转换为带有条目标识的错误
+func itemWriteError(item base.Item, err error) error {
+	return errors.New(fmt.Sprintf("The item %s can not be written: %s", itemLabel(item), err))
+}
+
+//获得条目的标识,优先使用条目ID,其次是来源url
+func itemLabel(item base.Item) string {
+	if id := item.ID(); id != "" {
+		return fmt.Sprintf("'%s'", id)
+	}
+	if sourceUrl, ok := item[base.ITEM_SOURCE_URL_KEY].(string); ok && sourceUrl != "" {
+		return fmt.Sprintf("(source: %s)", sourceUrl)
+	}
+	return "<unidentified>"
+}
+
+//分拣一次写出中被丢弃的条目的错误
+//结果值为第current个条目的错误,其它条目的错误会被追加到pending中,留待下一次调用Flush或Close时报告
+func settleErrors(failed map[int]error, current int, pending *[]error) error {
+	var currentErr error
+	for _, i := range sortedIndexes(failed) {
+		if i == current {
+			currentErr = failed[i]
+			continue
+		}
+		*pending = append(*pending, failed[i])
+	}
+	return currentErr
+}
+
+//取出所有待报告的错误并合并为一个错误,参数err为使整个缓冲都无法被写出的错误
+func takeErrors(failed map[int]error, err error, pending *[]error) error {
+	errs := *pending
+	*pending = nil
+	for _, i := range sortedIndexes(failed) {
+		errs = append(errs, failed[i])
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(fmt.Sprintf("%d errors occurred: %s", len(errs), strings.Join(messages, "; ")))
+}
+
+//获得按顺序排列的序号
+func sortedIndexes(failed map[int]error) []int {
+	indexes := make([]int, 0, len(failed))
+	for i := range failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+//复制条目
+func copyItem(item base.Item) base.Item {
+	newItem := make(base.Item, len(item))
+	for k, v := range item {
+		newItem[k] = v
+	}
+	return newItem
+}
+
+//把条目中的值转换为便于序列化的形式
+//实现了fmt.Stringer但没有实现json.Marshaler的值(如*url.URL)会被转换为字符串
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Marshaler:
+		return v
+	case fmt.Stringer:
+		if reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+			return nil
+		}
+		return v.String()
+	case base.Item:
+		return normalizeItem(v)
+	case map[string]interface{}:
+		return normalizeItem(base.Item(v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, elem := range v {
+			values[i] = normalizeValue(elem)
+		}
+		return values
+	}
+	return value
+}
+
+//转换条目中所有的值
+func normalizeItem(item base.Item) base.Item {
+	newItem := make(base.Item, len(item))
+	for k, v := range item {
+		newItem[k] = normalizeValue(v)
+	}
+	return newItem
+}
+
+//可轮转的输出文件
+type rotatingFile struct {
+	//第0个文件的路径
+	path string
+	//单个文件的尺寸上限,0表示不轮转
+	maxSize int64
+	//当前文件的序号
+	seq int
+	//当前文件
+	file *os.File
+	//当前文件的尺寸
+	size int64
+	//打开了的文件的数量
+	fileCount uint32
+	//新文件被打开之后调用,用于写入文件头.可以为nil
+	onOpen func() []byte
+}
+
+//创建可轮转的输出文件,文件会在第一次写入时才被创建
+func newRotatingFile(path string, maxSize int64, onOpen func() []byte) *rotatingFile {
+	return &rotatingFile{path: path, maxSize: maxSize, seq: -1, onOpen: onOpen}
+}
+
+//打开下一个文件
+func (rf *rotatingFile) openNext() error {
+	if rf.file != nil {
+		if err := rf.file.Close(); err != nil {
+			return err
+		}
+		rf.file = nil
+	}
+	if dir := filepath.Dir(rf.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	rf.seq = nextFreeSeq(rf.path, rf.seq+1)
+	file, err := os.OpenFile(rotatedPath(rf.path, rf.seq), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	rf.file = file
+	rf.size = 0
+	rf.fileCount++
+	if rf.onOpen != nil {
+		if header := rf.onOpen(); len(header) > 0 {
+			n, err := rf.file.Write(header)
+			rf.size += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//写入一条记录.当前文件写入这条记录之后会超过尺寸上限时,先轮转到新的文件
+func (rf *rotatingFile) write(record []byte) error {
+	if rf.file == nil ||
+		(rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(record)) > rf.maxSize) {
+		if err := rf.openNext(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.file.Write(record)
+	rf.size += int64(n)
+	return err
+}
+
+//重写当前文件
+//参数transform根据当前文件的内容生成新的内容.新的内容会先被写入临时文件,再替换当前文件,
+//因此重写失败时当前文件保持不变
+func (rf *rotatingFile) rewrite(transform func(content []byte) ([]byte, error)) error {
+	if rf.file == nil {
+		return nil
+	}
+	path := rotatedPath(rf.path, rf.seq)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newContent, err := transform(content)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, newContent); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := rf.file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	rf.file = nil
+	renameErr := os.Rename(tmpPath, path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+	//无论替换是否成功都要重新打开当前文件,以便继续写入
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	rf.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	rf.size = int64(len(newContent))
+	return nil
+}
+
+//写入文件并同步到磁盘
+func writeFileSync(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//把文件内容同步到磁盘
+func (rf *rotatingFile) sync() error {
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+//关闭当前文件
+func (rf *rotatingFile) close() error {
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+//获取当前文件的路径
+func (rf *rotatingFile) currentPath() string {
+	if rf.seq < 0 {
+		return rotatedPath(rf.path, 0)
+	}
+	return rotatedPath(rf.path, rf.seq)
+}
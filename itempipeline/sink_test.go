@@ -0,0 +1,226 @@
+package itempipeline
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//创建临时目录,返回目录和清理函数
+func newTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "itempipeline")
+	if err != nil {
+		t.Fatalf("Create temp dir failing: %s", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+//创建带有ID的条目
+func newTestItem(id string, kvs ...interface{}) base.Item {
+	item := base.Item{base.ITEM_ID_KEY: id}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		item[kvs[i].(string)] = kvs[i+1]
+	}
+	return item
+}
+
+//读取文件中的所有行
+func readLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open %s failing: %s", path, err)
+	}
+	defer file.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+//无法被编码的条目会被丢弃并被单独报告,同一批中的其它条目照常写出
+func TestJSONLinesSinkSkipsBadItem(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "items.jsonl")
+	sink, err := NewJSONLinesSink(NewSinkArgs(path, 3, 0))
+	if err != nil {
+		t.Fatalf("Create sink failing: %s", err)
+	}
+	if _, err := sink.Process(newTestItem("good1", "v", 1)); err != nil {
+		t.Errorf("An error occurs when processing good1: %s", err)
+	}
+	if _, err := sink.Process(newTestItem("bad", "v", math.NaN())); err != nil {
+		t.Errorf("An error occurs when buffering the bad item: %s", err)
+	}
+	//触发写出的条目不能收到其它条目的错误
+	if _, err := sink.Process(newTestItem("good2", "v", 2)); err != nil {
+		t.Errorf("The error of another item is reported against good2: %s", err)
+	}
+	err = sink.Flush()
+	if err == nil || !strings.Contains(err.Error(), "'bad'") {
+		t.Errorf("The error of the bad item is %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Errorf("The error is reported twice: %s", err)
+	}
+	//触发写出的条目自身的错误会被直接返回
+	if _, err := sink.Process(newTestItem("bad2", "v", math.Inf(1))); err != nil {
+		t.Errorf("An error occurs when buffering bad2: %s", err)
+	}
+	if _, err := sink.Process(newTestItem("good3", "v", 3)); err != nil {
+		t.Errorf("An error occurs when processing good3: %s", err)
+	}
+	if _, err := sink.Process(newTestItem("bad3", "v", math.NaN())); err == nil || !strings.Contains(err.Error(), "'bad3'") {
+		t.Errorf("The error of bad3 is %v", err)
+	}
+	if err := sink.Close(); err == nil || !strings.Contains(err.Error(), "'bad2'") || strings.Contains(err.Error(), "'bad3'") {
+		t.Errorf("The error when closing is %v", err)
+	}
+	lines := readLines(t, path)
+	if len(lines) != 3 {
+		t.Fatalf("The lines are %q, want 3 lines", lines)
+	}
+	for i, id := range []string{"good1", "good2", "good3"} {
+		if !strings.Contains(lines[i], `"`+id+`"`) {
+			t.Errorf("The line %d is %q, want item %s", i, lines[i], id)
+		}
+	}
+}
+
+//出现新的列时重写当前文件的表头而不是轮转到新的文件
+func TestCSVSinkNewColumns(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "items.csv")
+	sink, err := NewCSVSink(NewSinkArgs(path, 1, 0))
+	if err != nil {
+		t.Fatalf("Create sink failing: %s", err)
+	}
+	items := []base.Item{
+		{"a": 1},
+		{"a": 2, "b": "x,y"},
+		{"c": "multi\nline"},
+	}
+	for _, item := range items {
+		if _, err := sink.Process(item); err != nil {
+			t.Fatalf("An error occurs when processing %v: %s", item, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("An error occurs when closing: %s", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("The files are %v, want only %s", files, path)
+	}
+	file, _ := os.Open(path)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("Read csv failing: %s", err)
+	}
+	want := [][]string{
+		{"a", "b", "c"},
+		{"1", "", ""},
+		{"2", "x,y", ""},
+		{"", "", "multi\nline"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("The records are %q, want %q", records, want)
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("The record %d is %q, want %q", i, records[i], want[i])
+		}
+	}
+}
+
+//超过尺寸上限时轮转,每个文件的表头都包含所有已知的列
+func TestCSVSinkRotation(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "items.csv")
+	sink, _ := NewCSVSink(NewSinkArgs(path, 1, 10))
+	sink.Process(base.Item{"a": "first"})
+	sink.Process(base.Item{"b": "second"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("An error occurs when closing: %s", err)
+	}
+	if lines := readLines(t, path); strings.Join(lines, "\n") != "a,b\nfirst," {
+		t.Errorf("The first file is %q", lines)
+	}
+	if lines := readLines(t, rotatedPath(path, 1)); strings.Join(lines, "\n") != "a,b\n,second" {
+		t.Errorf("The second file is %q", lines)
+	}
+}
+
+//统计表中的行
+func countRows(t *testing.T, path string, table string, where string) int {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Open %s failing: %s", path, err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + quoteIdent(table) + " WHERE " + where).Scan(&count); err != nil {
+		t.Fatalf("Query %s failing: %s", where, err)
+	}
+	return count
+}
+
+//无法写入的行会被丢弃,同一批中的其它行照常提交,只在大小写上不同的键不会使写入失败
+func TestSQLiteSinkIsolatesBadRows(t *testing.T) {
+	dir, cleanup := newTestDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "items.db")
+	sink, err := NewSQLiteSink(NewSinkArgs(path, 4, 0), "items")
+	if err != nil {
+		t.Fatalf("Create sink failing: %s", err)
+	}
+	items := []base.Item{
+		newTestItem("lower", "name", "a"),
+		newTestItem("bad", "name", "b", "score", math.NaN()),
+		newTestItem("both", "Name", "c", "name", "d"),
+		newTestItem("upper", "NAME", "e", "_ITEM", "f"),
+	}
+	for _, item := range items {
+		if _, err := sink.Process(item); err != nil {
+			t.Errorf("An error occurs when processing %s: %s", item.ID(), err)
+		}
+	}
+	err = sink.Flush()
+	if err == nil || !strings.Contains(err.Error(), "'bad'") {
+		t.Errorf("The error of the bad item is %v", err)
+	}
+	//被丢弃的行不会使缓冲一直无法写出
+	sink.Process(newTestItem("later", "name", "g"))
+	if err := sink.Close(); err != nil {
+		t.Errorf("An error occurs when closing: %s", err)
+	}
+	if count := countRows(t, path, "items", "1"); count != 4 {
+		t.Errorf("The row count is %d, want 4", count)
+	}
+	if count := countRows(t, path, "items", `"_id" = 'bad'`); count != 0 {
+		t.Errorf("The bad item is written")
+	}
+	//列由最先出现的键决定,其它键仍然保存在完整的条目中
+	if count := countRows(t, path, "items", `"_id" = 'both' AND "name" = 'c' AND "_item" LIKE '%"name":"d"%'`); count != 1 {
+		t.Errorf("The item with keys differing in case is not written correctly")
+	}
+	if count := countRows(t, path, "items", `"_id" = 'upper' AND "name" = 'e' AND "_item" LIKE '%"_ITEM":"f"%'`); count != 1 {
+		t.Errorf("The item with upper case keys is not written correctly")
+	}
+	//score列随被丢弃的行一起被回滚
+	if count := countRows(t, path, "sqlite_master", `sql LIKE '%score%'`); count != 0 {
+		t.Errorf("The column of the bad item is kept")
+	}
+}
@@ -0,0 +1,351 @@
+package itempipeline
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"summerWebCrawler/base"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//SQLite输出器
+//每个条目占表中的一行,完整的条目以JSON的形式保存在ITEM_COLUMN列中,
+//条目中的每个键还会被自动添加为同名的列,以便直接查询.
+//SQLite的列名不区分大小写,只在大小写上不同的键中只有最先出现的那个会得到自己的列,其它键只保存在ITEM_COLUMN列中.
+//每个条目都在自己的保存点中被写入,无法被写入的条目(如无法被编码的条目)会被丢弃,同一批中的其它条目不受影响
+type sqliteSink struct {
+	//参数
+	args SinkArgs
+	//表名
+	table string
+	//当前数据库文件的序号
+	seq int
+	//当前数据库
+	db *sql.DB
+	//当前数据库中已存在的列的集合,其中的列名都已被转换为小写
+	columnSet map[string]bool
+	//打开过的数据库文件的数量
+	fileCount uint32
+	//缓冲中的条目
+	buffer []base.Item
+	//已被写出或者在缓冲中的条目的ID的集合
+	ids map[string]bool
+	//已写出的条目的数量
+	written uint64
+	//因已被写出过而被跳过的条目的数量
+	skipped uint64
+	//因无法被写出而被丢弃的条目的数量
+	failed uint64
+	//还未被报告的被丢弃的条目的错误
+	pending []error
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+//SQLite输出器中的固定列
+const (
+	//保存完整条目的列
+	ITEM_COLUMN = "_item"
+	//保存写入时间的列
+	STORED_AT_COLUMN = "_stored_at"
+)
+
+var sqliteSummaryTemplate = "{type:sqlite, file:%s, table:%s, files:%d, columns:%d, written:%d, skipped:%d, failed:%d, buffered:%d, closed:%v}"
+
+//创建SQLite输出器
+//参数table代表表名,表不存在时会被自动创建
+//已存在的数据库文件不会被改写,新的条目会被写入新的文件.这些文件的表中的条目ID会被载入,对应的条目不会被再次写出
+func NewSQLiteSink(args SinkArgs, table string) (ItemSink, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(table) == "" {
+		return nil, errors.New("The table name can not be empty!")
+	}
+	ids, err := loadWrittenIds(args.Path(), func(path string) ([]string, error) {
+		return loadSQLiteIds(path, table)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteSink{
+		args:   args,
+		table:  table,
+		seq:    -1,
+		buffer: make([]base.Item, 0, args.BatchSize()),
+		ids:    ids,
+	}, nil
+}
+
+//读取数据库文件的表中ITEM_ID_KEY列的值
+//条目是在事务中被写入的,被中断的写入不会留下不完整的行
+func loadSQLiteIds(path string, table string) ([]string, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	idColumn := ""
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if strings.ToLower(name) == strings.ToLower(base.ITEM_ID_KEY) {
+			idColumn = name
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if idColumn == "" {
+		return nil, nil
+	}
+	rows, err = db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL",
+		quoteIdent(idColumn), quoteIdent(table), quoteIdent(idColumn)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (sink *sqliteSink) Process(item base.Item) (result base.Item, err error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil, errSinkClosed
+	}
+	if id := item.ID(); id != "" {
+		if sink.ids[id] {
+			//已被写出过的条目不会被重复写出
+			sink.skipped++
+			return item, nil
+		}
+		sink.ids[id] = true
+	}
+	sink.buffer = append(sink.buffer, copyItem(item))
+	if uint32(len(sink.buffer)) >= sink.args.BatchSize() {
+		current := len(sink.buffer) - 1
+		failed, err := sink.flush()
+		itemErr := settleErrors(failed, current, &sink.pending)
+		if err != nil {
+			return item, err
+		}
+		if itemErr != nil {
+			return item, itemErr
+		}
+	}
+	return item, nil
+}
+
+func (sink *sqliteSink) Flush() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return errSinkClosed
+	}
+	failed, err := sink.flush()
+	return takeErrors(failed, err, &sink.pending)
+}
+
+//在一个事务中写出缓冲中的条目,调用方需持有锁
+//结果值failed中是被丢弃的条目在缓冲中的序号和错误,err是使整个事务失败的错误,这时缓冲中的条目会被保留
+func (sink *sqliteSink) flush() (failed map[int]error, err error) {
+	failed = make(map[int]error)
+	if len(sink.buffer) == 0 {
+		return
+	}
+	if sink.db == nil {
+		if err := sink.openNext(); err != nil {
+			return failed, err
+		}
+	}
+	tx, err := sink.db.Begin()
+	if err != nil {
+		return failed, err
+	}
+	//事务失败时新发现的列也会被回滚
+	newColumns := make([]string, 0)
+	storedAt := time.Now().Format(time.RFC3339)
+	for i, item := range sink.buffer {
+		columns, itemErr, err := sink.insert(tx, item, storedAt)
+		newColumns = append(newColumns, columns...)
+		if err != nil {
+			tx.Rollback()
+			sink.forgetColumns(newColumns)
+			return make(map[int]error), err
+		}
+		if itemErr != nil {
+			failed[i] = itemWriteError(item, itemErr)
+			delete(sink.ids, item.ID())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		sink.forgetColumns(newColumns)
+		return make(map[int]error), err
+	}
+	sink.written += uint64(len(sink.buffer) - len(failed))
+	sink.failed += uint64(len(failed))
+	sink.buffer = sink.buffer[:0]
+	//数据库文件超过尺寸上限之后轮转到新的文件
+	if maxSize := sink.args.MaxFileSize(); maxSize > 0 {
+		if info, err := os.Stat(rotatedPath(sink.args.Path(), sink.seq)); err == nil && info.Size() > maxSize {
+			return failed, sink.openNext()
+		}
+	}
+	return failed, nil
+}
+
+//在保存点中写入一个条目
+//结果值columns是被添加并保留下来的列,itemErr是使该条目被丢弃的错误,err是使整个事务无法继续的错误
+func (sink *sqliteSink) insert(tx *sql.Tx, item base.Item, storedAt string) (columns []string, itemErr error, err error) {
+	normalized := normalizeItem(item)
+	content, itemErr := json.Marshal(normalized)
+	if itemErr != nil {
+		return nil, itemErr, nil
+	}
+	keys := make([]string, 0, len(normalized))
+	for k := range normalized {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if _, err := tx.Exec("SAVEPOINT item"); err != nil {
+		return nil, nil, err
+	}
+	names := []string{quoteIdent(ITEM_COLUMN), quoteIdent(STORED_AT_COLUMN)}
+	values := []interface{}{string(content), storedAt}
+	//本行中已使用的列,用于跳过只在大小写上不同的键
+	used := map[string]bool{strings.ToLower(ITEM_COLUMN): true, strings.ToLower(STORED_AT_COLUMN): true}
+	for _, k := range keys {
+		column := strings.ToLower(k)
+		if used[column] {
+			continue
+		}
+		used[column] = true
+		if !sink.columnSet[column] {
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdent(sink.table), quoteIdent(k))
+			if _, itemErr = tx.Exec(stmt); itemErr != nil {
+				break
+			}
+			sink.columnSet[column] = true
+			columns = append(columns, column)
+		}
+		names = append(names, quoteIdent(k))
+		values = append(values, formatCell(normalized[k]))
+	}
+	if itemErr == nil {
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			quoteIdent(sink.table),
+			strings.Join(names, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+		_, itemErr = tx.Exec(stmt, values...)
+	}
+	if itemErr != nil {
+		//回滚到保存点,该条目添加的列也会被撤销
+		sink.forgetColumns(columns)
+		columns = nil
+		if _, err := tx.Exec("ROLLBACK TO item"); err != nil {
+			return nil, itemErr, err
+		}
+	}
+	if _, err := tx.Exec("RELEASE item"); err != nil {
+		return columns, itemErr, err
+	}
+	return columns, itemErr, nil
+}
+
+//从列的集合中删除被回滚的列
+func (sink *sqliteSink) forgetColumns(columns []string) {
+	for _, column := range columns {
+		delete(sink.columnSet, column)
+	}
+}
+
+//打开下一个数据库文件并建表
+func (sink *sqliteSink) openNext() error {
+	if sink.db != nil {
+		if err := sink.db.Close(); err != nil {
+			return err
+		}
+		sink.db = nil
+	}
+	sink.seq = nextFreeSeq(sink.args.Path(), sink.seq+1)
+	db, err := sql.Open("sqlite3", rotatedPath(sink.args.Path(), sink.seq))
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT NOT NULL, %s TEXT NOT NULL)",
+		quoteIdent(sink.table), quoteIdent(ITEM_COLUMN), quoteIdent(STORED_AT_COLUMN))
+	if _, err := db.Exec(stmt); err != nil {
+		db.Close()
+		return err
+	}
+	sink.db = db
+	sink.columnSet = map[string]bool{strings.ToLower(ITEM_COLUMN): true, strings.ToLower(STORED_AT_COLUMN): true}
+	sink.fileCount++
+	return nil
+}
+
+func (sink *sqliteSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.closed {
+		return nil
+	}
+	failed, err := sink.flush()
+	err = takeErrors(failed, err, &sink.pending)
+	sink.closed = true
+	if sink.db != nil {
+		if closeErr := sink.db.Close(); err == nil {
+			err = closeErr
+		}
+		sink.db = nil
+	}
+	return err
+}
+
+func (sink *sqliteSink) Summary() string {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	seq := sink.seq
+	if seq < 0 {
+		seq = 0
+	}
+	return fmt.Sprintf(sqliteSummaryTemplate,
+		rotatedPath(sink.args.Path(), seq), sink.table, sink.fileCount, len(sink.columnSet),
+		sink.written, sink.skipped, sink.failed, len(sink.buffer), sink.closed)
+}
+
+//给标识符加上引号
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,480 @@
+package itempipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"summerWebCrawler/base"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//条目处理阶段
+//每个阶段都拥有自己的工作goroutine和有界队列.队列已满时,向该阶段发送条目的一方会被阻塞,
+//由此产生的反压会一直传递到调度器的条目通道
+type ItemStage struct {
+	//名称
+	name string
+	//条目处理器
+	processor ProcessItem
+	//工作goroutine的数量
+	workers uint32
+	//队列的长度
+	queueLen uint32
+	//条目输出器,仅对由条目输出器生成的阶段有效
+	sink ItemSink
+	//批量条目处理器,仅对批量处理阶段有效
+	batchProcessor BatchProcessItem
+	//批量大小
+	batchSize uint32
+	//批量写出的时间间隔
+	flushInterval time.Duration
+	//条目拆分器,仅对拆分阶段有效
+	splitter SplitItem
+	//路由分支的列表,仅对路由阶段有效
+	routes []Route
+	//错误策略
+	policy ErrorPolicy
+}
+
+//条目在管道中流转时的信封
+type envelope struct {
+	//条目
+	item base.Item
+	//条目ID,只在设置了条目账本时有效
+	id string
+}
+
+//阶段的运行时
+type stageRuntime struct {
+	//阶段
+	stage ItemStage
+	//队列
+	queue chan *envelope
+	//已处理的条目的数量
+	handled uint64
+	//处理出错的条目的数量
+	errors uint64
+	//已处理的批次的数量
+	batches uint64
+	//被丢弃的条目的数量
+	dropped uint64
+	//重试的次数
+	retried uint64
+	//被交给死信输出器的条目的数量
+	deadLettered uint64
+	//路由分支的运行时的列表
+	routes []*routeRuntime
+	//下一个阶段,为nil时表示当前阶段是所在序列中的最后一个阶段
+	next *stageRuntime
+	//所在序列结束之后条目要回到的路由阶段,主序列中的阶段为nil
+	exit *stageRuntime
+	//等待所有工作goroutine结束
+	wg sync.WaitGroup
+}
+
+//路由分支的运行时
+type routeRuntime struct {
+	//路由分支
+	route Route
+	//分支中的第一个阶段,分支中没有阶段时为nil
+	head *stageRuntime
+	//匹配该分支的条目的数量
+	matched uint64
+}
+
+var (
+	stageSummaryTemplate = "%s{workers:%d, queue:%d/%d, handled:%d, errors:%d, dropped:%d," +
+		" policy:%s, retried:%d, deadLettered:%d}"
+	batchStageSummaryTemplate = "%s{workers:%d, queue:%d/%d, batchSize:%d, batches:%d, handled:%d, errors:%d, dropped:%d," +
+		" policy:%s, retried:%d, deadLettered:%d}"
+	routerStageSummaryTemplate = "%s{workers:%d, queue:%d/%d, handled:%d, errors:%d," +
+		" policy:%s, deadLettered:%d, routes:%v}"
+	routeSummaryTemplate       = "%s{matched:%d, stages:%v}"
+	//批量处理阶段默认的写出时间间隔
+	defaultFlushInterval = time.Second
+	//由条目处理器转换而来的阶段的工作goroutine的数量和队列的长度
+	processorStageWorkers  uint32 = 4
+	processorStageQueueLen uint32 = 4
+)
+
+//创建条目处理阶段
+//参数workers代表工作goroutine的数量,0会被当作1
+//参数queueLen代表队列的长度,0表示不设缓冲,条目会被直接交给空闲的工作goroutine
+func NewItemStage(name string, processor ProcessItem, workers uint32, queueLen uint32) ItemStage {
+	if workers == 0 {
+		workers = 1
+	}
+	return ItemStage{
+		name:      name,
+		processor: processor,
+		workers:   workers,
+		queueLen:  queueLen,
+	}
+}
+
+//把条目处理器的序列转换为条目处理阶段的序列,每个条目处理器都会成为一个单独的阶段
+//这样只提供了条目处理器的调用方可以继续使用条目处理管道.各阶段依次被命名为processor0丶processor1...
+//参数processors中为nil的元素会使对应的阶段无效
+func NewProcessorStages(processors []ProcessItem) []ItemStage {
+	if processors == nil {
+		return nil
+	}
+	stages := make([]ItemStage, len(processors))
+	for i, processor := range processors {
+		stages[i] = NewItemStage(fmt.Sprintf("processor%d", i), processor, processorStageWorkers, processorStageQueueLen)
+	}
+	return stages
+}
+
+//根据条目输出器创建条目处理阶段
+//条目处理管道关闭时会关闭该条目输出器
+func NewSinkStage(sink ItemSink, workers uint32, queueLen uint32) ItemStage {
+	var processor ProcessItem
+	if sink != nil {
+		processor = sink.Process
+	}
+	stage := NewItemStage("sink", processor, workers, queueLen)
+	stage.sink = sink
+	return stage
+}
+
+//创建批量处理阶段
+//条目在凑满batchSize个,距离上一次处理超过flushInterval或者管道关闭时会被成批地交给processor.
+//参数flushInterval为0时会使用默认的时间间隔(1秒),以免零散的条目一直滞留在阶段中
+//批量处理阶段可以和普通的阶段混合使用,其中失败的条目会被逐个报告
+func NewBatchItemStage(name string,
+	processor BatchProcessItem,
+	workers uint32,
+	queueLen uint32,
+	batchSize uint32,
+	flushInterval time.Duration) ItemStage {
+	if workers == 0 {
+		workers = 1
+	}
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	return ItemStage{
+		name:           name,
+		batchProcessor: processor,
+		workers:        workers,
+		queueLen:       queueLen,
+		batchSize:      batchSize,
+		flushInterval:  flushInterval,
+	}
+}
+
+//获得名称
+func (stage ItemStage) Name() string {
+	return stage.name
+}
+
+//获得工作goroutine的数量
+func (stage ItemStage) Workers() uint32 {
+	return stage.workers
+}
+
+//获得队列的长度
+func (stage ItemStage) QueueLen() uint32 {
+	return stage.queueLen
+}
+
+//获得批量大小,普通的阶段总是返回1
+func (stage ItemStage) BatchSize() uint32 {
+	if !stage.Batched() {
+		return 1
+	}
+	return stage.batchSize
+}
+
+//判断是否是批量处理阶段
+func (stage ItemStage) Batched() bool {
+	return stage.batchProcessor != nil
+}
+
+//设置错误策略,结果值为设置了错误策略的阶段
+//未设置错误策略的阶段会使用创建条目处理管道时指定的默认错误策略(默认快速失败)
+func (stage ItemStage) WithErrorPolicy(policy ErrorPolicy) ItemStage {
+	stage.policy = policy
+	return stage
+}
+
+//获得错误策略
+func (stage ItemStage) ErrorPolicy() ErrorPolicy {
+	return stage.policy
+}
+
+//判断是否是路由阶段
+func (stage ItemStage) Routed() bool {
+	return stage.routes != nil
+}
+
+//检查阶段的有效性
+func (stage ItemStage) Check() error {
+	if strings.TrimSpace(stage.name) == "" {
+		return errors.New("The name of item stage can not be empty!")
+	}
+	if err := stage.policy.Check(); err != nil {
+		return errors.New(fmt.Sprintf("Invalid error policy of stage '%s': %s", stage.name, err))
+	}
+	if stage.routes != nil {
+		if len(stage.routes) == 0 {
+			return errors.New(fmt.Sprintf("The route list of stage '%s' is empty!", stage.name))
+		}
+		for _, route := range stage.routes {
+			if err := route.check(); err != nil {
+				return errors.New(fmt.Sprintf("Invalid router stage '%s': %s", stage.name, err))
+			}
+		}
+		return nil
+	}
+	if stage.processor == nil && stage.batchProcessor == nil && stage.splitter == nil {
+		return errors.New(fmt.Sprintf("The item processor of stage '%s' is invalid!", stage.name))
+	}
+	return nil
+}
+
+//创建阶段的运行时
+func newStageRuntime(stage ItemStage) *stageRuntime {
+	return &stageRuntime{
+		stage: stage,
+		queue: make(chan *envelope, stage.queueLen),
+	}
+}
+
+//调用条目处理器,处理器中出现的运行时恐慌会被转换为错误
+func (runtime *stageRuntime) process(item base.Item) (result base.Item, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Fatal Item Processing Error (stage=%s): %s", runtime.stage.name, p))
+		}
+		atomic.AddUint64(&runtime.handled, 1)
+		if err == ErrDropItem {
+			atomic.AddUint64(&runtime.dropped, 1)
+		} else if err != nil {
+			atomic.AddUint64(&runtime.errors, 1)
+		}
+	}()
+	return runtime.stage.processor(item)
+}
+
+//调用条目处理器,失败时按照错误策略进行重试
+func (runtime *stageRuntime) processWithRetry(item base.Item) (result base.Item, err error) {
+	result, err = runtime.process(item)
+	policy := runtime.stage.policy
+	if policy.kind != ERROR_POLICY_RETRY {
+		return
+	}
+	for attempt := uint32(0); attempt < policy.retries && err != nil && err != ErrDropItem; attempt++ {
+		time.Sleep(policy.retryDelay(attempt))
+		atomic.AddUint64(&runtime.retried, 1)
+		result, err = runtime.process(item)
+	}
+	return
+}
+
+//调用条目拆分器,失败时按照错误策略进行重试
+func (runtime *stageRuntime) splitWithRetry(item base.Item) (results []base.Item, err error) {
+	results, err = runtime.split(item)
+	policy := runtime.stage.policy
+	if policy.kind != ERROR_POLICY_RETRY {
+		return
+	}
+	for attempt := uint32(0); attempt < policy.retries && err != nil && err != ErrDropItem; attempt++ {
+		time.Sleep(policy.retryDelay(attempt))
+		atomic.AddUint64(&runtime.retried, 1)
+		results, err = runtime.split(item)
+	}
+	return
+}
+
+//调用条目拆分器,拆分器中出现的运行时恐慌会被转换为错误
+//拆分结果为空时条目会被视为被丢弃
+func (runtime *stageRuntime) split(item base.Item) (results []base.Item, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Fatal Item Processing Error (stage=%s): %s", runtime.stage.name, p))
+			results = nil
+		}
+		atomic.AddUint64(&runtime.handled, 1)
+		if err == nil && len(results) == 0 {
+			err = ErrDropItem
+		}
+		if err == ErrDropItem {
+			atomic.AddUint64(&runtime.dropped, 1)
+		} else if err != nil {
+			atomic.AddUint64(&runtime.errors, 1)
+		}
+	}()
+	results, err = runtime.stage.splitter(item)
+	//过滤掉空的条目
+	filtered := make([]base.Item, 0, len(results))
+	for _, result := range results {
+		if result != nil {
+			filtered = append(filtered, result)
+		}
+	}
+	results = filtered
+	return
+}
+
+//根据条目选择路由分支,没有匹配的分支时结果值为nil
+func (runtime *stageRuntime) route(item base.Item) (matched *routeRuntime, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Fatal Item Routing Error (stage=%s): %s", runtime.stage.name, p))
+			matched = nil
+		}
+		atomic.AddUint64(&runtime.handled, 1)
+		if err != nil {
+			atomic.AddUint64(&runtime.errors, 1)
+		} else if matched != nil {
+			atomic.AddUint64(&matched.matched, 1)
+		}
+	}()
+	for _, route := range runtime.routes {
+		if route.route.Match(item) {
+			return route, nil
+		}
+	}
+	return nil, nil
+}
+
+//调用批量条目处理器
+//结果值errs总是与items一一对应,处理器中出现的运行时恐慌会使整批条目都被视为失败
+func (runtime *stageRuntime) processBatch(items []base.Item) (results []base.Item, errs []error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err := errors.New(fmt.Sprintf("Fatal Item Processing Error (stage=%s): %s", runtime.stage.name, p))
+			results = nil
+			errs = make([]error, len(items))
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		atomic.AddUint64(&runtime.batches, 1)
+		atomic.AddUint64(&runtime.handled, uint64(len(items)))
+		for _, err := range errs {
+			if err == ErrDropItem {
+				atomic.AddUint64(&runtime.dropped, 1)
+			} else if err != nil {
+				atomic.AddUint64(&runtime.errors, 1)
+			}
+		}
+	}()
+	results, errs = runtime.stage.batchProcessor(items)
+	if results != nil && len(results) != len(items) {
+		err := errors.New(fmt.Sprintf("The batch processor of stage '%s' returned %d results for %d items!",
+			runtime.stage.name, len(results), len(items)))
+		results = nil
+		errs = make([]error, len(items))
+		for i := range errs {
+			errs[i] = err
+		}
+		return
+	}
+	if errs != nil && len(errs) != len(items) {
+		//无法确定哪些条目失败了,只能把整批条目都视为失败
+		err := errors.New(fmt.Sprintf("The batch processor of stage '%s' returned %d errors for %d items: %v",
+			runtime.stage.name, len(errs), len(items), errs))
+		errs = make([]error, len(items))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return
+}
+
+//调用批量条目处理器,失败时按照错误策略对其中失败的条目进行重试
+func (runtime *stageRuntime) processBatchWithRetry(items []base.Item) (results []base.Item, errs []error) {
+	results, errs = runtime.processBatch(items)
+	policy := runtime.stage.policy
+	if policy.kind != ERROR_POLICY_RETRY || errs == nil {
+		return
+	}
+	if results == nil {
+		results = make([]base.Item, len(items))
+	}
+	for attempt := uint32(0); attempt < policy.retries; attempt++ {
+		//只重试失败的条目
+		failed := make([]int, 0)
+		for i, err := range errs {
+			if err != nil && err != ErrDropItem {
+				failed = append(failed, i)
+			}
+		}
+		if len(failed) == 0 {
+			break
+		}
+		time.Sleep(policy.retryDelay(attempt))
+		atomic.AddUint64(&runtime.retried, uint64(len(failed)))
+		retryItems := make([]base.Item, len(failed))
+		for j, i := range failed {
+			retryItems[j] = items[i]
+		}
+		retryResults, retryErrs := runtime.processBatch(retryItems)
+		for j, i := range failed {
+			if retryResults != nil {
+				results[i] = retryResults[j]
+			}
+			if retryErrs != nil {
+				errs[i] = retryErrs[j]
+			} else {
+				errs[i] = nil
+			}
+		}
+	}
+	return
+}
+
+func (runtime *stageRuntime) summary() string {
+	if runtime.stage.Routed() {
+		routeSummaries := make([]string, 0, len(runtime.routes))
+		for _, route := range runtime.routes {
+			stageSummaries := make([]string, 0)
+			for stage := route.head; stage != nil; stage = stage.next {
+				stageSummaries = append(stageSummaries, stage.summary())
+			}
+			routeSummaries = append(routeSummaries, fmt.Sprintf(routeSummaryTemplate,
+				route.route.Name, atomic.LoadUint64(&route.matched), stageSummaries))
+		}
+		return fmt.Sprintf(routerStageSummaryTemplate,
+			runtime.stage.name,
+			runtime.stage.workers,
+			len(runtime.queue), cap(runtime.queue),
+			atomic.LoadUint64(&runtime.handled),
+			atomic.LoadUint64(&runtime.errors),
+			runtime.stage.policy,
+			atomic.LoadUint64(&runtime.deadLettered),
+			routeSummaries)
+	}
+	if runtime.stage.Batched() {
+		return fmt.Sprintf(batchStageSummaryTemplate,
+			runtime.stage.name,
+			runtime.stage.workers,
+			len(runtime.queue), cap(runtime.queue),
+			runtime.stage.batchSize,
+			atomic.LoadUint64(&runtime.batches),
+			atomic.LoadUint64(&runtime.handled),
+			atomic.LoadUint64(&runtime.errors),
+			atomic.LoadUint64(&runtime.dropped),
+			runtime.stage.policy,
+			atomic.LoadUint64(&runtime.retried),
+			atomic.LoadUint64(&runtime.deadLettered))
+	}
+	return fmt.Sprintf(stageSummaryTemplate,
+		runtime.stage.name,
+		runtime.stage.workers,
+		len(runtime.queue), cap(runtime.queue),
+		atomic.LoadUint64(&runtime.handled),
+		atomic.LoadUint64(&runtime.errors),
+		atomic.LoadUint64(&runtime.dropped),
+		runtime.stage.policy,
+		atomic.LoadUint64(&runtime.retried),
+		atomic.LoadUint64(&runtime.deadLettered))
+}
@@ -1,22 +1,51 @@
 package middleware
 
 import (
+	"context"
 	"reflect"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 //实体池的接口类型
 type Pool interface {
-	//取出实体
+	//取出实体,没有空闲的实体时会一直阻塞
 	Take() (Entity, error)
+	//取出实体,超过参数timeout仍没有空闲的实体时返回错误
+	TakeWithTimeout(timeout time.Duration) (Entity, error)
+	//取出实体,参数ctx被取消时返回它的错误
+	TakeContext(ctx context.Context) (Entity, error)
 	//归还实体
 	Return(entity Entity) error
 	//实体池的总量
 	Total() uint32
 	//实体池中已经被使用的实体的数量
 	Used() uint32
+	//调整实体池的总量
+	//扩大时通过实体的生成函数创建新的实体,缩小时先淘汰空闲的实体,不足的部分在实体被归还时淘汰
+	Resize(total uint32) error
+	//设置实体的健康检查函数,实体被归还时检查失败会被替换为新生成的实体
+	SetHealthCheck(check HealthCheck)
+	//获得被取出的时间超过参数threshold且尚未归还的实体,它们可能已经被泄漏
+	Leaks(threshold time.Duration) []Leak
+	//获得因健康检查失败而被替换的实体的数量
+	Replaced() uint32
+}
+
+//实体的健康检查函数的类型,返回false表示实体已损坏
+type HealthCheck func(entity Entity) bool
+
+//可能被泄漏的实体
+type Leak struct {
+	//实体的Id
+	Id uint32
+	//被取出的时间
+	TakenAt time.Time
+	//已被持有的时长
+	Held time.Duration
 }
 
 //实体接口类型
@@ -32,11 +61,23 @@ type myPool struct {
 	etype reflect.Type
 	//池中实体的生成函数
 	genEntity func() Entity
-	//实体容器
+	//实体容器,调整尺寸时会被替换为新容量的通道
 	container chan Entity
+	//调整尺寸的通知器,每次调整尺寸时被关闭并替换,用来唤醒等待在旧容器上的Take
+	resized chan struct{}
+	//已被取出且尚未归还的实体的数量
+	used uint32
+	//缩小尺寸之后等待被淘汰的实体的数量,这些实体会在被归还时淘汰
+	retiring uint32
 	//实体Id的容器
 	IDContainer map[uint32]bool
-	//针对实体ID容器操作的互斥锁
+	//已被取出的实体的Id到取出时间的映射
+	takenAt map[uint32]time.Time
+	//健康检查函数
+	healthCheck HealthCheck
+	//被替换的实体的数量
+	replaced uint32
+	//针对实体容器、实体ID容器和计数操作的互斥锁
 	mutex sync.Mutex
 }
 
@@ -56,11 +97,9 @@ func NewPool(total uint32, entityType reflect.Type, genEntity func() Entity) (Po
 	idContainer := make(map[uint32]bool)
 	for i := 0; i < size; i++ {
 		//生成一个实体
-		newEntity := genEntity()
-		//判断实体生成的类型是否和预期的一致
-		if entityType != reflect.TypeOf(newEntity) {
-			errMsg := fmt.Sprintf("The type of result of function genEntity() is not %s!\n", entityType)
-			return nil, errors.New(errMsg)
+		newEntity, err := genTypedEntity(genEntity, entityType)
+		if err != nil {
+			return nil, err
 		}
 		//把实体放入资源池
 		container <- newEntity
@@ -73,26 +112,70 @@ func NewPool(total uint32, entityType reflect.Type, genEntity func() Entity) (Po
 		etype:       entityType,
 		genEntity:   genEntity,
 		container:   container,
+		resized:     make(chan struct{}),
 		IDContainer: idContainer,
+		takenAt:     make(map[uint32]time.Time),
 	}
 
 	return pool, nil
 }
 
+//生成一个实体并判断实体生成的类型是否和预期的一致
+func genTypedEntity(genEntity func() Entity, entityType reflect.Type) (Entity, error) {
+	newEntity := genEntity()
+	if entityType != reflect.TypeOf(newEntity) {
+		errMsg := fmt.Sprintf("The type of result of function genEntity() is not %s!\n", entityType)
+		return nil, errors.New(errMsg)
+	}
+	return newEntity, nil
+}
+
 func (pool *myPool) Take() (Entity, error) {
-	//从实体容器返回一个实体
-	//channel是并发安全的,不需要加锁
-	entity, ok := <-pool.container
-	//资源池被关闭
-	if !ok {
-		return nil, errors.New("The inner container is invalid!")
+	return pool.take(context.Background())
+}
+
+func (pool *myPool) TakeWithTimeout(timeout time.Duration) (Entity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	entity, err := pool.take(ctx)
+	if err == context.DeadlineExceeded {
+		errMsg := fmt.Sprintf("Take entity timeout! (timeout=%s, total=%d, used=%d)\n", timeout, pool.Total(), pool.Used())
+		return nil, errors.New(errMsg)
+	}
+	return entity, err
+}
+
+func (pool *myPool) TakeContext(ctx context.Context) (Entity, error) {
+	return pool.take(ctx)
+}
+
+//取出实体,参数ctx被取消时返回它的错误
+func (pool *myPool) take(ctx context.Context) (Entity, error) {
+	for {
+		pool.mutex.Lock()
+		container, resized := pool.container, pool.resized
+		pool.mutex.Unlock()
+		//从实体容器返回一个实体
+		//channel是并发安全的,不需要加锁.容器被替换时通知器会被关闭,这时需要改为等待新的容器
+		select {
+		case entity, ok := <-container:
+			//资源池被关闭
+			if !ok {
+				return nil, errors.New("The inner container is invalid!")
+			}
+			//加锁
+			pool.mutex.Lock()
+			defer pool.mutex.Unlock()
+			//这里改变实体状态,用于辨别是否被拿出或在池中
+			pool.IDContainer[entity.Id()] = false
+			pool.takenAt[entity.Id()] = time.Now()
+			pool.used++
+			return entity, nil
+		case <-resized:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	//加锁
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
-	//这里改变实体状态,用于辨别是否被拿出或在池中
-	pool.IDContainer[entity.Id()] = false
-	return entity, nil
 }
 
 func (pool *myPool) Return(entity Entity) error {
@@ -105,49 +188,167 @@ func (pool *myPool) Return(entity Entity) error {
 		errMsg := fmt.Sprintf("The type of returning is not %s!\n", pool.etype)
 		return errors.New(errMsg)
 	}
+	//健康检查可能比较耗时,在加锁之前执行
+	pool.mutex.Lock()
+	check := pool.healthCheck
+	pool.mutex.Unlock()
+	healthy := check == nil || check(entity)
 	//获取实体Id判断是否是池中的实体
 	entityId := entity.Id()
-	casResult := pool.compareAndSetForIDContainer(entityId, false, true)
-	if casResult == 1 {
-		// 1:代表成功,这时可以把实体放入容器中
-		pool.container <- entity
-		return nil
-	} else if casResult == 0 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	inPool, ok := pool.IDContainer[entityId]
+	if !ok {
+		errMsg := fmt.Sprintf("The entity (id=%d) is illegal!\n", entityId)
+		return errors.New(errMsg)
+	}
+	if inPool {
 		errMsg := fmt.Sprintf("The entity (id=%d) is already in the pool\n", entityId)
 		return errors.New(errMsg)
-	} else {
-		errMsg := fmt.Sprintf("The entity (id=%d) is illegal!\n")
+	}
+	pool.used--
+	delete(pool.takenAt, entityId)
+	//池被缩小之后,归还的实体会被淘汰而不是放回容器
+	if pool.retiring > 0 {
+		pool.retiring--
+		delete(pool.IDContainer, entityId)
+		return nil
+	}
+	//损坏的实体被替换为新生成的实体,无法生成时仍放回原来的实体,下次归还时再尝试替换
+	var replaceErr error
+	if !healthy {
+		newEntity, err := genTypedEntity(pool.genEntity, pool.etype)
+		if err != nil {
+			replaceErr = errors.New(fmt.Sprintf("Replace the unhealthy entity (id=%d) failing: %s", entityId, err))
+		} else {
+			delete(pool.IDContainer, entityId)
+			entity = newEntity
+			entityId = newEntity.Id()
+			pool.replaced++
+		}
+	}
+	pool.IDContainer[entityId] = true
+	//容器的容量总是不小于池内和在外的实体的数量之和,这里不会阻塞
+	select {
+	case pool.container <- entity:
+		return replaceErr
+	default:
+		pool.IDContainer[entityId] = false
+		pool.used++
+		errMsg := fmt.Sprintf("The pool is full! (entity id=%d)\n", entityId)
 		return errors.New(errMsg)
 	}
 }
 
-//比较并设置实体ID容器中与给定实体ID对应的键值对的元素值
-//结果值:
-// -1:表示键值对不存在
-//  0:表示操作失败
-//  1:表示操作成功
-func (pool *myPool) compareAndSetForIDContainer(entityId uint32, oldValue bool, newValue bool) int8 {
+func (pool *myPool) Resize(total uint32) error {
+	if total == 0 {
+		errMsg := fmt.Sprintf("The pool can not be resized!(total= %d)\n", total)
+		return errors.New(errMsg)
+	}
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
-	v, ok := pool.IDContainer[entityId]
-	if !ok {
-		return -1
+	if total == pool.totla {
+		return nil
 	}
-	//取出一个值得时候 IDContainer 的对应map值会变为false
-	//这里对比池内的值和预期值是否一致
-	if v != oldValue {
-		return 0
+	if total > pool.totla {
+		//先取消等待中的淘汰,不足的部分再创建新的实体
+		grow := total - pool.totla
+		cancel := pool.retiring
+		if cancel > grow {
+			cancel = grow
+		}
+		newEntities := make([]Entity, 0, grow-cancel)
+		for i := cancel; i < grow; i++ {
+			newEntity, err := genTypedEntity(pool.genEntity, pool.etype)
+			if err != nil {
+				return err
+			}
+			newEntities = append(newEntities, newEntity)
+		}
+		pool.retiring -= cancel
+		for _, newEntity := range newEntities {
+			pool.IDContainer[newEntity.Id()] = true
+		}
+		pool.swapContainer(total, append(pool.drain(), newEntities...))
+	} else {
+		//先淘汰空闲的实体,不足的部分在实体被归还时淘汰
+		shrink := pool.totla - total
+		idle := pool.drain()
+		for shrink > 0 && len(idle) > 0 {
+			delete(pool.IDContainer, idle[len(idle)-1].Id())
+			idle = idle[:len(idle)-1]
+			shrink--
+		}
+		pool.retiring += shrink
+		pool.swapContainer(total, idle)
 	}
+	pool.totla = total
+	return nil
+}
+
+//取出容器中所有空闲的实体,调用方需持有锁
+//取出的同时可能有Take从容器中取走实体,因此这里不能阻塞
+func (pool *myPool) drain() []Entity {
+	var idle []Entity
+	for {
+		select {
+		case entity := <-pool.container:
+			idle = append(idle, entity)
+		default:
+			return idle
+		}
+	}
+}
 
-	pool.IDContainer[entityId] = newValue
-	return 1
+//换成新容量的容器并放入空闲的实体,然后唤醒等待在旧容器上的Take,调用方需持有锁
+func (pool *myPool) swapContainer(total uint32, idle []Entity) {
+	container := make(chan Entity, total)
+	for _, entity := range idle {
+		container <- entity
+	}
+	pool.container = container
+	close(pool.resized)
+	pool.resized = make(chan struct{})
 }
 
 func (pool *myPool) Total() uint32 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
 	return pool.totla
 }
 
+//缩小尺寸之后,在等待被淘汰的实体被归还之前,使用的数量可能大于总量
 func (pool *myPool) Used() uint32 {
-	//总量 - 池内剩余实体 = 使用的实体
-	return pool.totla - uint32(len(pool.container))
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.used
+}
+
+func (pool *myPool) SetHealthCheck(check HealthCheck) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.healthCheck = check
+}
+
+func (pool *myPool) Leaks(threshold time.Duration) []Leak {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := time.Now()
+	var leaks []Leak
+	for id, takenAt := range pool.takenAt {
+		if held := now.Sub(takenAt); held > threshold {
+			leaks = append(leaks, Leak{Id: id, TakenAt: takenAt, Held: held})
+		}
+	}
+	//被持有最久的实体排在最前面
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].TakenAt.Before(leaks[j].TakenAt)
+	})
+	return leaks
+}
+
+func (pool *myPool) Replaced() uint32 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.replaced
 }
@@ -0,0 +1,285 @@
+package middleware
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//测试用的实体
+type testEntity struct {
+	id uint32
+	//是否已损坏
+	broken bool
+}
+
+func (entity *testEntity) Id() uint32 {
+	return entity.id
+}
+
+//创建测试用的实体池,返回实体池和已生成的实体的数量
+func newTestPool(t *testing.T, total uint32) (Pool, *uint32) {
+	idGenertor := NewIdGenertor()
+	var generated uint32
+	pool, err := NewPool(total, reflect.TypeOf(&testEntity{}), func() Entity {
+		atomic.AddUint32(&generated, 1)
+		return &testEntity{id: idGenertor.GetUint32()}
+	})
+	if err != nil {
+		t.Fatalf("Create pool failing: %s", err)
+	}
+	return pool, &generated
+}
+
+//取出n个实体
+func takeN(t *testing.T, pool Pool, n int) []Entity {
+	entities := make([]Entity, 0, n)
+	for i := 0; i < n; i++ {
+		entity, err := pool.TakeWithTimeout(time.Second)
+		if err != nil {
+			t.Fatalf("Take the %dth entity failing: %s", i, err)
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+//判断实体池中是否还能取出实体
+func canTake(pool Pool) bool {
+	entity, err := pool.TakeWithTimeout(20 * time.Millisecond)
+	if err != nil {
+		return false
+	}
+	pool.Return(entity)
+	return true
+}
+
+func TestPoolShrinkWhileTaken(t *testing.T) {
+	pool, _ := newTestPool(t, 3)
+	taken := takeN(t, pool, 2)
+	//空闲的实体先被淘汰,不足的部分在实体被归还时淘汰
+	if err := pool.Resize(1); err != nil {
+		t.Fatalf("Resize failing: %s", err)
+	}
+	if total, used := pool.Total(), pool.Used(); total != 1 || used != 2 {
+		t.Fatalf("The pool is (total=%d, used=%d), want (1, 2)", total, used)
+	}
+	if canTake(pool) {
+		t.Fatalf("Take from a pool whose entities are all in use")
+	}
+	for _, entity := range taken {
+		if err := pool.Return(entity); err != nil {
+			t.Fatalf("Return failing: %s", err)
+		}
+	}
+	if used := pool.Used(); used != 0 {
+		t.Fatalf("The used number is %d, want 0", used)
+	}
+	//被淘汰的实体不能再被归还
+	if err := pool.Return(taken[0]); err == nil {
+		t.Errorf("Return a retired entity succeeds")
+	}
+	remained := takeN(t, pool, 1)
+	if canTake(pool) {
+		t.Errorf("The pool has more than 1 entity after shrinking")
+	}
+	pool.Return(remained[0])
+}
+
+func TestPoolGrowWhileTaken(t *testing.T) {
+	pool, generated := newTestPool(t, 2)
+	taken := takeN(t, pool, 2)
+	if err := pool.Resize(1); err != nil {
+		t.Fatalf("Resize failing: %s", err)
+	}
+	//扩大时先取消等待中的淘汰,不创建新的实体
+	if err := pool.Resize(2); err != nil {
+		t.Fatalf("Resize failing: %s", err)
+	}
+	if n := atomic.LoadUint32(generated); n != 2 {
+		t.Fatalf("%d entities are generated, want 2", n)
+	}
+	if err := pool.Resize(4); err != nil {
+		t.Fatalf("Resize failing: %s", err)
+	}
+	if n := atomic.LoadUint32(generated); n != 4 {
+		t.Fatalf("%d entities are generated, want 4", n)
+	}
+	for _, entity := range taken {
+		if err := pool.Return(entity); err != nil {
+			t.Fatalf("Return failing: %s", err)
+		}
+	}
+	all := takeN(t, pool, 4)
+	if canTake(pool) {
+		t.Errorf("The pool has more than 4 entities after growing")
+	}
+	ids := make(map[uint32]bool)
+	for _, entity := range all {
+		ids[entity.Id()] = true
+		pool.Return(entity)
+	}
+	if len(ids) != 4 {
+		t.Errorf("The pool has %d distinct entities, want 4", len(ids))
+	}
+}
+
+func TestPoolTakeBlockedAcrossResize(t *testing.T) {
+	pool, _ := newTestPool(t, 1)
+	taken := takeN(t, pool, 1)
+	result := make(chan error, 1)
+	go func() {
+		_, err := pool.Take()
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("Take returns before the pool is resized: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	//等待在旧容器上的Take会被唤醒并从新容器中取出实体
+	if err := pool.Resize(2); err != nil {
+		t.Fatalf("Resize failing: %s", err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Take failing: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Take is still blocked after the pool is resized")
+	}
+	pool.Return(taken[0])
+}
+
+func TestPoolTakeTimeoutAndCancel(t *testing.T) {
+	pool, _ := newTestPool(t, 1)
+	taken := takeN(t, pool, 1)
+	start := time.Now()
+	if _, err := pool.TakeWithTimeout(30 * time.Millisecond); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("TakeWithTimeout returns %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("TakeWithTimeout returns after %s, before the timeout", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := pool.TakeContext(ctx); err != context.Canceled {
+		t.Fatalf("TakeContext returns %v, want %v", err, context.Canceled)
+	}
+	//超时和取消都不会改变使用的数量
+	if used := pool.Used(); used != 1 {
+		t.Errorf("The used number is %d, want 1", used)
+	}
+	pool.Return(taken[0])
+	if !canTake(pool) {
+		t.Errorf("Take failing after the entity is returned")
+	}
+}
+
+func TestPoolReplaceUnhealthyEntity(t *testing.T) {
+	pool, _ := newTestPool(t, 1)
+	pool.SetHealthCheck(func(entity Entity) bool {
+		return !entity.(*testEntity).broken
+	})
+	entity := takeN(t, pool, 1)[0]
+	entity.(*testEntity).broken = true
+	if err := pool.Return(entity); err != nil {
+		t.Fatalf("Return failing: %s", err)
+	}
+	if replaced := pool.Replaced(); replaced != 1 {
+		t.Fatalf("%d entities are replaced, want 1", replaced)
+	}
+	replacement := takeN(t, pool, 1)[0]
+	if replacement.Id() == entity.Id() || replacement.(*testEntity).broken {
+		t.Fatalf("The unhealthy entity %d is not replaced", entity.Id())
+	}
+	//被替换的实体不再属于实体池
+	if err := pool.Return(entity); err == nil {
+		t.Errorf("Return a replaced entity succeeds")
+	}
+	if err := pool.Return(replacement); err != nil {
+		t.Fatalf("Return failing: %s", err)
+	}
+	if replaced := pool.Replaced(); replaced != 1 {
+		t.Errorf("%d entities are replaced, want 1", replaced)
+	}
+}
+
+func TestPoolLeaks(t *testing.T) {
+	pool, _ := newTestPool(t, 2)
+	taken := takeN(t, pool, 2)
+	time.Sleep(30 * time.Millisecond)
+	if err := pool.Return(taken[1]); err != nil {
+		t.Fatalf("Return failing: %s", err)
+	}
+	leaks := pool.Leaks(20 * time.Millisecond)
+	if len(leaks) != 1 || leaks[0].Id != taken[0].Id() {
+		t.Fatalf("The leaks are %v, want only the entity %d", leaks, taken[0].Id())
+	}
+	if leaks[0].Held < 30*time.Millisecond {
+		t.Errorf("The entity is held for %s, want at least 30ms", leaks[0].Held)
+	}
+	if leaks := pool.Leaks(time.Hour); len(leaks) != 0 {
+		t.Errorf("The leaks under a long threshold are %v, want none", leaks)
+	}
+	pool.Return(taken[0])
+	if leaks := pool.Leaks(0); len(leaks) != 0 {
+		t.Errorf("The leaks after returning are %v, want none", leaks)
+	}
+}
+
+func TestPoolConcurrentResize(t *testing.T) {
+	pool, _ := newTestPool(t, 4)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	const workers = 8
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				entity, err := pool.TakeWithTimeout(10 * time.Millisecond)
+				if err != nil {
+					continue
+				}
+				time.Sleep(time.Millisecond)
+				if err := pool.Return(entity); err != nil {
+					t.Errorf("Return failing: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	for _, total := range []uint32{1, 6, 2, 8, 3, 3, 5, 1, 4} {
+		if err := pool.Resize(total); err != nil {
+			t.Fatalf("Resize failing: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(quit)
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+	//所有实体都被归还之后,池中的实体数量与总量相同
+	if used := pool.Used(); used != 0 {
+		t.Fatalf("The used number is %d, want 0", used)
+	}
+	all := takeN(t, pool, 4)
+	if canTake(pool) {
+		t.Errorf("The pool has more than 4 entities")
+	}
+	for _, entity := range all {
+		pool.Return(entity)
+	}
+}
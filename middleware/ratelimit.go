@@ -0,0 +1,433 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//速率限制器的接口类型
+//它由一组令牌桶组成,每个请求在被下载之前需要从所有适用于它的令牌桶中各取出一个令牌
+type RateLimiter interface {
+	//等待所有适用于参数reqUrl的令牌桶都有令牌,返回等待的时长.参数ctx被取消时返回它的错误
+	Wait(ctx context.Context, reqUrl *url.URL) (time.Duration, error)
+	//从所有适用于参数reqUrl的令牌桶中预约令牌但不等待,返回请求在被下载之前还需要等待的时长.
+	//预约的令牌不会被归还,调用方需要在等待之后下载该请求
+	Reserve(reqUrl *url.URL) (time.Duration, error)
+	//替换速率限制的规则,可以在运行期间调用.规则不变的令牌桶会保留其中的令牌
+	SetRules(rules []RateRule) error
+	//获得每条规则的统计信息
+	Stats() []RateStats
+	//获取摘要信息
+	Summary() string
+}
+
+//速率限制的范围
+type RateScope int
+
+const (
+	//所有请求共用一个令牌桶
+	RATE_SCOPE_GLOBAL RateScope = iota
+	//每个主机一个令牌桶
+	RATE_SCOPE_HOST
+	//url匹配同一个正则表达式的请求共用一个令牌桶
+	RATE_SCOPE_PATTERN
+)
+
+func (scope RateScope) String() string {
+	switch scope {
+	case RATE_SCOPE_GLOBAL:
+		return "global"
+	case RATE_SCOPE_HOST:
+		return "host"
+	case RATE_SCOPE_PATTERN:
+		return "pattern"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(scope))
+	}
+}
+
+//速率限制的规则
+type RateRule struct {
+	//范围
+	scope RateScope
+	//对于主机范围是主机名,为空时适用于没有单独规则的所有主机;对于url范围是匹配url的正则表达式
+	pattern string
+	//每秒的请求数
+	rate float64
+	//令牌桶的容量,即允许的突发请求数
+	burst uint32
+	//编译后的正则表达式
+	regexp *regexp.Regexp
+	//描述
+	description string
+}
+
+var rateRuleTemplate = "%s(%s){rate:%g/s, burst:%d}"
+
+//创建速率限制的规则
+func NewRateRule(scope RateScope, pattern string, rate float64, burst uint32) RateRule {
+	return RateRule{
+		scope:   scope,
+		pattern: pattern,
+		rate:    rate,
+		burst:   burst,
+	}
+}
+
+//获得范围
+func (rule *RateRule) Scope() RateScope {
+	return rule.scope
+}
+
+//获得主机名或者正则表达式
+func (rule *RateRule) Pattern() string {
+	return rule.pattern
+}
+
+//获得每秒的请求数
+func (rule *RateRule) Rate() float64 {
+	return rule.rate
+}
+
+//获得令牌桶的容量
+func (rule *RateRule) Burst() uint32 {
+	return rule.burst
+}
+
+func (rule *RateRule) Check() error {
+	if rule.rate <= 0 || math.IsInf(rule.rate, 0) || math.IsNaN(rule.rate) {
+		return errors.New(fmt.Sprintf("The rate of rule %s is invalid!", rule.key()))
+	}
+	if rule.burst == 0 {
+		return errors.New(fmt.Sprintf("The burst of rule %s can not be 0!", rule.key()))
+	}
+	switch rule.scope {
+	case RATE_SCOPE_GLOBAL:
+	case RATE_SCOPE_HOST:
+		rule.pattern = strings.ToLower(rule.pattern)
+	case RATE_SCOPE_PATTERN:
+		if rule.pattern == "" {
+			return errors.New("The pattern of url rule can not be empty!")
+		}
+		re, err := regexp.Compile(rule.pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The pattern of rule %s is invalid: %s", rule.key(), err))
+		}
+		rule.regexp = re
+	default:
+		return errors.New(fmt.Sprintf("The scope of rule %s is invalid!", rule.key()))
+	}
+	return nil
+}
+
+func (rule *RateRule) String() string {
+	if rule.description == "" {
+		rule.description = fmt.Sprintf(rateRuleTemplate, rule.scope, rule.pattern, rule.rate, rule.burst)
+	}
+	return rule.description
+}
+
+//规则的键,范围和模式相同的规则被视为同一条规则
+func (rule *RateRule) key() string {
+	return rule.scope.String() + "(" + rule.pattern + ")"
+}
+
+//规则的统计信息
+type RateStats struct {
+	//规则
+	Rule string
+	//适用该规则的请求的数量
+	Requests uint64
+	//因该规则而需要等待的请求的数量
+	Waited uint64
+	//因该规则而等待的总时长
+	WaitTime time.Duration
+	//因该规则而等待的最大时长
+	MaxWait time.Duration
+}
+
+//记录一次等待
+func (stats *RateStats) add(wait time.Duration) {
+	stats.Requests++
+	if wait <= 0 {
+		return
+	}
+	stats.Waited++
+	stats.WaitTime += wait
+	if wait > stats.MaxWait {
+		stats.MaxWait = wait
+	}
+}
+
+//令牌桶
+//令牌数可以为负数,代表已经被预约的令牌,预约者需要等待令牌数恢复到0
+type tokenBucket struct {
+	//每秒补充的令牌数
+	rate float64
+	//容量
+	burst float64
+	//当前的令牌数
+	tokens float64
+	//最近一次补充令牌的时间
+	last time.Time
+}
+
+//创建装满令牌的令牌桶
+func newTokenBucket(rate float64, burst uint32, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+//补充令牌
+func (bucket *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = math.Min(bucket.burst, bucket.tokens+elapsed.Seconds()*bucket.rate)
+		bucket.last = now
+	}
+}
+
+//预约一个令牌,返回需要等待的时长
+func (bucket *tokenBucket) reserve(now time.Time) time.Duration {
+	bucket.refill(now)
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+//归还一个预约的令牌,令牌数不会超过容量
+func (bucket *tokenBucket) cancel() {
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+1)
+}
+
+//调整速率和容量,已补充的令牌会被保留
+func (bucket *tokenBucket) set(rate float64, burst uint32, now time.Time) {
+	bucket.refill(now)
+	bucket.rate = rate
+	bucket.burst = float64(burst)
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+}
+
+//令牌桶的键
+type bucketKey struct {
+	//规则的键
+	rule string
+	//主机名,只有主机范围的默认规则的令牌桶才有
+	host string
+}
+
+//速率限制器的实现类型
+type myRateLimiter struct {
+	//规则
+	rules []RateRule
+	//令牌桶
+	buckets map[bucketKey]*tokenBucket
+	//每条规则的统计信息,键是规则的键
+	stats map[string]*RateStats
+	//所有请求的统计信息,等待的时长是各规则的等待时长的最大值
+	total RateStats
+	//最近一次清理令牌桶的时间
+	lastSweep time.Time
+	//互斥锁
+	mutex sync.Mutex
+}
+
+//清理令牌桶的时间间隔,已经装满的主机令牌桶与新建的没有区别,可以被删除
+const rateSweepInterval = time.Minute
+
+var rateLimiterSummaryTemplate = "{rules:%d, buckets:%d, requests:%d, waited:%d, waitTime:%s, maxWait:%s}"
+
+//创建速率限制器
+func NewRateLimiter(rules []RateRule) (RateLimiter, error) {
+	limiter := &myRateLimiter{
+		buckets:   make(map[bucketKey]*tokenBucket),
+		stats:     make(map[string]*RateStats),
+		lastSweep: time.Now(),
+	}
+	if err := limiter.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return limiter, nil
+}
+
+func (limiter *myRateLimiter) SetRules(rules []RateRule) error {
+	checked := make([]RateRule, len(rules))
+	keys := make(map[string]bool)
+	for i, rule := range rules {
+		if err := rule.Check(); err != nil {
+			return err
+		}
+		if keys[rule.key()] {
+			return errors.New(fmt.Sprintf("The rule %s is duplicated!", rule.key()))
+		}
+		keys[rule.key()] = true
+		checked[i] = rule
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	ruleOf := make(map[string]*RateRule, len(checked))
+	for i := range checked {
+		ruleOf[checked[i].key()] = &checked[i]
+	}
+	for key, bucket := range limiter.buckets {
+		rule, ok := ruleOf[key.rule]
+		if !ok {
+			delete(limiter.buckets, key)
+			continue
+		}
+		bucket.set(rule.rate, rule.burst, now)
+	}
+	for key := range limiter.stats {
+		if !keys[key] {
+			delete(limiter.stats, key)
+		}
+	}
+	for key := range keys {
+		if _, ok := limiter.stats[key]; !ok {
+			limiter.stats[key] = &RateStats{}
+		}
+	}
+	limiter.rules = checked
+	return nil
+}
+
+func (limiter *myRateLimiter) Wait(ctx context.Context, reqUrl *url.URL) (time.Duration, error) {
+	if reqUrl == nil {
+		return 0, errors.New("The url is invalid!")
+	}
+	wait, buckets := limiter.reserve(reqUrl, time.Now())
+	if wait <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		//没有被使用的令牌要还回去,否则之后的请求会为它们多等待
+		limiter.refund(buckets)
+		return 0, ctx.Err()
+	}
+}
+
+func (limiter *myRateLimiter) Reserve(reqUrl *url.URL) (time.Duration, error) {
+	if reqUrl == nil {
+		return 0, errors.New("The url is invalid!")
+	}
+	wait, _ := limiter.reserve(reqUrl, time.Now())
+	return wait, nil
+}
+
+//从所有适用的令牌桶中预约令牌,返回需要等待的时长和被预约了令牌的令牌桶
+func (limiter *myRateLimiter) reserve(reqUrl *url.URL, now time.Time) (time.Duration, []*tokenBucket) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if now.Sub(limiter.lastSweep) >= rateSweepInterval {
+		limiter.sweep(now)
+	}
+	host := strings.ToLower(reqUrl.Hostname())
+	rawUrl := reqUrl.String()
+	//有单独规则的主机不使用默认规则
+	hostMatched := false
+	for i := range limiter.rules {
+		rule := &limiter.rules[i]
+		if rule.scope == RATE_SCOPE_HOST && rule.pattern != "" && rule.pattern == host {
+			hostMatched = true
+			break
+		}
+	}
+	var wait time.Duration
+	var reserved []*tokenBucket
+	for i := range limiter.rules {
+		rule := &limiter.rules[i]
+		key := bucketKey{rule: rule.key()}
+		switch rule.scope {
+		case RATE_SCOPE_HOST:
+			if rule.pattern == "" {
+				if hostMatched {
+					continue
+				}
+				key.host = host
+			} else if rule.pattern != host {
+				continue
+			}
+		case RATE_SCOPE_PATTERN:
+			if !rule.regexp.MatchString(rawUrl) {
+				continue
+			}
+		}
+		bucket, ok := limiter.buckets[key]
+		if !ok {
+			bucket = newTokenBucket(rule.rate, rule.burst, now)
+			limiter.buckets[key] = bucket
+		}
+		ruleWait := bucket.reserve(now)
+		reserved = append(reserved, bucket)
+		limiter.stats[key.rule].add(ruleWait)
+		if ruleWait > wait {
+			wait = ruleWait
+		}
+	}
+	limiter.total.add(wait)
+	return wait, reserved
+}
+
+//归还预约的令牌
+//令牌桶在预约之后可能已经因规则被替换而被删除,向它归还令牌不会产生影响
+func (limiter *myRateLimiter) refund(buckets []*tokenBucket) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for _, bucket := range buckets {
+		bucket.cancel()
+	}
+}
+
+//删除已经装满的主机令牌桶,调用方需持有锁
+func (limiter *myRateLimiter) sweep(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		if key.host == "" {
+			continue
+		}
+		bucket.refill(now)
+		if bucket.tokens >= bucket.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+func (limiter *myRateLimiter) Stats() []RateStats {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	stats := make([]RateStats, 0, len(limiter.rules))
+	for i := range limiter.rules {
+		rule := &limiter.rules[i]
+		ruleStats := *limiter.stats[rule.key()]
+		ruleStats.Rule = rule.String()
+		stats = append(stats, ruleStats)
+	}
+	return stats
+}
+
+func (limiter *myRateLimiter) Summary() string {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return fmt.Sprintf(rateLimiterSummaryTemplate,
+		len(limiter.rules),
+		len(limiter.buckets),
+		limiter.total.Requests,
+		limiter.total.Waited,
+		limiter.total.WaitTime.Truncate(time.Millisecond),
+		limiter.total.MaxWait.Truncate(time.Millisecond))
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+//创建速率限制器
+func newTestLimiter(t *testing.T, rules ...RateRule) *myRateLimiter {
+	limiter, err := NewRateLimiter(rules)
+	if err != nil {
+		t.Fatalf("Create rate limiter failing: %s", err)
+	}
+	return limiter.(*myRateLimiter)
+}
+
+//解析url
+func mustParseUrl(t *testing.T, rawUrl string) *url.URL {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("Parse url failing: %s", err)
+	}
+	return u
+}
+
+func TestRateLimiterWaitsForTokens(t *testing.T) {
+	limiter := newTestLimiter(t, NewRateRule(RATE_SCOPE_HOST, "", 1, 1))
+	reqUrl := mustParseUrl(t, "http://a.example.com/")
+	now := time.Now()
+	if wait, _ := limiter.reserve(reqUrl, now); wait != 0 {
+		t.Fatalf("The first request waits %s, want 0", wait)
+	}
+	if wait, _ := limiter.reserve(reqUrl, now); wait != time.Second {
+		t.Fatalf("The second request waits %s, want 1s", wait)
+	}
+	//其他主机使用自己的令牌桶
+	if wait, _ := limiter.reserve(mustParseUrl(t, "http://b.example.com/"), now); wait != 0 {
+		t.Fatalf("The request to another host waits %s, want 0", wait)
+	}
+}
+
+func TestRateLimiterRefundsOnCancel(t *testing.T) {
+	limiter := newTestLimiter(t,
+		NewRateRule(RATE_SCOPE_GLOBAL, "", 1, 1),
+		NewRateRule(RATE_SCOPE_HOST, "", 1, 1))
+	reqUrl := mustParseUrl(t, "http://a.example.com/")
+	if _, err := limiter.Wait(context.Background(), reqUrl); err != nil {
+		t.Fatalf("Wait failing: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Wait(ctx, reqUrl); err != context.DeadlineExceeded {
+		t.Fatalf("Wait returns %v, want %v", err, context.DeadlineExceeded)
+	}
+	//被取消的请求预约的令牌已经归还,下一个请求只需要等待第一个请求之后的令牌
+	wait, _ := limiter.reserve(reqUrl, time.Now())
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("The request after the cancelled one waits %s, want (0, 1s]", wait)
+	}
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens < -1 {
+			t.Errorf("The bucket %v has %g tokens, the cancelled reservation is not refunded", key, bucket.tokens)
+		}
+	}
+}
+
+func TestRateLimiterRefundKeepsBurst(t *testing.T) {
+	limiter := newTestLimiter(t, NewRateRule(RATE_SCOPE_GLOBAL, "", 1, 2))
+	reqUrl := mustParseUrl(t, "http://a.example.com/")
+	_, buckets := limiter.reserve(reqUrl, time.Now())
+	limiter.refund(buckets)
+	limiter.refund(buckets)
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens > bucket.burst {
+			t.Errorf("The bucket %v has %g tokens, more than its burst %g", key, bucket.tokens, bucket.burst)
+		}
+	}
+}
+
+func TestRateLimiterReserveDoesNotBlock(t *testing.T) {
+	limiter := newTestLimiter(t, NewRateRule(RATE_SCOPE_PATTERN, "/slow/", 1, 1))
+	slowUrl := mustParseUrl(t, "http://a.example.com/slow/1")
+	start := time.Now()
+	var waits []time.Duration
+	for i := 0; i < 3; i++ {
+		wait, err := limiter.Reserve(slowUrl)
+		if err != nil {
+			t.Fatalf("Reserve failing: %s", err)
+		}
+		waits = append(waits, wait)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Reserve blocks for %s", elapsed)
+	}
+	//每次预约都占用一个令牌,等待的时长依次增加
+	if waits[0] != 0 || waits[1] <= 0 || waits[2] <= waits[1] {
+		t.Fatalf("The waits are %v, want increasing waits after the first one", waits)
+	}
+	if wait, _ := limiter.Reserve(mustParseUrl(t, "http://a.example.com/fast/1")); wait != 0 {
+		t.Fatalf("The request not matching any rule waits %s, want 0", wait)
+	}
+	if _, err := limiter.Reserve(nil); err == nil {
+		t.Fatalf("Reserve with nil url should fail")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"summerWebCrawler/base"
 	"sync"
 	"fmt"
+	"sort"
+	"time"
 )
 
 //请求缓存类型
@@ -12,9 +14,15 @@ type requestCache interface {
 	put(req *base.Request) bool
 	//从请求缓存中获最早被放入且人在其中的请求
 	get() *base.Request
+	//将需要等到readyAt才能被下载的请求放入请求缓存,它不会被get取出
+	delay(req *base.Request, readyAt time.Time) bool
+	//取出一个已经到期的被延迟的请求,没有时返回nil
+	getDue(now time.Time) *base.Request
+	//获得被延迟的请求的数量
+	delayedLength() int
 	//获得请求缓存的容量
 	capacity() int
-	//获得请求缓存的实时长度,即其中的请求的即时数量
+	//获得请求缓存的实时长度,即其中的请求的即时数量,包括被延迟的请求
 	length() int
 	//关闭请求缓存
 	close()
@@ -22,15 +30,24 @@ type requestCache interface {
 	summary() string
 }
 
+//被延迟的请求
+type delayedRequest struct {
+	req *base.Request
+	//可以被下载的时间
+	readyAt time.Time
+}
+
 type reqCacheBySlice struct {
 	cache []*base.Request
+	//被延迟的请求,按可以被下载的时间排序
+	delayed []delayedRequest
 	mutex sync.Mutex
 	//代表请求状态0代表初始化,1代表关闭
 	status byte
 }
 
 var (
-	summaryTemplate = "status:%s," + "length:%d," + "capacity:%d"
+	summaryTemplate = "status:%s," + "length:%d," + "delayed:%d," + "capacity:%d"
 	//状态字典
 	statusMap = map[byte]string{
 		0: "running",
@@ -61,25 +78,65 @@ func (reqcache *reqCacheBySlice) put(req *base.Request) bool {
 }
 
 func (reqcache *reqCacheBySlice) get() *base.Request {
-	if reqcache.length() == 0 {
-		return nil
-	}
 	if reqcache.status == 1 {
 		return nil
 	}
 	reqcache.mutex.Lock()
 	defer reqcache.mutex.Unlock()
+	//被延迟的请求不计算在内
+	if len(reqcache.cache) == 0 {
+		return nil
+	}
 	req := reqcache.cache[0]
 	reqcache.cache = reqcache.cache[1:]
 	return req
 }
 
+func (reqcache *reqCacheBySlice) delay(req *base.Request, readyAt time.Time) bool {
+	if req == nil {
+		return false
+	}
+	if reqcache.status == 1 {
+		return false
+	}
+	reqcache.mutex.Lock()
+	defer reqcache.mutex.Unlock()
+	//到期时间相同的请求按放入的顺序排列
+	index := sort.Search(len(reqcache.delayed), func(i int) bool {
+		return reqcache.delayed[i].readyAt.After(readyAt)
+	})
+	reqcache.delayed = append(reqcache.delayed, delayedRequest{})
+	copy(reqcache.delayed[index+1:], reqcache.delayed[index:])
+	reqcache.delayed[index] = delayedRequest{req: req, readyAt: readyAt}
+	return true
+}
+
+func (reqcache *reqCacheBySlice) getDue(now time.Time) *base.Request {
+	if reqcache.status == 1 {
+		return nil
+	}
+	reqcache.mutex.Lock()
+	defer reqcache.mutex.Unlock()
+	if len(reqcache.delayed) == 0 || reqcache.delayed[0].readyAt.After(now) {
+		return nil
+	}
+	req := reqcache.delayed[0].req
+	reqcache.delayed = reqcache.delayed[1:]
+	return req
+}
+
+func (reqcache *reqCacheBySlice) delayedLength() int {
+	reqcache.mutex.Lock()
+	defer reqcache.mutex.Unlock()
+	return len(reqcache.delayed)
+}
+
 func (reqcache *reqCacheBySlice) capacity() int {
 	return cap(reqcache.cache)
 }
 
 func (reqcache *reqCacheBySlice) length() int {
-	return len(reqcache.cache)
+	return len(reqcache.cache) + reqcache.delayedLength()
 }
 
 func (reqcache *reqCacheBySlice) close() {
@@ -93,6 +150,7 @@ func (reqcache *reqCacheBySlice) summary() string {
 	summary := fmt.Sprintf(summaryTemplate,
 		statusMap[reqcache.status],
 		reqcache.length(),
+		reqcache.delayedLength(),
 		reqcache.capacity())
 	return summary
 }
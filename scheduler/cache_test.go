@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"fmt"
+	"net/http"
+	"summerWebCrawler/base"
+	"testing"
+	"time"
+)
+
+//创建指定url的请求
+func newTestRequest(t *testing.T, rawUrl string) *base.Request {
+	httpReq, err := http.NewRequest("GET", rawUrl, nil)
+	if err != nil {
+		t.Fatalf("Create request failing: %s", err)
+	}
+	return base.NewRequest(httpReq, 0)
+}
+
+func TestRequestCacheDelay(t *testing.T) {
+	cache := NewRequestCache()
+	now := time.Now()
+	for i, offset := range []time.Duration{2 * time.Second, time.Second, time.Second} {
+		cache.delay(newTestRequest(t, fmt.Sprintf("http://example.com/%d", i)), now.Add(offset))
+	}
+	cache.put(newTestRequest(t, "http://example.com/ready"))
+	if length := cache.length(); length != 4 {
+		t.Fatalf("The length is %d, want 4", length)
+	}
+	//被延迟的请求不会被get取出
+	if req := cache.get(); req == nil || req.HttpReq().URL.Path != "/ready" {
+		t.Fatalf("get returns %v, want the ready request", req)
+	}
+	if req := cache.get(); req != nil {
+		t.Fatalf("get returns the delayed request %s", req.HttpReq().URL)
+	}
+	if req := cache.getDue(now); req != nil {
+		t.Fatalf("getDue returns the request %s before it is due", req.HttpReq().URL)
+	}
+	//先到期的请求先被取出,到期时间相同的按放入的顺序取出
+	var paths []string
+	for req := cache.getDue(now.Add(2 * time.Second)); req != nil; req = cache.getDue(now.Add(2 * time.Second)) {
+		paths = append(paths, req.HttpReq().URL.Path)
+	}
+	if fmt.Sprint(paths) != "[/1 /2 /0]" {
+		t.Fatalf("The due requests are %v, want [/1 /2 /0]", paths)
+	}
+	if length := cache.delayedLength(); length != 0 {
+		t.Fatalf("The delayed length is %d, want 0", length)
+	}
+}
@@ -0,0 +1,372 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//并发控制器的接口类型
+//它观察下载的延迟、超时和429/503响应的比例,按照AIMD(加性增、乘性减)的方式
+//调整每个主机的并发数和全局的并发数(即网页下载器池的容量)
+type ConcurrencyController interface {
+	//在请求被交给下载的goroutine之前调用,该主机正在进行的下载数低于其并发数时占用一个并发数并返回true,
+	//否则立即返回false,这时请求应该稍后再试.该方法不会阻塞,因此受限的主机不会占用下载的goroutine.
+	//控制器被关闭之后总是返回false
+	TryAcquire(host string) bool
+	//在下载之后调用,报告下载的结果.参数statusCode在没有响应时为0
+	Release(host string, latency time.Duration, statusCode int, err error)
+	//在TryAcquire成功之后没有进行下载时调用,只释放并发数而不记录结果
+	Cancel(host string)
+	//根据上一个周期的观测结果调整并发数,参数current是当前的全局并发数,返回调整之后的全局并发数
+	//结果值总在全局并发数的上下限之内,因此通过ResizePools设置的超出上下限的容量会在下一个周期被修正
+	Adjust(current uint32) uint32
+	//获得调整的周期
+	Interval() time.Duration
+	//关闭控制器,之后的TryAcquire都会失败
+	Close()
+	//获取摘要信息
+	Summary() string
+}
+
+//并发控制的参数
+type ConcurrencyArgs struct {
+	//全局并发数的下限
+	minConcurrency uint32
+	//全局并发数的上限
+	maxConcurrency uint32
+	//每个主机的并发数的下限,也是新主机的初始并发数
+	minPerHost uint32
+	//每个主机的并发数的上限
+	maxPerHost uint32
+	//目标延迟,平均延迟超过它时视为拥塞.0表示不根据延迟判断
+	targetLatency time.Duration
+	//调整的周期
+	interval time.Duration
+	//描述
+	description string
+}
+
+var concurrencyArgsTemplate = "{concurrency:[%d, %d], perHost:[%d, %d], targetLatency:%s, interval:%s}"
+
+//错误(包括超时和429/503响应)的比例超过该值时视为拥塞
+const congestionErrorRate = 0.1
+
+//创建并发控制的参数
+func NewConcurrencyArgs(minConcurrency, maxConcurrency, minPerHost, maxPerHost uint32,
+	targetLatency time.Duration, interval time.Duration) ConcurrencyArgs {
+	return ConcurrencyArgs{
+		minConcurrency: minConcurrency,
+		maxConcurrency: maxConcurrency,
+		minPerHost:     minPerHost,
+		maxPerHost:     maxPerHost,
+		targetLatency:  targetLatency,
+		interval:       interval,
+	}
+}
+
+//获得全局并发数的下限
+func (args *ConcurrencyArgs) MinConcurrency() uint32 {
+	return args.minConcurrency
+}
+
+//获得全局并发数的上限
+func (args *ConcurrencyArgs) MaxConcurrency() uint32 {
+	return args.maxConcurrency
+}
+
+//获得每个主机的并发数的下限
+func (args *ConcurrencyArgs) MinPerHost() uint32 {
+	return args.minPerHost
+}
+
+//获得每个主机的并发数的上限
+func (args *ConcurrencyArgs) MaxPerHost() uint32 {
+	return args.maxPerHost
+}
+
+//获得目标延迟
+func (args *ConcurrencyArgs) TargetLatency() time.Duration {
+	return args.targetLatency
+}
+
+//获得调整的周期
+func (args *ConcurrencyArgs) Interval() time.Duration {
+	return args.interval
+}
+
+func (args *ConcurrencyArgs) Check() error {
+	if args.minConcurrency == 0 || args.minConcurrency > args.maxConcurrency {
+		return errors.New("The concurrency bounds are invalid!\n")
+	}
+	if args.minPerHost == 0 || args.minPerHost > args.maxPerHost {
+		return errors.New("The per host concurrency bounds are invalid!\n")
+	}
+	if args.interval <= 0 {
+		return errors.New("The adjusting interval must be greater than 0!\n")
+	}
+	return nil
+}
+
+func (args *ConcurrencyArgs) String() string {
+	if args.description == "" {
+		args.description = fmt.Sprintf(concurrencyArgsTemplate,
+			args.minConcurrency,
+			args.maxConcurrency,
+			args.minPerHost,
+			args.maxPerHost,
+			args.targetLatency,
+			args.interval)
+	}
+	return args.description
+}
+
+//一个周期内的观测结果
+type observation struct {
+	//完成的下载数
+	requests uint64
+	//延迟之和
+	latency time.Duration
+	//超时的数量
+	timeouts uint64
+	//429/503响应的数量
+	throttled uint64
+	//其他错误的数量
+	errors uint64
+	//是否达到过并发数的上限
+	saturated bool
+}
+
+//记录一次下载的结果
+func (obs *observation) add(latency time.Duration, statusCode int, err error) {
+	obs.requests++
+	obs.latency += latency
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			obs.timeouts++
+		} else {
+			obs.errors++
+		}
+	} else if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		obs.throttled++
+	}
+}
+
+//合并观测结果
+func (obs *observation) merge(other observation) {
+	obs.requests += other.requests
+	obs.latency += other.latency
+	obs.timeouts += other.timeouts
+	obs.throttled += other.throttled
+	obs.errors += other.errors
+}
+
+//判断是否拥塞
+//参数strict为true时只要出现429/503响应或者超时就视为拥塞,否则只看比例
+func (obs *observation) congested(targetLatency time.Duration, strict bool) bool {
+	if obs.requests == 0 {
+		return false
+	}
+	if strict && (obs.throttled > 0 || obs.timeouts > 0) {
+		return true
+	}
+	failures := obs.throttled + obs.timeouts + obs.errors
+	if float64(failures)/float64(obs.requests) > congestionErrorRate {
+		return true
+	}
+	return targetLatency > 0 && obs.avgLatency() > targetLatency
+}
+
+//获得平均延迟
+func (obs *observation) avgLatency() time.Duration {
+	if obs.requests == 0 {
+		return 0
+	}
+	return obs.latency / time.Duration(obs.requests)
+}
+
+func (obs *observation) String() string {
+	return fmt.Sprintf("requests=%d, avgLatency=%s, timeouts=%d, throttled=%d, errors=%d",
+		obs.requests, obs.avgLatency(), obs.timeouts, obs.throttled, obs.errors)
+}
+
+//主机的并发状态
+type hostState struct {
+	//并发数
+	limit uint32
+	//正在进行的下载数
+	active uint32
+	//本周期的观测结果
+	window observation
+}
+
+//AIMD并发控制器的实现类型
+type myAIMDController struct {
+	//参数
+	args ConcurrencyArgs
+	//主机到并发状态的映射
+	hosts map[string]*hostState
+	//全局正在进行的下载数
+	active uint32
+	//本周期内同时进行的下载数的最大值
+	peak uint32
+	//累计的观测结果
+	total observation
+	//全局并发数
+	concurrency uint32
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var aimdSummaryTemplate = "{concurrency:%d, active:%d, hosts:%d, limitedHosts:%d, %s}"
+
+//创建AIMD并发控制器
+func NewAIMDController(args ConcurrencyArgs) (ConcurrencyController, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	controller := &myAIMDController{
+		args:  args,
+		hosts: make(map[string]*hostState),
+	}
+	return controller, nil
+}
+
+func (controller *myAIMDController) TryAcquire(host string) bool {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	if controller.closed {
+		return false
+	}
+	state, ok := controller.hosts[host]
+	if !ok {
+		state = &hostState{limit: controller.args.minPerHost}
+		controller.hosts[host] = state
+	}
+	if state.active >= state.limit {
+		state.window.saturated = true
+		return false
+	}
+	state.active++
+	controller.active++
+	if controller.active > controller.peak {
+		controller.peak = controller.active
+	}
+	return true
+}
+
+func (controller *myAIMDController) Release(host string, latency time.Duration, statusCode int, err error) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.total.add(latency, statusCode, err)
+	if state := controller.release(host); state != nil {
+		state.window.add(latency, statusCode, err)
+	}
+}
+
+func (controller *myAIMDController) Cancel(host string) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.release(host)
+}
+
+//释放主机的并发数,调用方需持有锁
+func (controller *myAIMDController) release(host string) *hostState {
+	if controller.active > 0 {
+		controller.active--
+	}
+	state, ok := controller.hosts[host]
+	if !ok {
+		return nil
+	}
+	if state.active > 0 {
+		state.active--
+	}
+	return state
+}
+
+func (controller *myAIMDController) Adjust(current uint32) uint32 {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	args := controller.args
+	var global observation
+	for host, state := range controller.hosts {
+		window := state.window
+		state.window = observation{}
+		global.merge(window)
+		//本周期没有下载的主机不再被跟踪,它再次出现时从并发数的下限重新开始
+		if window.requests == 0 && state.active == 0 {
+			delete(controller.hosts, host)
+			continue
+		}
+		limit := state.limit
+		if window.congested(args.targetLatency, true) {
+			limit = maxUint32(limit/2, args.minPerHost)
+		} else if window.saturated && window.requests > 0 {
+			limit = minUint32(limit+1, args.maxPerHost)
+		}
+		if limit != state.limit {
+			logger.Infof("Adjust the concurrency of host %s: %d -> %d (%s)\n", host, state.limit, limit, window.String())
+			state.limit = limit
+		}
+	}
+	//全局并发数以当前的网页下载器池的容量为准
+	concurrency := minUint32(maxUint32(current, args.minConcurrency), args.maxConcurrency)
+	if global.congested(args.targetLatency, false) {
+		concurrency = maxUint32(concurrency/2, args.minConcurrency)
+	} else if controller.peak >= current && global.requests > 0 {
+		concurrency = minUint32(concurrency+1, args.maxConcurrency)
+	}
+	if concurrency != current {
+		logger.Infof("Adjust the global concurrency: %d -> %d (%s)\n", current, concurrency, global.String())
+	}
+	controller.peak = controller.active
+	controller.concurrency = concurrency
+	return concurrency
+}
+
+func (controller *myAIMDController) Interval() time.Duration {
+	return controller.args.interval
+}
+
+func (controller *myAIMDController) Close() {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.closed = true
+}
+
+func (controller *myAIMDController) Summary() string {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	limited := 0
+	for _, state := range controller.hosts {
+		if state.limit < controller.args.maxPerHost {
+			limited++
+		}
+	}
+	return fmt.Sprintf(aimdSummaryTemplate,
+		controller.concurrency,
+		controller.active,
+		len(controller.hosts),
+		limited,
+		controller.total.String())
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
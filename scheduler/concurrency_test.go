@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+//一个周期内某个主机的下载
+type hostRound struct {
+	//完成的下载数
+	requests int
+	//每个下载的延迟
+	latency time.Duration
+	//每个下载的状态码
+	statusCode int
+	//每个下载的错误
+	err error
+	//是否在下载期间达到过并发数的上限
+	saturate bool
+}
+
+//创建AIMD并发控制器
+func newTestController(t *testing.T, args ConcurrencyArgs) *myAIMDController {
+	controller, err := NewAIMDController(args)
+	if err != nil {
+		t.Fatalf("Create concurrency controller failing: %s", err)
+	}
+	return controller.(*myAIMDController)
+}
+
+//按照参数round在主机上进行一个周期的下载
+func (round hostRound) run(t *testing.T, controller *myAIMDController, host string) {
+	for i := 0; i < round.requests; i++ {
+		if !controller.TryAcquire(host) {
+			t.Fatalf("Acquire host %s failing", host)
+		}
+		if round.saturate && i == 0 {
+			//占满主机的并发数之后再尝试一次
+			limit := controller.hosts[host].limit
+			for j := uint32(1); j < limit; j++ {
+				if !controller.TryAcquire(host) {
+					t.Fatalf("Acquire host %s failing", host)
+				}
+			}
+			if controller.TryAcquire(host) {
+				t.Fatalf("Acquire host %s over its limit %d", host, limit)
+			}
+			for j := uint32(1); j < limit; j++ {
+				controller.Cancel(host)
+			}
+		}
+		controller.Release(host, round.latency, round.statusCode, round.err)
+	}
+}
+
+func TestAIMDControllerAdjustHost(t *testing.T) {
+	args := NewConcurrencyArgs(1, 100, 1, 8, 500*time.Millisecond, time.Second)
+	cases := []struct {
+		name  string
+		limit uint32
+		//同一周期内之前没有出错的下载数
+		succeeded int
+		round     hostRound
+		want      uint32
+	}{
+		{"additive increase when saturated", 4, 0,
+			hostRound{requests: 10, latency: 100 * time.Millisecond, statusCode: 200, saturate: true}, 5},
+		{"keep the limit when not saturated", 4, 0,
+			hostRound{requests: 10, latency: 100 * time.Millisecond, statusCode: 200}, 4},
+		{"multiplicative decrease on 429", 6, 0,
+			hostRound{requests: 1, statusCode: http.StatusTooManyRequests, saturate: true}, 3},
+		{"multiplicative decrease on 503", 6, 0,
+			hostRound{requests: 1, statusCode: http.StatusServiceUnavailable}, 3},
+		{"multiplicative decrease on timeouts", 4, 0,
+			hostRound{requests: 1, err: timeoutError{}}, 2},
+		{"multiplicative decrease when latency is over target", 8, 0,
+			hostRound{requests: 5, latency: time.Second, statusCode: 200, saturate: true}, 4},
+		{"multiplicative decrease when errors are over the error rate", 4, 0,
+			hostRound{requests: 1, err: errors.New("connection reset")}, 2},
+		{"other errors under the error rate are ignored", 4, 19,
+			hostRound{requests: 1, err: errors.New("connection reset"), saturate: true}, 5},
+		{"clamp the increase to the upper bound", 8, 0,
+			hostRound{requests: 10, statusCode: 200, saturate: true}, 8},
+		{"clamp the decrease to the lower bound", 1, 0,
+			hostRound{requests: 1, statusCode: http.StatusTooManyRequests}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			controller := newTestController(t, args)
+			controller.hosts["example.com"] = &hostState{limit: c.limit}
+			//之前没有出错的下载使错误的比例低于阈值
+			if c.succeeded != 0 {
+				hostRound{requests: c.succeeded, statusCode: 200}.run(t, controller, "example.com")
+			}
+			c.round.run(t, controller, "example.com")
+			controller.Adjust(10)
+			state, ok := controller.hosts["example.com"]
+			if !ok {
+				t.Fatalf("The host is not tracked after adjusting")
+			}
+			if state.limit != c.want {
+				t.Errorf("The limit is %d, want %d", state.limit, c.want)
+			}
+		})
+	}
+}
+
+func TestAIMDControllerAdjustGlobal(t *testing.T) {
+	args := NewConcurrencyArgs(4, 16, 1, 100, 0, time.Second)
+	cases := []struct {
+		name    string
+		current uint32
+		//同时进行的下载数
+		active int
+		round  hostRound
+		want   uint32
+	}{
+		{"additive increase when all downloaders are used", 8, 8,
+			hostRound{requests: 8, statusCode: 200}, 9},
+		{"keep the size when downloaders are idle", 8, 2,
+			hostRound{requests: 8, statusCode: 200}, 8},
+		{"keep the size without downloads", 8, 8,
+			hostRound{}, 8},
+		{"multiplicative decrease on throttled responses", 12, 12,
+			hostRound{requests: 4, statusCode: http.StatusServiceUnavailable}, 6},
+		{"multiplicative decrease on timeouts", 12, 12,
+			hostRound{requests: 4, err: timeoutError{}}, 6},
+		{"clamp the decrease to the lower bound", 6, 6,
+			hostRound{requests: 4, statusCode: http.StatusTooManyRequests}, 4},
+		{"clamp the increase to the upper bound", 16, 16,
+			hostRound{requests: 16, statusCode: 200}, 16},
+		{"clamp a size below the lower bound", 2, 2,
+			hostRound{requests: 2, statusCode: 200}, 5},
+		{"clamp a size over the upper bound", 32, 32,
+			hostRound{requests: 32, statusCode: 200}, 16},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			controller := newTestController(t, args)
+			host := "example.com"
+			controller.hosts[host] = &hostState{limit: 100}
+			//同时占用active个并发数,记录峰值
+			for i := 0; i < c.active; i++ {
+				controller.TryAcquire(host)
+			}
+			for i := 0; i < c.active; i++ {
+				controller.Cancel(host)
+			}
+			c.round.run(t, controller, host)
+			if got := controller.Adjust(c.current); got != c.want {
+				t.Errorf("The concurrency is %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAIMDControllerTryAcquire(t *testing.T) {
+	controller := newTestController(t, NewConcurrencyArgs(1, 10, 2, 4, 0, time.Second))
+	//新主机从每个主机的并发数的下限开始
+	for i := 0; i < 2; i++ {
+		if !controller.TryAcquire("a.example.com") {
+			t.Fatalf("The %dth acquire failing", i)
+		}
+	}
+	if controller.TryAcquire("a.example.com") {
+		t.Fatalf("Acquire over the host limit")
+	}
+	if !controller.TryAcquire("b.example.com") {
+		t.Fatalf("Another host is blocked by a busy host")
+	}
+	controller.Release("a.example.com", time.Millisecond, 200, nil)
+	if !controller.TryAcquire("a.example.com") {
+		t.Fatalf("Acquire after release failing")
+	}
+	controller.Close()
+	if controller.TryAcquire("c.example.com") {
+		t.Fatalf("Acquire after close succeeds")
+	}
+}
@@ -15,12 +15,12 @@ import (
 var regexpForIp = regexp.MustCompile(`((?:(?:25[0-5]|2[0-4]\d|[01]?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|[01]?\d?\d))`)
 
 var regexpForDomains = []*regexp.Regexp{
-	// *.xx or *.xxx.xx
+	//*.xx or *.xxx.xx
 	regexp.MustCompile(`\.(com|com\.\w{2})$`),
 	regexp.MustCompile(`\.(gov|gov\.\w{2})$`),
 	regexp.MustCompile(`\.(net|net\.\w{2})$`),
 	regexp.MustCompile(`\.(org|org\.\w{2})$`),
-	// *.xx
+	//*.xx
 	regexp.MustCompile(`\.me$`),
 	regexp.MustCompile(`\.biz$`),
 	regexp.MustCompile(`\.info$`),
@@ -48,13 +48,19 @@ func generateAnalyzerPool(poolSize uint32) (analy.AnalyzerPool, error) {
 }
 
 //初始化网页下载器池
-func generatePageDownloaderPool(poolSize uint32, client GenHttpClient) (download.PageDownloaderPool, error) {
+func generatePageDownloaderPool(poolSize uint32,
+	client GenHttpClient,
+	gen download.PageDownloaderGenerator,
+	args download.DownloaderArgs) (download.PageDownloaderPool, error) {
+	if gen == nil {
+		gen = download.NewPageDownloaderWithArgs
+	}
 	downloader, err := download.NewPageDownloaderPool(
 		poolSize,
-		//download.NewPageDownloader(client())返回一个网页下载器(实体)
+		//gen(client(), args)返回一个网页下载器(实体)
 		//通过实体初始化网页下载器池
 		func() download.PageDownloader {
-			return download.NewPageDownloader(client())
+			return gen(client(), args)
 		})
 	if err != nil {
 		return nil, err
@@ -67,8 +73,8 @@ func generateChannelManager(channelArgs base.ChannelArgs) middle.ChannelManager
 	return middle.NewChannelManager(channelArgs)
 }
 
-func generateItemPipeline(items []pipe.ProcessItem) pipe.ItemPipeline {
-	return pipe.NewItemPipeline(items)
+func generateItemPipeline(stages []pipe.ItemStage, policy pipe.ErrorPolicy) pipe.ItemPipeline {
+	return pipe.NewItemPipeline(stages, policy)
 }
 
 func getPrimaryDomain(host string) (string, error) {
@@ -102,12 +108,12 @@ func getPrimaryDomain(host string) (string, error) {
 	}
 }
 
-// 生成组件实例代号。
+//生成组件实例代号。
 func generateCode(prefix string, id uint32) string {
 	return fmt.Sprintf("%s-%d", prefix, id)
 }
 
-// 解析组件实例代号。
+//解析组件实例代号。
 func parseCode(code string) []string {
 	result := make([]string, 2)
 	var codePrefix string
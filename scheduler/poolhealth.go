@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	analy "summerWebCrawler/analyzer"
+	download "summerWebCrawler/downloadder"
+	"time"
+)
+
+//池的健康参数
+type PoolHealthArgs struct {
+	//从池中取出实体的超时时间,0表示一直等待
+	takeTimeout time.Duration
+	//实体被持有的时长超过该值时被报告为可能已泄漏,0表示不检测
+	leakThreshold time.Duration
+	//网页下载器的健康检查函数,可以为nil
+	downloaderCheck func(download.PageDownloader) bool
+	//分析器的健康检查函数,可以为nil
+	analyzerCheck func(analy.Analyzer) bool
+	//描述
+	description string
+}
+
+var poolHealthArgsTemplate = "{takeTimeout:%s, leakThreshold:%s, downloaderCheck:%v, analyzerCheck:%v}"
+
+//创建池的健康参数
+func NewPoolHealthArgs(takeTimeout, leakThreshold time.Duration,
+	downloaderCheck func(download.PageDownloader) bool,
+	analyzerCheck func(analy.Analyzer) bool) PoolHealthArgs {
+	return PoolHealthArgs{
+		takeTimeout:     takeTimeout,
+		leakThreshold:   leakThreshold,
+		downloaderCheck: downloaderCheck,
+		analyzerCheck:   analyzerCheck,
+	}
+}
+
+//获得取出实体的超时时间
+func (args *PoolHealthArgs) TakeTimeout() time.Duration {
+	return args.takeTimeout
+}
+
+//获得泄漏检测的阈值
+func (args *PoolHealthArgs) LeakThreshold() time.Duration {
+	return args.leakThreshold
+}
+
+//获得网页下载器的健康检查函数
+func (args *PoolHealthArgs) DownloaderCheck() func(download.PageDownloader) bool {
+	return args.downloaderCheck
+}
+
+//获得分析器的健康检查函数
+func (args *PoolHealthArgs) AnalyzerCheck() func(analy.Analyzer) bool {
+	return args.analyzerCheck
+}
+
+func (args *PoolHealthArgs) Check() error {
+	if args.takeTimeout < 0 {
+		return errors.New("The take timeout can not be negative!\n")
+	}
+	if args.leakThreshold < 0 {
+		return errors.New("The leak threshold can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *PoolHealthArgs) String() string {
+	if args.description == "" {
+		args.description = fmt.Sprintf(poolHealthArgsTemplate,
+			args.takeTimeout,
+			args.leakThreshold,
+			args.downloaderCheck != nil,
+			args.analyzerCheck != nil)
+	}
+	return args.description
+}
+
+//被报告过的泄漏,同一个实体在同一次被取出期间只报告一次
+type leakKey struct {
+	//池的名称
+	pool string
+	//实体的Id
+	id uint32
+	//被取出的时间
+	takenAt time.Time
+}
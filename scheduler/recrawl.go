@@ -0,0 +1,326 @@
+package scheduler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"summerWebCrawler/base"
+	"sync"
+	"time"
+)
+
+//重新爬取跟踪器的接口类型
+//它记录每个url最近一次被抓取的时间和内容的摘要,并根据观察到的变化频率安排下一次抓取:
+//内容发生变化时缩短重新抓取的间隔,没有变化时延长间隔
+type RecrawlTracker interface {
+	//记录一次抓取的结果,返回内容是否发生了变化.首次被抓取的url总是被视为发生了变化
+	Observe(reqUrl string, depth uint32, hash string, fetchedAt time.Time) bool
+	//取出所有到期需要重新抓取的请求
+	//被取出的url会被推迟一个间隔,抓取失败时它会在一个间隔之后被再次取出
+	Due(now time.Time) []*base.Request
+	//获得所有被跟踪的url
+	URLs() []string
+	//保存跟踪的状态
+	Save() error
+	//获取摘要信息
+	Summary() string
+}
+
+//重新爬取的参数
+type RecrawlArgs struct {
+	//保存跟踪状态的文件的路径,为空时不保存
+	path string
+	//重新抓取间隔的下限
+	minInterval time.Duration
+	//首次抓取之后的重新抓取间隔
+	initialInterval time.Duration
+	//重新抓取间隔的上限
+	maxInterval time.Duration
+	//描述
+	description string
+}
+
+var recrawlArgsTemplate = "{path:%s, minInterval:%s, initialInterval:%s, maxInterval:%s}"
+
+//创建重新爬取的参数
+func NewRecrawlArgs(path string, minInterval, initialInterval, maxInterval time.Duration) RecrawlArgs {
+	return RecrawlArgs{
+		path:            path,
+		minInterval:     minInterval,
+		initialInterval: initialInterval,
+		maxInterval:     maxInterval,
+	}
+}
+
+//获得保存跟踪状态的文件的路径
+func (args *RecrawlArgs) Path() string {
+	return args.path
+}
+
+//获得重新抓取间隔的下限
+func (args *RecrawlArgs) MinInterval() time.Duration {
+	return args.minInterval
+}
+
+//获得首次抓取之后的重新抓取间隔
+func (args *RecrawlArgs) InitialInterval() time.Duration {
+	return args.initialInterval
+}
+
+//获得重新抓取间隔的上限
+func (args *RecrawlArgs) MaxInterval() time.Duration {
+	return args.maxInterval
+}
+
+func (args *RecrawlArgs) Check() error {
+	if args.minInterval <= 0 {
+		return errors.New("The min recrawl interval must be greater than 0!\n")
+	}
+	if args.initialInterval < args.minInterval || args.initialInterval > args.maxInterval {
+		return errors.New("The initial recrawl interval must be between the min and the max interval!\n")
+	}
+	return nil
+}
+
+func (args *RecrawlArgs) String() string {
+	if args.description == "" {
+		args.description = fmt.Sprintf(recrawlArgsTemplate,
+			args.path,
+			args.minInterval,
+			args.initialInterval,
+			args.maxInterval)
+	}
+	return args.description
+}
+
+//被跟踪的url的状态
+type recrawlEntry struct {
+	//url
+	URL string `json:"url"`
+	//首次被发现时的深度
+	Depth uint32 `json:"depth"`
+	//内容的摘要
+	Hash string `json:"hash"`
+	//最近一次抓取的时间
+	LastFetch time.Time `json:"last_fetch"`
+	//最近一次发现变化的时间
+	LastChange time.Time `json:"last_change"`
+	//下一次抓取的时间
+	NextFetch time.Time `json:"next_fetch"`
+	//当前的重新抓取间隔
+	Interval time.Duration `json:"interval"`
+	//抓取的次数
+	Fetches uint64 `json:"fetches"`
+	//发现变化的次数
+	Changes uint64 `json:"changes"`
+}
+
+//重新爬取跟踪器的实现类型
+type myRecrawlTracker struct {
+	//参数
+	args RecrawlArgs
+	//url到状态的映射
+	entries map[string]*recrawlEntry
+	//记录的抓取结果的数量
+	observed uint64
+	//其中内容发生了变化的数量
+	changed uint64
+	//被取出的到期请求的数量
+	dueCount uint64
+	//互斥锁
+	mutex sync.Mutex
+}
+
+//保存跟踪状态的时间间隔
+const recrawlSaveInterval = time.Minute
+
+var recrawlSummaryTemplate = "{urls:%d, observed:%d, changed:%d, unchanged:%d, due:%d, next:%s}"
+
+//创建重新爬取跟踪器
+//如果参数中的文件已经存在,之前保存的跟踪状态会被载入
+func NewRecrawlTracker(args RecrawlArgs) (RecrawlTracker, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	tracker := &myRecrawlTracker{args: args, entries: make(map[string]*recrawlEntry)}
+	if args.path == "" {
+		return tracker, nil
+	}
+	content, err := ioutil.ReadFile(args.path)
+	if os.IsNotExist(err) {
+		return tracker, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*recrawlEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, errors.New(fmt.Sprintf("Load recrawl state from %s failing: %s", args.path, err))
+	}
+	for _, entry := range entries {
+		tracker.entries[entry.URL] = entry
+	}
+	return tracker, nil
+}
+
+func (tracker *myRecrawlTracker) Observe(reqUrl string, depth uint32, hash string, fetchedAt time.Time) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.observed++
+	entry, ok := tracker.entries[reqUrl]
+	if !ok {
+		entry = &recrawlEntry{URL: reqUrl, Depth: depth, Interval: tracker.args.initialInterval}
+		tracker.entries[reqUrl] = entry
+	}
+	changed := entry.Hash != hash
+	if changed {
+		if entry.Fetches > 0 {
+			entry.Interval = tracker.clamp(entry.Interval / 2)
+		}
+		entry.Hash = hash
+		entry.LastChange = fetchedAt
+		entry.Changes++
+		tracker.changed++
+	} else {
+		entry.Interval = tracker.clamp(entry.Interval * 3 / 2)
+	}
+	entry.Fetches++
+	entry.LastFetch = fetchedAt
+	entry.NextFetch = fetchedAt.Add(entry.Interval)
+	return changed
+}
+
+//把间隔限制在上下限之间
+func (tracker *myRecrawlTracker) clamp(interval time.Duration) time.Duration {
+	if interval < tracker.args.minInterval {
+		return tracker.args.minInterval
+	}
+	if interval > tracker.args.maxInterval {
+		return tracker.args.maxInterval
+	}
+	return interval
+}
+
+func (tracker *myRecrawlTracker) Due(now time.Time) []*base.Request {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var dueEntries []*recrawlEntry
+	for _, entry := range tracker.entries {
+		if !entry.NextFetch.After(now) {
+			dueEntries = append(dueEntries, entry)
+		}
+	}
+	//最早到期的url最先被抓取
+	sort.Slice(dueEntries, func(i, j int) bool {
+		return dueEntries[i].NextFetch.Before(dueEntries[j].NextFetch)
+	})
+	reqs := make([]*base.Request, 0, len(dueEntries))
+	for _, entry := range dueEntries {
+		entry.NextFetch = now.Add(entry.Interval)
+		httpReq, err := http.NewRequest("GET", entry.URL, nil)
+		if err != nil {
+			logger.Warnf("Ignore the recrawl url '%s': %s\n", entry.URL, err)
+			continue
+		}
+		reqs = append(reqs, base.NewRequest(httpReq, entry.Depth))
+	}
+	tracker.dueCount += uint64(len(reqs))
+	return reqs
+}
+
+func (tracker *myRecrawlTracker) URLs() []string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	urls := make([]string, 0, len(tracker.entries))
+	for reqUrl := range tracker.entries {
+		urls = append(urls, reqUrl)
+	}
+	return urls
+}
+
+//先写入临时文件再重命名,中途失败不会破坏之前保存的状态
+func (tracker *myRecrawlTracker) Save() error {
+	if tracker.args.path == "" {
+		return nil
+	}
+	tracker.mutex.Lock()
+	entries := make([]*recrawlEntry, 0, len(tracker.entries))
+	for _, entry := range tracker.entries {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	tracker.mutex.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(tracker.args.path), filepath.Base(tracker.args.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), tracker.args.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (tracker *myRecrawlTracker) Summary() string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	var next time.Time
+	for _, entry := range tracker.entries {
+		if next.IsZero() || entry.NextFetch.Before(next) {
+			next = entry.NextFetch
+		}
+	}
+	nextStr := "<none>"
+	if !next.IsZero() {
+		nextStr = next.Format(time.RFC3339)
+	}
+	return fmt.Sprintf(recrawlSummaryTemplate,
+		len(tracker.entries),
+		tracker.observed,
+		tracker.changed,
+		tracker.observed-tracker.changed,
+		tracker.dueCount,
+		nextStr)
+}
+
+//计算响应内容的摘要,状态码也是内容的一部分
+//响应体被读出之后会被重置,以便分析器再次读取
+func hashResponse(httpResp *http.Response) (string, error) {
+	hash := sha1.New()
+	hash.Write([]byte(strconv.Itoa(httpResp.StatusCode) + "\n"))
+	if httpResp.Body != nil {
+		body, err := ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		hash.Write(body)
+		httpResp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -6,10 +6,12 @@ import (
 	"net/http"
 	middle "summerWebCrawler/middleware"
 	download "summerWebCrawler/downloadder"
+	front "summerWebCrawler/frontier"
 	"fmt"
 	"errors"
 	"summerWebCrawler/logging"
 	"summerWebCrawler/base"
+	"sync"
 	"sync/atomic"
 	"time"
 	"strings"
@@ -24,6 +26,7 @@ type Scheduler interface {
 	//参数crawlDepth代表了需要被爬取的网页的最大深度值,深度大于此值的网页会被忽略
 	//参数httpClicentGenerator代表的是被用来生成http客户端的函数
 	//参数respParsers的值应为需要被置入条目处理管道中的条目处理器的序列
+	//参数itemProcessors代表条目处理器的序列,每个条目处理器都会成为条目处理管道中的一个阶段,见pipeline.NewProcessorStages
 	//参数firstHttpReq即代表首次请求.调度器会以此为起点开始执行爬取流程
 	Start(channelArgs base.ChannelArgs,
 		poolBaseArgs base.PoolBaseArgs,
@@ -32,6 +35,16 @@ type Scheduler interface {
 		respParsers []analy.ParseResponse,
 		itemProcessors []pipeline.ProcessItem,
 		firstHttpReq *http.Request) (err error)
+	//使用条目处理阶段启动调度器,其它参数与Start相同
+	//参数itemStages代表条目处理管道中的阶段的序列,每个阶段都有自己的工作goroutine和有界队列.
+	//出错时的处理方式由各阶段的错误策略决定,未设置错误策略的阶段使用SetItemErrorPolicy设置的默认错误策略
+	StartWithStages(channelArgs base.ChannelArgs,
+		poolBaseArgs base.PoolBaseArgs,
+		crawlDepth uint32,
+		httpClientGenerator GenHttpClient,
+		respParsers []analy.ParseResponse,
+		itemStages []pipeline.ItemStage,
+		firstHttpReq *http.Request) (err error)
 
 	//调用该方法会停止调度器的运行.所有处理模块执行的流程会被中止
 	Stop() bool
@@ -43,15 +56,69 @@ type Scheduler interface {
 	Idle() bool
 	//获取摘要信息
 	Summary(prefix string) SchedSummary
+	//设置网页下载器的附加参数(如内容过滤器),应在Start之前调用
+	SetDownloaderArgs(args download.DownloaderArgs)
+	//设置网页下载器的生成函数,应在Start之前调用
+	//未设置时使用默认的网页下载器,设置为回放下载器的生成函数即可离线地重新执行爬取流程
+	SetPageDownloaderGenerator(gen download.PageDownloaderGenerator)
+	//设置登录函数,应在Start之前调用
+	//登录函数会在开始下载之前执行,它设置的cookie会被所有网页下载器共享.
+	//登录失败时Start返回错误,已经启动的组件会被停止,调度器可以被再次启动
+	SetLoginHook(login LoginFunc)
+	//设置条目的模式注册表和拒收处理器,应在Start之前调用
+	//条目在进入条目处理器之前会先被校验,校验失败的条目会被交给拒收处理器(可以为nil)
+	SetSchemaRegistry(registry pipeline.SchemaRegistry, rejectSink pipeline.ProcessItem)
+	//设置条目输出器,应在Start之前调用
+	//条目输出器会被放在所有条目处理器之后,调度器停止时它们缓冲中的条目会被写出
+	SetItemSinks(sinks []pipeline.ItemSink)
+	//设置条目账本和检查点的时间间隔,应在Start之前调用
+	//设置之后,已被写出的条目会被记入账本,使用同一个账本重新爬取时它们不会被再次写出
+	SetItemLedger(ledger pipeline.ItemLedger, checkpointInterval time.Duration)
+	//设置条目处理管道的默认错误策略,应在Start之前调用
+	//它被用于没有设置错误策略的阶段,未设置时快速失败:出错的条目会被报告并忽略后续的所有阶段
+	SetItemErrorPolicy(policy pipeline.ErrorPolicy)
+	//设置重新爬取跟踪器,应在Start之前调用
+	//设置之后调度器进入重新爬取模式:被抓取过的url会在到期之后被再次抓取,内容没有变化的网页不会被分析,
+	//因此只有发生了变化的网页才会产生条目.该模式下调度器不会被视为空闲,需要显式地停止它
+	SetRecrawlTracker(tracker RecrawlTracker)
+	//设置共享的爬取边界和本调度器的工作节点编号,应在Start之前调用
+	//设置之后,解析出的请求(包括首次请求)会被加入共享的爬取边界,调度器只抓取属于自己的分区的请求,
+	//并定期向爬取边界报告自己的状态和摘要信息.只有整个爬取都空闲时调度器才会被视为空闲.
+	//工作节点编号不小于爬取边界的工作节点数量时Start会返回错误
+	SetFrontier(frontier front.Frontier, worker uint32)
+	//调整网页下载器池和分析器池的容量,可以在运行期间调用
+	//扩大时会立即创建新的实体,缩小时正在被使用的实体会在被归还之后淘汰.
+	//下载和分析的goroutine的数量会随之调整,被减少的goroutine会先处理完当前的数据.
+	//设置了并发控制器时,网页下载器池的容量只是下一次调整的起点:控制器会在下一个周期把它限制在
+	//全局并发数的上下限之内并继续按观测结果增减.需要固定容量时应使用上下限相同的并发控制器或者不设置它
+	ResizePools(poolSizeArgs base.PoolBaseArgs) error
+	//设置并发控制器,应在Start之前调用
+	//设置之后,每个主机的并发数由它限制,网页下载器池的容量也会由它定期调整.
+	//并发数已满的主机的请求会被延迟放回请求缓存,不会占用下载的goroutine
+	SetConcurrencyController(controller ConcurrencyController)
+	//设置池的健康参数,应在Start之前调用
+	//设置之后,取出网页下载器或分析器超时时会报告错误而不是一直等待,损坏的实体在归还时会被替换,
+	//被持有过久的实体会被报告为可能已泄漏
+	SetPoolHealthArgs(args PoolHealthArgs)
+	//设置速率限制器,应在Start之前调用
+	//设置之后,每个请求在被下载之前需要等待速率限制器的令牌.速率限制的规则可以在运行期间通过速率限制器修改.
+	//需要等待的请求会被延迟放入请求通道,等待期间不占用下载的goroutine,因此受限的主机不会阻塞其他主机的请求
+	SetRateLimiter(limiter middle.RateLimiter)
 }
 
 //被用来生成http客户端的函数类型
 type GenHttpClient func() *http.Client
 
+//登录函数的类型
+//参数client使用与网页下载器相同的cookie容器,通过它提交登录表单即可让后续的请求带上登录后的cookie
+type LoginFunc func(client *http.Client) error
+
 //调度器的实现
 type myScheduler struct {
-	//池的尺寸
+	//池的尺寸,运行期间可能被并发控制器和ResizePools修改,需要持有poolSizeMutex读写
 	poolSizeArgs base.PoolBaseArgs
+	//保护poolSizeArgs并保证同一时刻只有一次池的容量调整
+	poolSizeMutex sync.Mutex
 	//通道的长度(也即容量)
 	channelArgs base.ChannelArgs
 	//爬取的最大深度,首次请求的深度为0
@@ -67,6 +134,10 @@ type myScheduler struct {
 	dlPool download.PageDownloaderPool
 	//分析器池
 	analyzerPool analy.AnalyzerPool
+	//下载的工作goroutine组
+	dlWorkers *workerGroup
+	//分析的工作goroutine组
+	analyzerWorkers *workerGroup
 	//条目处理管道
 	itemPipeline pipeline.ItemPipeline
 
@@ -76,9 +147,47 @@ type myScheduler struct {
 	reqCache requestCache
 	//已请求的URL的字典
 	urlMap map[string]bool
+	//网页下载器的附加参数
+	downloaderArgs download.DownloaderArgs
+	//网页下载器的生成函数
+	dlGenerator download.PageDownloaderGenerator
+	//被下载器跳过和没有被路由到解析函数的url的计数
+	skippedCount uint64
+	//登录函数
+	login LoginFunc
+	//条目的模式注册表
+	schemaRegistry pipeline.SchemaRegistry
+	//校验失败的条目的拒收处理器
+	rejectSink pipeline.ProcessItem
+	//条目输出器的列表
+	itemSinks []pipeline.ItemSink
+	//条目账本
+	itemLedger pipeline.ItemLedger
+	//检查点的时间间隔
+	checkpointInterval time.Duration
+	//条目处理管道的默认错误策略
+	itemErrorPolicy pipeline.ErrorPolicy
+	//条目通道中的条目全部被发送到条目处理管道之后会被关闭
+	itemsDrained chan struct{}
+	//重新爬取跟踪器
+	recrawlTracker RecrawlTracker
+	//共享的爬取边界
+	frontier front.Frontier
+	//本调度器的工作节点编号
+	worker uint32
+	//共享的爬取边界最近一次被查询到的空闲状态,0表示忙碌,1表示空闲
+	frontierIdle uint32
+	//并发控制器
+	concurrencyController ConcurrencyController
+	//池的健康参数
+	poolHealthArgs PoolHealthArgs
+	//速率限制器
+	rateLimiter middle.RateLimiter
+	//正在下载(包括等待网页下载器)的请求的数量
+	downloading int64
 }
 
-// 日志记录器。
+//日志记录器。
 var logger logging.Logger = base.NewLogger()
 
 const (
@@ -88,6 +197,9 @@ const (
 	SCHEDULER_CODE    = "scheduler"
 )
 
+//主机的并发数已满时请求被延迟的时长
+const hostBusyDelay = 20 * time.Millisecond
+
 //创建调度器
 func NewScheduler() Scheduler {
 	return &myScheduler{}
@@ -100,6 +212,41 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 	respParsers []analy.ParseResponse,
 	itemProcessors []pipeline.ProcessItem,
 	firstHttpReq *http.Request) (err error) {
+	//itemProcessors是一个slice可以添加多个处理器处理数据
+	if itemProcessors == nil {
+		return errors.New("The item processor list is invalid!")
+	}
+	//itemProcessors是个slice.还要判断他的值是否是nil
+	for i, ip := range itemProcessors {
+		if ip == nil {
+			return errors.New(fmt.Sprintf("The %dth item processor is invalid!", i))
+		}
+	}
+	return scheduler.StartWithStages(channelArgs,
+		poolSizeArgs,
+		crawlDepth,
+		httpClientGenerator,
+		respParsers,
+		pipeline.NewProcessorStages(itemProcessors),
+		firstHttpReq)
+}
+
+func (scheduler *myScheduler) StartWithStages(channelArgs base.ChannelArgs,
+	poolSizeArgs base.PoolBaseArgs,
+	crawlDepth uint32,
+	httpClientGenerator GenHttpClient,
+	respParsers []analy.ParseResponse,
+	itemStages []pipeline.ItemStage,
+	firstHttpReq *http.Request) (err error) {
+	//启动失败(包括panic)时停止已经启动的组件并恢复调度器的状态,以便修正参数之后再次启动
+	//该函数最后执行,这时panic已经被转换为错误
+	started := false
+	defer func() {
+		if err != nil && started {
+			scheduler.Stop()
+			atomic.StoreUint32(&scheduler.running, 0)
+		}
+	}()
 	//初始化调度器的各个字段以及开启调度器的过程中有运行时的panic被抛出
 	//调度器能够及时地恢复它并记录下相应的日志
 	defer func() {
@@ -115,6 +262,7 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 	}
 	//更改调度器状态
 	atomic.StoreUint32(&scheduler.running, 1)
+	started = true
 
 	//检查channel的参数是否合法
 	if err := channelArgs.Check(); err != nil {
@@ -126,7 +274,22 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 	if err := poolSizeArgs.Check(); err != nil {
 		return err
 	}
+	scheduler.poolSizeMutex.Lock()
 	scheduler.poolSizeArgs = poolSizeArgs
+	scheduler.poolSizeMutex.Unlock()
+	if err := scheduler.poolHealthArgs.Check(); err != nil {
+		return err
+	}
+	//超出范围的工作节点永远取不到请求,爬取会一直无法结束
+	if scheduler.frontier != nil {
+		workers, err := scheduler.frontier.Workers()
+		if err != nil {
+			return errors.New(fmt.Sprintf("Query frontier failing: %s", err))
+		}
+		if scheduler.worker >= workers {
+			return errors.New(fmt.Sprintf("The worker %d is out of range [0, %d)!", scheduler.worker, workers))
+		}
+	}
 
 	scheduler.crawlDepth = crawlDepth
 	//初始化channelManager.并对reqChan,respChan...赋值
@@ -136,34 +299,54 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 		return errors.New("The http client generator list is invalid!")
 	}
 	//初始化网页下载器池
-	dlPool, err := generatePageDownloaderPool(scheduler.poolSizeArgs.PageDownloaderPoolSize(), httpClientGenerator)
+	dlPool, err := generatePageDownloaderPool(poolSizeArgs.PageDownloaderPoolSize(),
+		httpClientGenerator,
+		scheduler.dlGenerator,
+		scheduler.downloaderArgs)
 	if err != nil {
 		errMsg := fmt.Sprintf("Occur error when get page downloader pool:%s\n", err)
 		return errors.New(errMsg)
 	}
 	scheduler.dlPool = dlPool
+	scheduler.dlPool.SetHealthCheck(scheduler.poolHealthArgs.DownloaderCheck())
 
 	//初始化分析器池
-	analyzerPool, err := generateAnalyzerPool(scheduler.poolSizeArgs.AnalyzerPoolSize())
+	analyzerPool, err := generateAnalyzerPool(poolSizeArgs.AnalyzerPoolSize())
 	if err != nil {
 		errMsg := fmt.Sprintf("Occur error when get analy pool:%s\n", err)
 		return errors.New(errMsg)
 	}
 	scheduler.analyzerPool = analyzerPool
+	scheduler.analyzerPool.SetHealthCheck(scheduler.poolHealthArgs.AnalyzerCheck())
 
-	//条目处理器
-	//itemProcessors是一个slice可以添加多个处理器处理数据
-	if itemProcessors == nil {
-		return errors.New("The item processor list is invalid!")
+	//条目处理阶段
+	//itemStages是一个slice可以添加多个阶段处理数据
+	if itemStages == nil {
+		return errors.New("The item stage list is invalid!")
 	}
-	//itemProcessors是个slice.还要判断他的值是否是nil
-	for i, ip := range itemProcessors {
-		if ip == nil {
-			return errors.New(fmt.Sprintf("The %dth item processor is invalid!", i))
+	//还要检查每个阶段是否有效
+	for i, stage := range itemStages {
+		if err := stage.Check(); err != nil {
+			return errors.New(fmt.Sprintf("The %dth item stage is invalid: %s", i, err))
 		}
 	}
+	if err := scheduler.itemErrorPolicy.Check(); err != nil {
+		return errors.New(fmt.Sprintf("The item error policy is invalid: %s", err))
+	}
 	//条目处理管道
-	scheduler.itemPipeline = generateItemPipeline(itemProcessors)
+	scheduler.itemPipeline = generateItemPipeline(itemStages, scheduler.itemErrorPolicy)
+	if scheduler.schemaRegistry != nil {
+		scheduler.itemPipeline.SetValidator(scheduler.schemaRegistry, scheduler.rejectSink)
+	}
+	for i, sink := range scheduler.itemSinks {
+		if sink == nil {
+			return errors.New(fmt.Sprintf("The %dth item sink is invalid!", i))
+		}
+		scheduler.itemPipeline.AddSink(sink)
+	}
+	if scheduler.itemLedger != nil {
+		scheduler.itemPipeline.SetLedger(scheduler.itemLedger, scheduler.checkpointInterval)
+	}
 
 	//初始化停止信号
 	//如果停止信号还未初始化
@@ -178,13 +361,13 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 	scheduler.reqCache = NewRequestCache()
 	//处理过的url(避免重复处理)
 	scheduler.urlMap = make(map[string]bool)
-
-	//开始下载
-	scheduler.startDownloading()
-	//激活分析器(从respChan管道拿去数据然后进行分析)
-	scheduler.activateAnalyzers(respParsers)
-	scheduler.openItemPipeline()
-	scheduler.schedule(10 * time.Millisecond)
+	atomic.StoreUint64(&scheduler.skippedCount, 0)
+	//被跟踪的url由重新爬取跟踪器负责抓取
+	if scheduler.recrawlTracker != nil {
+		for _, reqUrl := range scheduler.recrawlTracker.URLs() {
+			scheduler.urlMap[reqUrl] = true
+		}
+	}
 
 	if firstHttpReq == nil {
 		return errors.New("The first http request is invalid!")
@@ -195,27 +378,76 @@ func (scheduler *myScheduler) Start(channelArgs base.ChannelArgs,
 	}
 	scheduler.primaryDomain = pd
 
+	//在开始下载之前登录,以免首次请求在登录之前被下载
+	if scheduler.login != nil {
+		client := httpClientGenerator()
+		if client == nil {
+			client = &http.Client{}
+		}
+		if scheduler.downloaderArgs.Jar != nil {
+			client.Jar = scheduler.downloaderArgs.Jar
+		}
+		if err := scheduler.login(client); err != nil {
+			errMsg := fmt.Sprintf("Occur error when login:%s\n", err)
+			return errors.New(errMsg)
+		}
+	}
+
+	//开始下载
+	scheduler.startDownloading()
+	//激活分析器(从respChan管道拿去数据然后进行分析)
+	scheduler.activateAnalyzers(respParsers)
+	scheduler.openItemPipeline()
+	scheduler.schedule(10 * time.Millisecond)
+	if scheduler.recrawlTracker != nil {
+		scheduler.recrawl(time.Second)
+	}
+	if scheduler.frontier != nil {
+		atomic.StoreUint32(&scheduler.frontierIdle, 0)
+		scheduler.syncFrontier(200 * time.Millisecond)
+	}
+	if scheduler.concurrencyController != nil {
+		scheduler.adjustConcurrency(scheduler.concurrencyController.Interval())
+	}
+	if threshold := scheduler.poolHealthArgs.LeakThreshold(); threshold > 0 {
+		scheduler.detectLeaks(threshold / 2)
+	}
+
 	firstreq := base.NewRequest(firstHttpReq, 0)
+	if scheduler.frontier != nil {
+		//首次请求同样交给共享的爬取边界,多个工作节点使用同一个首次请求时只有一个会被抓取
+		if _, err := scheduler.frontier.Push([]front.Entry{front.NewEntry(*firstreq)}); err != nil {
+			return errors.New(fmt.Sprintf("Push the first request to frontier failing: %s", err))
+		}
+		return nil
+	}
 	scheduler.reqCache.put(firstreq)
 
 	return nil
 }
 
 //开始下载
+//下载的goroutine的数量与网页下载器池的容量相同,它们都忙碌时请求会留在请求通道和请求缓存中
 func (scheduler *myScheduler) startDownloading() {
-	go func() {
-		for {
+	reqChan := scheduler.getReqChan()
+	scheduler.dlWorkers = newWorkerGroup(DOWNLOADER_CODE, func(quit <-chan struct{}) {
+		for !quitting(quit) {
 			//从缓存中拿取一条然后处理
 			//因为页面的分析能力大于下载能力.所以先把请求缓存在channel.
-			req, ok := <-scheduler.getReqChan()
-			//管道关闭
-			if !ok {
-				break
+			select {
+			case <-quit:
+				return
+			case req, ok := <-reqChan:
+				//管道关闭
+				if !ok {
+					return
+				}
+				//下载内容
+				scheduler.download(req)
 			}
-			//下载内容
-			go scheduler.download(req)
 		}
-	}()
+	})
+	scheduler.dlWorkers.resize(scheduler.dlPool.Total())
 }
 
 //获取通道管理器持有的请求channel
@@ -228,17 +460,24 @@ func (scheduler *myScheduler) getReqChan() chan base.Request {
 }
 
 //激活分析器
+//分析的goroutine的数量与分析器池的容量相同,它们都忙碌时响应通道满了之后下载的goroutine会被阻塞
 func (scheduler *myScheduler) activateAnalyzers(respParsers []analy.ParseResponse) {
-	go func() {
-		for {
+	respChan := scheduler.getRespChan()
+	scheduler.analyzerWorkers = newWorkerGroup(ANALYZER_CODE, func(quit <-chan struct{}) {
+		for !quitting(quit) {
 			//从响应channel拿出数据分析
-			resp, ok := <-scheduler.getRespChan()
-			if !ok {
-				break
+			select {
+			case <-quit:
+				return
+			case resp, ok := <-respChan:
+				if !ok {
+					return
+				}
+				scheduler.analyze(respParsers, resp)
 			}
-			go scheduler.analyze(respParsers, resp)
 		}
-	}()
+	})
+	scheduler.analyzerWorkers.resize(scheduler.analyzerPool.Total())
 }
 
 func (scheduler *myScheduler) getRespChan() chan base.Response {
@@ -295,6 +534,9 @@ func (scheduler *myScheduler) sendError(err error, code string) bool {
 }
 
 func (scheduler *myScheduler) download(req base.Request) {
+	//等待中的请求还没有取出网页下载器,需要单独计数才能正确判断是否空闲
+	atomic.AddInt64(&scheduler.downloading, 1)
+	defer atomic.AddInt64(&scheduler.downloading, -1)
 
 	defer func() {
 		if p := recover(); p != nil {
@@ -302,8 +544,28 @@ func (scheduler *myScheduler) download(req base.Request) {
 			logger.Fatal(errMsg)
 		}
 	}()
+	//速率限制和主机的并发数已经在调度时处理
+	host := req.HttpReq().URL.Host
 	//从网页下载池中取出一个下载实体
-	downloader, err := scheduler.dlPool.Take()
+	var downloader download.PageDownloader
+	var err error
+	if timeout := scheduler.poolHealthArgs.TakeTimeout(); timeout > 0 {
+		downloader, err = scheduler.dlPool.TakeWithTimeout(timeout)
+	} else {
+		downloader, err = scheduler.dlPool.Take()
+	}
+	if err != nil {
+		if scheduler.concurrencyController != nil {
+			scheduler.concurrencyController.Cancel(host)
+		}
+		//取出超时的请求被放回请求缓存,等待网页下载器空闲之后再下载
+		if !scheduler.stopSign.Signed() {
+			scheduler.reqCache.put(&req)
+		}
+		errMsg := fmt.Sprintf("Downloader pool error:%s (requestUrl=%s)", err, req.HttpReq().URL)
+		scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
+		return
+	}
 	defer func() {
 		//归还下载器
 		err := scheduler.dlPool.Return(downloader)
@@ -312,22 +574,56 @@ func (scheduler *myScheduler) download(req base.Request) {
 			scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
 		}
 	}()
-	if err != nil {
-		errMsg := fmt.Sprintf("Downloader pool error:%s", err)
-		scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
-		return
-	}
 	code := generateCode(DOWNLOADER_CODE, downloader.Id())
+	start := time.Now()
 	respp, err := downloader.Download(req)
+	if scheduler.concurrencyController != nil {
+		scheduler.releaseHost(host, time.Since(start), respp, err)
+	}
+	if respp != nil && scheduler.recrawlTracker != nil && !scheduler.observe(req, *respp, code) {
+		//内容没有变化的网页不需要再次分析
+		respp.HttpResp().Body.Close()
+		respp = nil
+	}
 	if respp != nil {
 		scheduler.sendResp(*respp, code)
 	}
 	if err != nil {
+		//被内容过滤器跳过的url只做计数,不作为错误上报
+		if download.IsSkipError(err) {
+			atomic.AddUint64(&scheduler.skippedCount, 1)
+			logger.Infof("%s\n", err)
+			return
+		}
 		scheduler.sendError(err, code)
 	}
 
 }
 
+//把下载的结果报告给并发控制器,被跳过的url不算作错误
+func (scheduler *myScheduler) releaseHost(host string, latency time.Duration, respp *base.Response, err error) {
+	statusCode := 0
+	if respp != nil && respp.HttpResp() != nil {
+		statusCode = respp.HttpResp().StatusCode
+	}
+	if download.IsSkipError(err) {
+		err = nil
+	}
+	scheduler.concurrencyController.Release(host, latency, statusCode, err)
+}
+
+//把抓取的结果交给重新爬取跟踪器,返回内容是否发生了变化
+//无法计算内容摘要时会报告错误,并把内容视为发生了变化
+func (scheduler *myScheduler) observe(req base.Request, resp base.Response, code string) bool {
+	hash, err := hashResponse(resp.HttpResp())
+	if err != nil {
+		errMsg := fmt.Sprintf("Hash the response failing: %s (requestUrl=%s)", err, req.HttpReq().URL)
+		scheduler.sendError(errors.New(errMsg), code)
+		return true
+	}
+	return scheduler.recrawlTracker.Observe(req.HttpReq().URL.String(), req.Depth(), hash, time.Now())
+}
+
 func (scheduler *myScheduler) sendResp(resp base.Response, code string) bool {
 	//判断是否已经停止
 	if scheduler.stopSign.Signed() {
@@ -356,8 +652,23 @@ func (scheduler *myScheduler) analyze(parsers []analy.ParseResponse, response ba
 		}
 	}()
 	//从分析池取一个实体
-	analyzer, err := scheduler.analyzerPool.Take()
+	var analyzer analy.Analyzer
+	var err error
+	if timeout := scheduler.poolHealthArgs.TakeTimeout(); timeout > 0 {
+		analyzer, err = scheduler.analyzerPool.TakeWithTimeout(timeout)
+	} else {
+		analyzer, err = scheduler.analyzerPool.Take()
+	}
 	if err != nil {
+		//取出超时的响应无法被分析,它的请求被放回请求缓存,等待分析器空闲之后重新下载.
+		//把响应放回响应通道可能会让所有分析的goroutine都阻塞在发送上
+		httpResp := response.HttpResp()
+		if httpResp != nil && httpResp.Body != nil {
+			httpResp.Body.Close()
+		}
+		if httpResp != nil && httpResp.Request != nil && !scheduler.stopSign.Signed() {
+			scheduler.reqCache.put(requestOfResponse(response))
+		}
 		errMsg := fmt.Sprintf("Analyzer pool error:%s\n", err)
 		scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
 		return
@@ -392,11 +703,26 @@ func (scheduler *myScheduler) analyze(parsers []analy.ParseResponse, response ba
 	}
 	if errs != nil {
 		for _, err := range errs {
+			//没有被路由到任何解析函数的响应和被内容过滤器跳过的url一样只做计数
+			if analy.IsSkipError(err) {
+				atomic.AddUint64(&scheduler.skippedCount, 1)
+				logger.Infof("%s\n", err)
+				continue
+			}
 			scheduler.sendError(err, code)
 		}
 	}
 }
 
+//根据响应重新生成请求,只属于响应的元数据会被去掉
+func requestOfResponse(response base.Response) *base.Request {
+	req := base.NewRequestWithMeta(response.HttpResp().Request, response.Depth(), response.Meta())
+	for _, key := range []string{base.META_RENDERED, base.META_REPLAYED, base.META_CACHE_STATUS, base.META_ORIGINAL_CHARSET} {
+		delete(req.Meta(), key)
+	}
+	return req
+}
+
 func (scheduler *myScheduler) saveReqToCache(request base.Request, code string) bool {
 	httpReq := request.HttpReq()
 	if httpReq == nil {
@@ -429,38 +755,41 @@ func (scheduler *myScheduler) saveReqToCache(request base.Request, code string)
 		scheduler.stopSign.Deal(code)
 		return false
 	}
-	//请求放入缓存中
-	scheduler.reqCache.put(&request)
 	//标记url已经爬取过
 	scheduler.urlMap[reqUrl.String()] = true
+	//请求交给共享的爬取边界,由负责该分区的工作节点抓取
+	if scheduler.frontier != nil {
+		if _, err := scheduler.frontier.Push([]front.Entry{front.NewEntry(request)}); err != nil {
+			errMsg := fmt.Sprintf("Push the request to frontier failing: %s (requestUrl=%s)", err, reqUrl)
+			scheduler.sendError(errors.New(errMsg), code)
+			return false
+		}
+		return true
+	}
+	//请求放入缓存中
+	scheduler.reqCache.put(&request)
 	return true
 }
 
 //打开条目处理管道
 func (scheduler *myScheduler) openItemPipeline() {
+	scheduler.itemsDrained = make(chan struct{})
 	go func() {
-		//设置快速失败
-		//FailFast 方法会返回一个布尔值.该值标识当前的条目处理管道是否是快速失败的
-		//快速失败:只要对某个条目的处理流程在某一个步骤上出错
-		//那么条目处理管道就会忽略掉后续的所有处理步骤并报告错误
-		scheduler.itemPipeline.SetFailFast(true)
+		defer close(scheduler.itemsDrained)
 		code := ITEMPIPELINE_CODE
+		//接收各阶段处理条目时出现的错误
+		go func() {
+			for err := range scheduler.itemPipeline.ErrorChan() {
+				scheduler.sendError(err, code)
+			}
+		}()
 		//从条目管道取出条目
+		//条目处理管道的队列已满时Send会被阻塞,条目通道随之被填满,由此形成反压
 		for item := range scheduler.getItemChan() {
-			go func(item base.Item) {
-				defer func() {
-					if p := recover(); p != nil {
-						errMsg := fmt.Sprintf("Fatal Item Processing Error:%s\n", p)
-						logger.Fatal(errMsg)
-					}
-					errs := scheduler.itemPipeline.Send(item)
-					if errs != nil {
-						for _, err := range errs {
-							scheduler.sendError(err, code)
-						}
-					}
-				}()
-			}(item)
+			errs := scheduler.itemPipeline.Send(item)
+			for _, err := range errs {
+				scheduler.sendError(err, code)
+			}
 		}
 	}()
 }
@@ -479,12 +808,23 @@ func (scheduler *myScheduler) schedule(interval time.Duration) {
 			remainder := cap(scheduler.getReqChan()) - len(scheduler.getReqChan())
 			var temp *base.Request
 			for remainder > 0 {
-				temp = scheduler.reqCache.get()
+				//到期的被延迟的请求已经预约了令牌,优先发送
+				temp = scheduler.reqCache.getDue(time.Now())
 				if temp == nil {
-					break
+					temp = scheduler.reqCache.get()
+					if temp == nil {
+						break
+					}
+					if scheduler.throttle(temp) {
+						continue
+					}
+				}
+				//主机的并发数已满的请求稍后再试,它已经预约的令牌会被保留
+				if !scheduler.acquireHost(temp) {
+					continue
 				}
 				//有必要多判断一次,因为程序可能时刻中断,
-				// 而for循环内执行代码需要一定时间
+				//而for循环内执行代码需要一定时间
 				//所以在请求发送之前是有必要多判断一次的
 				if scheduler.stopSign.Signed() {
 					scheduler.stopSign.Deal(SCHEDULER_CODE)
@@ -498,14 +838,234 @@ func (scheduler *myScheduler) schedule(interval time.Duration) {
 	}()
 }
 
+//为请求预约速率限制的令牌,需要等待时把它延迟放回请求缓存并返回true
+//请求在到期之前不会被发送到请求通道,以免下载的goroutine被受限的主机占用
+func (scheduler *myScheduler) throttle(req *base.Request) bool {
+	if scheduler.rateLimiter == nil {
+		return false
+	}
+	wait, err := scheduler.rateLimiter.Reserve(req.HttpReq().URL)
+	if err != nil {
+		scheduler.sendError(errors.New(fmt.Sprintf("Rate limiter error:%s", err)), SCHEDULER_CODE)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	return scheduler.reqCache.delay(req, time.Now().Add(wait))
+}
+
+//为请求占用主机的并发数,并发数已满时把它延迟放回请求缓存并返回false
+func (scheduler *myScheduler) acquireHost(req *base.Request) bool {
+	if scheduler.concurrencyController == nil {
+		return true
+	}
+	if scheduler.concurrencyController.TryAcquire(req.HttpReq().URL.Host) {
+		return true
+	}
+	scheduler.reqCache.delay(req, time.Now().Add(hostBusyDelay))
+	return false
+}
+
+//重新爬取,定期把到期的url放入请求缓存,并保存跟踪的状态
+func (scheduler *myScheduler) recrawl(interval time.Duration) {
+	go func() {
+		lastSave := time.Now()
+		for {
+			if scheduler.stopSign.Signed() {
+				scheduler.stopSign.Deal(SCHEDULER_CODE)
+				return
+			}
+			for _, req := range scheduler.recrawlTracker.Due(time.Now()) {
+				scheduler.reqCache.put(req)
+			}
+			if time.Since(lastSave) >= recrawlSaveInterval {
+				if err := scheduler.recrawlTracker.Save(); err != nil {
+					errMsg := fmt.Sprintf("Save recrawl state failing: %s", err)
+					scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
+				}
+				lastSave = time.Now()
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+//与共享的爬取边界同步
+//请求缓存中的请求不足时从爬取边界取出属于本工作节点的请求,然后报告本工作节点的状态并查询整个爬取的空闲状态
+func (scheduler *myScheduler) syncFrontier(interval time.Duration) {
+	go func() {
+		for {
+			if scheduler.stopSign.Signed() {
+				scheduler.stopSign.Deal(SCHEDULER_CODE)
+				return
+			}
+			reqChan := scheduler.getReqChan()
+			//被延迟的请求不计算在内,以免受限的主机使其他主机的请求无法被取出
+			ready := scheduler.reqCache.length() - scheduler.reqCache.delayedLength()
+			if remainder := cap(reqChan) - ready; remainder > 0 {
+				entries, err := scheduler.frontier.Pop(scheduler.worker, remainder)
+				if err != nil {
+					scheduler.sendError(errors.New(fmt.Sprintf("Pop requests from frontier failing: %s", err)), SCHEDULER_CODE)
+				}
+				for _, entry := range entries {
+					req, err := entry.Request()
+					if err != nil {
+						logger.Warnf("Ignore the request from frontier! (requestUrl=%s): %s\n", entry.URL, err)
+						continue
+					}
+					scheduler.reqCache.put(req)
+				}
+			}
+			idle := scheduler.localIdle() && scheduler.reqCache.length() == 0 && len(reqChan) == 0
+			if err := scheduler.frontier.Report(scheduler.worker, idle, scheduler.Summary("  ").String()); err != nil {
+				scheduler.sendError(errors.New(fmt.Sprintf("Report to frontier failing: %s", err)), SCHEDULER_CODE)
+			}
+			if frontierIdle, err := scheduler.frontier.Idle(); err != nil {
+				scheduler.sendError(errors.New(fmt.Sprintf("Query frontier failing: %s", err)), SCHEDULER_CODE)
+			} else if frontierIdle {
+				atomic.StoreUint32(&scheduler.frontierIdle, 1)
+			} else {
+				atomic.StoreUint32(&scheduler.frontierIdle, 0)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+//定期让并发控制器调整并发数,并按照它的结果调整网页下载器池的容量
+func (scheduler *myScheduler) adjustConcurrency(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if scheduler.stopSign.Signed() {
+				scheduler.stopSign.Deal(SCHEDULER_CODE)
+				return
+			}
+			if err := scheduler.applyConcurrency(); err != nil {
+				scheduler.sendError(err, SCHEDULER_CODE)
+			}
+		}
+	}()
+}
+
+//让并发控制器调整网页下载器池的容量
+func (scheduler *myScheduler) applyConcurrency() error {
+	scheduler.poolSizeMutex.Lock()
+	defer scheduler.poolSizeMutex.Unlock()
+	current := scheduler.dlPool.Total()
+	concurrency := scheduler.concurrencyController.Adjust(current)
+	if concurrency == current {
+		return nil
+	}
+	if err := scheduler.dlPool.Resize(concurrency); err != nil {
+		return errors.New(fmt.Sprintf("Resize the page downloader pool failing: %s", err))
+	}
+	scheduler.dlWorkers.resize(concurrency)
+	scheduler.poolSizeArgs = base.NewPoolBaseArgs(concurrency, scheduler.poolSizeArgs.AnalyzerPoolSize())
+	return nil
+}
+
+//获得池的尺寸
+func (scheduler *myScheduler) getPoolSizeArgs() base.PoolBaseArgs {
+	scheduler.poolSizeMutex.Lock()
+	defer scheduler.poolSizeMutex.Unlock()
+	return scheduler.poolSizeArgs
+}
+
+//定期检查网页下载器池和分析器池中被持有过久的实体,每个可能已泄漏的实体只报告一次
+func (scheduler *myScheduler) detectLeaks(interval time.Duration) {
+	go func() {
+		reported := make(map[leakKey]bool)
+		for {
+			time.Sleep(interval)
+			if scheduler.stopSign.Signed() {
+				scheduler.stopSign.Deal(SCHEDULER_CODE)
+				return
+			}
+			threshold := scheduler.poolHealthArgs.LeakThreshold()
+			current := make(map[leakKey]bool)
+			scheduler.reportLeaks(DOWNLOADER_CODE, scheduler.dlPool.Leaks(threshold), reported, current)
+			scheduler.reportLeaks(ANALYZER_CODE, scheduler.analyzerPool.Leaks(threshold), reported, current)
+			//已被归还的实体不再需要记录
+			reported = current
+		}
+	}()
+}
+
+//报告新发现的泄漏,本次发现的所有泄漏会被记入参数current
+func (scheduler *myScheduler) reportLeaks(poolName string, leaks []middle.Leak, reported, current map[leakKey]bool) {
+	for _, leak := range leaks {
+		key := leakKey{pool: poolName, id: leak.Id, takenAt: leak.TakenAt}
+		current[key] = true
+		if reported[key] {
+			continue
+		}
+		errMsg := fmt.Sprintf("The entity (id=%d) of %s pool may be leaked! (held=%s)",
+			leak.Id, poolName, leak.Held.Truncate(time.Millisecond))
+		scheduler.sendError(errors.New(errMsg), SCHEDULER_CODE)
+	}
+}
+
 func (scheduler *myScheduler) Stop() bool {
 	if atomic.LoadUint32(&scheduler.running) != 1 {
 		return false
 	}
 
-	scheduler.stopSign.Sign()
-	scheduler.chanman.Close()
-	scheduler.reqCache.close()
+	//Start可能在中途因参数无效而返回,这时一部分组件还没有被初始化
+	if scheduler.stopSign != nil {
+		scheduler.stopSign.Sign()
+	}
+	//关闭并发控制器
+	if scheduler.concurrencyController != nil {
+		scheduler.concurrencyController.Close()
+	}
+	if scheduler.chanman != nil {
+		scheduler.chanman.Close()
+	}
+	if scheduler.reqCache != nil {
+		scheduler.reqCache.close()
+	}
+	//等待条目通道中剩余的条目被发送到条目处理管道,否则它们会丢失
+	if scheduler.itemsDrained != nil {
+		<-scheduler.itemsDrained
+	}
+	//写出条目输出器缓冲中的条目
+	if scheduler.itemPipeline != nil {
+		for _, err := range scheduler.itemPipeline.Close() {
+			logger.Errorf("Close item pipeline failing: %s\n", err)
+		}
+	}
+	//持久化cookie
+	if jar, ok := scheduler.downloaderArgs.Jar.(download.CookieJar); ok {
+		if err := jar.Save(); err != nil {
+			logger.Errorf("Save cookies failing: %s\n", err)
+		}
+	}
+	//关闭渲染器
+	if scheduler.downloaderArgs.Renderer != nil {
+		if err := scheduler.downloaderArgs.Renderer.Close(); err != nil {
+			logger.Errorf("Close renderer failing: %s\n", err)
+		}
+	}
+	//关闭响应记录器
+	if scheduler.downloaderArgs.Recorder != nil {
+		if err := scheduler.downloaderArgs.Recorder.Close(); err != nil {
+			logger.Errorf("Close response recorder failing: %s\n", err)
+		}
+	}
+	//告诉共享的爬取边界本工作节点已经停止,爬取边界由创建它的一方关闭
+	if scheduler.frontier != nil {
+		if err := scheduler.frontier.Report(scheduler.worker, true, scheduler.Summary("  ").String()); err != nil {
+			logger.Errorf("Report to frontier failing: %s\n", err)
+		}
+	}
+	//保存重新爬取的状态
+	if scheduler.recrawlTracker != nil {
+		if err := scheduler.recrawlTracker.Save(); err != nil {
+			logger.Errorf("Save recrawl state failing: %s\n", err)
+		}
+	}
 	atomic.StoreUint32(&scheduler.running, 2)
 	return true
 }
@@ -524,8 +1084,23 @@ func (scheduler *myScheduler) ErrorChan() <-chan error {
 }
 
 //检查是否空闲
+//重新爬取模式下调度器总是在等待下一批到期的url,因此不会被视为空闲
+//使用共享的爬取边界时,只有本工作节点和整个爬取都空闲时调度器才是空闲的
 func (scheduler *myScheduler) Idle() bool {
-	idleDlPool := scheduler.dlPool.Used() == 0
+	if scheduler.recrawlTracker != nil {
+		return false
+	}
+	if scheduler.frontier != nil && atomic.LoadUint32(&scheduler.frontierIdle) != 1 {
+		return false
+	}
+	return scheduler.localIdle()
+}
+
+//检查本调度器的各个处理模块是否都空闲
+func (scheduler *myScheduler) localIdle() bool {
+	idleDlPool := scheduler.dlPool.Used() == 0 && atomic.LoadInt64(&scheduler.downloading) == 0
+	//等待速率限制的请求还没有被下载
+	idleDlPool = idleDlPool && scheduler.reqCache.delayedLength() == 0
 	idleAnalyzerPool := scheduler.analyzerPool.Used() == 0
 	idleItemPipeline := scheduler.itemPipeline.ProcessingNumber() == 0
 
@@ -535,6 +1110,78 @@ func (scheduler *myScheduler) Idle() bool {
 	return false
 }
 
+func (scheduler *myScheduler) SetDownloaderArgs(args download.DownloaderArgs) {
+	scheduler.downloaderArgs = args
+}
+
+func (scheduler *myScheduler) SetPageDownloaderGenerator(gen download.PageDownloaderGenerator) {
+	scheduler.dlGenerator = gen
+}
+
+func (scheduler *myScheduler) SetRecrawlTracker(tracker RecrawlTracker) {
+	scheduler.recrawlTracker = tracker
+}
+
+func (scheduler *myScheduler) ResizePools(poolSizeArgs base.PoolBaseArgs) error {
+	if atomic.LoadUint32(&scheduler.running) != 1 {
+		return errors.New("The scheduler is not running!")
+	}
+	if err := poolSizeArgs.Check(); err != nil {
+		return err
+	}
+	scheduler.poolSizeMutex.Lock()
+	defer scheduler.poolSizeMutex.Unlock()
+	if err := scheduler.dlPool.Resize(poolSizeArgs.PageDownloaderPoolSize()); err != nil {
+		return errors.New(fmt.Sprintf("Resize the page downloader pool failing: %s", err))
+	}
+	scheduler.dlWorkers.resize(poolSizeArgs.PageDownloaderPoolSize())
+	if err := scheduler.analyzerPool.Resize(poolSizeArgs.AnalyzerPoolSize()); err != nil {
+		return errors.New(fmt.Sprintf("Resize the analyzer pool failing: %s", err))
+	}
+	scheduler.analyzerWorkers.resize(poolSizeArgs.AnalyzerPoolSize())
+	scheduler.poolSizeArgs = poolSizeArgs
+	return nil
+}
+
+func (scheduler *myScheduler) SetRateLimiter(limiter middle.RateLimiter) {
+	scheduler.rateLimiter = limiter
+}
+
+func (scheduler *myScheduler) SetPoolHealthArgs(args PoolHealthArgs) {
+	scheduler.poolHealthArgs = args
+}
+
+func (scheduler *myScheduler) SetConcurrencyController(controller ConcurrencyController) {
+	scheduler.concurrencyController = controller
+}
+
+func (scheduler *myScheduler) SetFrontier(frontier front.Frontier, worker uint32) {
+	scheduler.frontier = frontier
+	scheduler.worker = worker
+}
+
+func (scheduler *myScheduler) SetLoginHook(login LoginFunc) {
+	scheduler.login = login
+}
+
+func (scheduler *myScheduler) SetSchemaRegistry(registry pipeline.SchemaRegistry, rejectSink pipeline.ProcessItem) {
+	scheduler.schemaRegistry = registry
+	scheduler.rejectSink = rejectSink
+}
+
+func (scheduler *myScheduler) SetItemSinks(sinks []pipeline.ItemSink) {
+	scheduler.itemSinks = sinks
+}
+
+func (scheduler *myScheduler) SetItemLedger(ledger pipeline.ItemLedger, checkpointInterval time.Duration) {
+	scheduler.itemLedger = ledger
+	scheduler.checkpointInterval = checkpointInterval
+}
+
+func (scheduler *myScheduler) SetItemErrorPolicy(policy pipeline.ErrorPolicy) {
+	scheduler.itemErrorPolicy = policy
+}
+
 //获取摘要信息
 func (scheduler *myScheduler) Summary(prefix string) SchedSummary {
 	return NewSchedSummary(scheduler, prefix)
@@ -0,0 +1,424 @@
+package scheduler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net"
+	"context"
+	"runtime"
+	"sort"
+	"summerWebCrawler/analyzer"
+	"summerWebCrawler/base"
+	download "summerWebCrawler/downloadder"
+	"summerWebCrawler/itempipeline"
+	"summerWebCrawler/middleware"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//基准测试中被放入请求缓存的url的数量
+const benchUrlCount = 2000
+
+//基准测试中服务器处理每个请求的延迟
+const benchServerDelay = 100 * time.Millisecond
+
+//资源使用的峰值采样器
+type peakSampler struct {
+	//goroutine数量的峰值
+	goroutines int
+	//HeapInuse的峰值
+	heapInuse uint64
+	//停止信号
+	quit chan struct{}
+	//采样goroutine结束的信号
+	done sync.WaitGroup
+}
+
+//开始采样
+func startPeakSampler(interval time.Duration) *peakSampler {
+	sampler := &peakSampler{quit: make(chan struct{})}
+	sampler.done.Add(1)
+	go func() {
+		defer sampler.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			sampler.sample()
+			select {
+			case <-sampler.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return sampler
+}
+
+//采样一次
+func (sampler *peakSampler) sample() {
+	if n := runtime.NumGoroutine(); n > sampler.goroutines {
+		sampler.goroutines = n
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapInuse > sampler.heapInuse {
+		sampler.heapInuse = memStats.HeapInuse
+	}
+}
+
+//停止采样
+func (sampler *peakSampler) stop() {
+	close(sampler.quit)
+	sampler.done.Wait()
+}
+
+//首页之外的每个网页都延迟一段时间才返回,首页的解析函数会一次产生benchUrlCount个请求
+func newDelayServer(served *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchServerDelay)
+		atomic.AddInt64(served, 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><body>%s</body></html>", r.URL.Path)
+	}))
+}
+
+//只解析首页,为它生成所有的请求
+func benchParser(serverUrl string) analyzer.ParseResponse {
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		if respDepth > 0 {
+			return nil, nil
+		}
+		dataList := make([]base.Data, 0, benchUrlCount)
+		for i := 0; i < benchUrlCount; i++ {
+			httpReq, err := http.NewRequest("GET", fmt.Sprintf("%s/page/%d", serverUrl, i), nil)
+			if err != nil {
+				return nil, []error{err}
+			}
+			dataList = append(dataList, base.NewRequest(httpReq, respDepth+1))
+		}
+		return dataList, nil
+	}
+}
+
+//爬取benchUrlCount个延迟返回的网页,报告goroutine数量和HeapInuse的峰值
+//固定数量的下载goroutine使goroutine数量不随被缓存的请求数量增长
+func BenchmarkSchedulerDelayedPages(b *testing.B) {
+	var served int64
+	server := newDelayServer(&served)
+	defer server.Close()
+	var peakGoroutines int
+	var peakHeapInuse uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		atomic.StoreInt64(&served, 0)
+		sampler := startPeakSampler(10 * time.Millisecond)
+		scheduler := NewScheduler()
+		firstHttpReq, err := http.NewRequest("GET", server.URL+"/", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = scheduler.StartWithStages(
+			base.NewChannelArgs(10, 10, 10, 10),
+			base.NewPoolBaseArgs(50, 10),
+			1,
+			func() *http.Client { return &http.Client{} },
+			[]analyzer.ParseResponse{benchParser(server.URL)},
+			[]itempipeline.ItemStage{itempipeline.NewItemStage("bench", func(item base.Item) (base.Item, error) {
+				return item, nil
+			}, 1, 1)},
+			firstHttpReq)
+		if err != nil {
+			b.Fatalf("Start scheduler failing: %s", err)
+		}
+		go func() {
+			for range scheduler.ErrorChan() {
+			}
+		}()
+		deadline := time.Now().Add(time.Minute)
+		for atomic.LoadInt64(&served) < benchUrlCount+1 || !scheduler.Idle() {
+			if time.Now().After(deadline) {
+				b.Fatalf("The crawl is not finished in time (served=%d)", atomic.LoadInt64(&served))
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		scheduler.Stop()
+		sampler.stop()
+		if sampler.goroutines > peakGoroutines {
+			peakGoroutines = sampler.goroutines
+		}
+		if sampler.heapInuse > peakHeapInuse {
+			peakHeapInuse = sampler.heapInuse
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(peakGoroutines), "peak-goroutines")
+	b.ReportMetric(float64(peakHeapInuse), "peak-heap-inuse-bytes")
+}
+
+//只解析首页,为它生成参数paths中的每个路径的请求
+func pathsParser(serverUrl string, paths []string) analyzer.ParseResponse {
+	return func(httpResp *http.Response, respDepth uint32, respMeta base.Meta) ([]base.Data, []error) {
+		if respDepth > 0 {
+			return nil, nil
+		}
+		dataList := make([]base.Data, 0, len(paths))
+		for _, path := range paths {
+			httpReq, err := http.NewRequest("GET", serverUrl+path, nil)
+			if err != nil {
+				return nil, []error{err}
+			}
+			dataList = append(dataList, base.NewRequest(httpReq, respDepth+1))
+		}
+		return dataList, nil
+	}
+}
+
+//以服务器的首页为首次请求启动调度器,爬取深度为1,参数poolSize是网页下载器池和分析器池的容量,
+//参数genClient为nil时使用默认的http客户端.启动成功时错误通道会被持续地清空
+func startTestScheduler(scheduler Scheduler, serverUrl string, parser analyzer.ParseResponse, poolSize uint32,
+	genClient GenHttpClient) error {
+	if genClient == nil {
+		genClient = func() *http.Client { return &http.Client{} }
+	}
+	firstHttpReq, err := http.NewRequest("GET", serverUrl+"/", nil)
+	if err != nil {
+		return err
+	}
+	err = scheduler.Start(
+		base.NewChannelArgs(10, 10, 10, 10),
+		base.NewPoolBaseArgs(poolSize, poolSize),
+		1,
+		genClient,
+		[]analyzer.ParseResponse{parser},
+		[]itempipeline.ProcessItem{func(item base.Item) (base.Item, error) {
+			return item, nil
+		}},
+		firstHttpReq)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range scheduler.ErrorChan() {
+		}
+	}()
+	return nil
+}
+
+//等待调度器空闲,参数done判断爬取是否已经完成
+func waitIdle(t *testing.T, scheduler Scheduler, done func() bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !done() || !scheduler.Idle() {
+		if time.Now().After(deadline) {
+			t.Fatalf("The crawl is not finished in %s", timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSchedulerThrottledRequestsDoNotBlockWorkers(t *testing.T) {
+	var mutex sync.Mutex
+	servedAt := make(map[string]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		servedAt[r.URL.Path] = time.Now()
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><body>%s</body></html>", r.URL.Path)
+	}))
+	defer server.Close()
+	//受限的请求排在前面,它们在等待令牌期间不能占用仅有的两个下载goroutine
+	var paths []string
+	for i := 0; i < 4; i++ {
+		paths = append(paths, fmt.Sprintf("/slow/%d", i))
+	}
+	for i := 0; i < 20; i++ {
+		paths = append(paths, fmt.Sprintf("/fast/%d", i))
+	}
+	limiter, err := middleware.NewRateLimiter([]middleware.RateRule{
+		middleware.NewRateRule(middleware.RATE_SCOPE_PATTERN, "/slow/", 2, 1),
+	})
+	if err != nil {
+		t.Fatalf("Create rate limiter failing: %s", err)
+	}
+	scheduler := NewScheduler()
+	scheduler.SetRateLimiter(limiter)
+	if err := startTestScheduler(scheduler, server.URL, pathsParser(server.URL, paths), 2, nil); err != nil {
+		t.Fatalf("Start scheduler failing: %s", err)
+	}
+	defer scheduler.Stop()
+	served := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(servedAt)
+	}
+	waitIdle(t, scheduler, func() bool { return served() >= len(paths)+1 }, 10*time.Second)
+	mutex.Lock()
+	defer mutex.Unlock()
+	//第二个受限的请求要在第一个之后0.5秒才能被下载,不受限的请求都应该在它之前被下载
+	secondSlow := servedAt["/slow/1"]
+	if secondSlow.Sub(servedAt["/slow/0"]) < 400*time.Millisecond {
+		t.Errorf("The throttled requests are not rate limited: %s", secondSlow.Sub(servedAt["/slow/0"]))
+	}
+	for path, at := range servedAt {
+		if strings.HasPrefix(path, "/fast/") && !at.Before(secondSlow) {
+			t.Errorf("The request %s is blocked by the throttled requests", path)
+		}
+	}
+}
+
+//登录之后才能访问的服务器,返回服务器和记录首页是否在登录之后被访问的函数
+func newLoginServer() (*httptest.Server, func() (visited bool, loggedIn bool)) {
+	var mutex sync.Mutex
+	var visited, loggedIn bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+			return
+		}
+		mutex.Lock()
+		visited = true
+		cookie, err := r.Cookie("session")
+		loggedIn = err == nil && cookie.Value == "ok"
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body>home</body></html>")
+	}))
+	return server, func() (bool, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return visited, loggedIn
+	}
+}
+
+func TestSchedulerLoginBeforeDownloading(t *testing.T) {
+	server, state := newLoginServer()
+	defer server.Close()
+	jar, err := download.NewCookieJar("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler()
+	scheduler.SetDownloaderArgs(download.DownloaderArgs{Jar: jar})
+	scheduler.SetLoginHook(func(client *http.Client) error {
+		//登录较慢时首次请求也不能被提前下载
+		time.Sleep(100 * time.Millisecond)
+		resp, err := client.Get(server.URL + "/login")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+	if err := startTestScheduler(scheduler, server.URL, pathsParser(server.URL, nil), 2, nil); err != nil {
+		t.Fatalf("Start scheduler failing: %s", err)
+	}
+	defer scheduler.Stop()
+	waitIdle(t, scheduler, func() bool { visited, _ := state(); return visited }, 5*time.Second)
+	if _, loggedIn := state(); !loggedIn {
+		t.Errorf("The first request is downloaded without the login session")
+	}
+}
+
+func TestSchedulerLoginFailureStopsStart(t *testing.T) {
+	server, state := newLoginServer()
+	defer server.Close()
+	scheduler := NewScheduler()
+	scheduler.SetLoginHook(func(client *http.Client) error {
+		return fmt.Errorf("wrong password")
+	})
+	if err := startTestScheduler(scheduler, server.URL, pathsParser(server.URL, nil), 2, nil); err == nil {
+		scheduler.Stop()
+		t.Fatalf("Start succeeds with a failing login hook")
+	}
+	if scheduler.Running() {
+		t.Errorf("The scheduler is still running after a failed start")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if visited, _ := state(); visited {
+		t.Errorf("The first request is downloaded although the login failed")
+	}
+	//修正登录函数之后可以再次启动
+	scheduler.SetLoginHook(func(client *http.Client) error { return nil })
+	if err := startTestScheduler(scheduler, server.URL, pathsParser(server.URL, nil), 2, nil); err != nil {
+		t.Fatalf("Restart scheduler failing: %s", err)
+	}
+	defer scheduler.Stop()
+	waitIdle(t, scheduler, func() bool { visited, _ := state(); return visited }, 5*time.Second)
+}
+
+//所有主机的请求都被发送到服务器的http客户端,用来模拟属于同一个主域名的多个主机
+func serverClient(server *httptest.Server) GenHttpClient {
+	addr := server.Listener.Addr().String()
+	return func() *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+	}
+}
+
+func TestSchedulerBusyHostDoesNotBlockWorkers(t *testing.T) {
+	var mutex sync.Mutex
+	startedAt := make(map[string]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		startedAt[r.Host+r.URL.Path] = time.Now()
+		mutex.Unlock()
+		if strings.HasPrefix(r.Host, "slow.") {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><body>%s</body></html>", r.URL.Path)
+	}))
+	defer server.Close()
+	//慢主机的请求排在前面,每个主机的并发数为1,等待并发数的请求不能占用其余的下载goroutine
+	var urls []string
+	for i := 0; i < 4; i++ {
+		urls = append(urls, fmt.Sprintf("http://slow.example.com/%d", i))
+	}
+	for i := 0; i < 8; i++ {
+		urls = append(urls, fmt.Sprintf("http://fast.example.com/%d", i))
+	}
+	controller, err := NewAIMDController(NewConcurrencyArgs(4, 4, 1, 1, 0, time.Hour))
+	if err != nil {
+		t.Fatalf("Create concurrency controller failing: %s", err)
+	}
+	scheduler := NewScheduler()
+	scheduler.SetConcurrencyController(controller)
+	if err := startTestScheduler(scheduler, "http://www.example.com", pathsParser("", urls), 4, serverClient(server)); err != nil {
+		t.Fatalf("Start scheduler failing: %s", err)
+	}
+	defer scheduler.Stop()
+	started := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(startedAt)
+	}
+	waitIdle(t, scheduler, func() bool { return started() >= len(urls)+1 }, 10*time.Second)
+	mutex.Lock()
+	defer mutex.Unlock()
+	//第二个慢主机的请求要等第一个完成之后才能开始,快主机的请求都应该在它之前开始
+	//慢主机的请求应该一个接一个地开始,快主机的请求都应该在第一个慢主机的请求完成之前开始
+	var slowStarts []time.Time
+	for key, at := range startedAt {
+		if strings.HasPrefix(key, "slow.") {
+			slowStarts = append(slowStarts, at)
+		}
+	}
+	sort.Slice(slowStarts, func(i, j int) bool { return slowStarts[i].Before(slowStarts[j]) })
+	for i := 1; i < len(slowStarts); i++ {
+		if gap := slowStarts[i].Sub(slowStarts[i-1]); gap < 400*time.Millisecond {
+			t.Errorf("The busy host is not limited to one download: %s", gap)
+		}
+	}
+	firstSlowDone := slowStarts[0].Add(300 * time.Millisecond)
+	for key, at := range startedAt {
+		if strings.HasPrefix(key, "fast.") && !at.Before(firstSlowDone) {
+			t.Errorf("The request %s is blocked by the busy host", key)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"summerWebCrawler/base"
+	"sync/atomic"
 )
 
 type SchedSummary interface {
@@ -38,14 +39,30 @@ type mySchedSummary struct {
 	dlPoolLen uint32
 	//网页下载器池的容量
 	dlPoolCap uint32
+	//网页下载器池中被替换的网页下载器的数量
+	dlPoolReplaced uint32
+	//下载的goroutine的数量
+	dlWorkers uint32
 	//分析器池的长度
 	analyzerPoolLen uint32
 	//分析器池的容量
 	analyzerPoolCap uint32
+	//分析器池中被替换的分析器的数量
+	analyzerPoolReplaced uint32
+	//分析的goroutine的数量
+	analyzerWorkers uint32
 	//已请求的url的计数
 	urlCount int
 	//已请求的url的详细信息
 	urlDetail string
+	//被下载器跳过和没有被路由到解析函数的url的计数
+	skippedCount uint64
+	//重新爬取跟踪器的摘要信息
+	recrawlSummary string
+	//并发控制器的摘要信息
+	concurrencySummary string
+	//速率限制器的摘要信息
+	rateLimitSummary string
 }
 
 //获取摘要信息
@@ -70,40 +87,71 @@ func NewSchedSummary(sched *myScheduler, prefix string) SchedSummary {
 		urlDetail = "\n"
 	}
 
+	recrawlSummary := "<disabled>"
+	if sched.recrawlTracker != nil {
+		recrawlSummary = sched.recrawlTracker.Summary()
+	}
+
+	concurrencySummary := "<disabled>"
+	if sched.concurrencyController != nil {
+		concurrencySummary = sched.concurrencyController.Summary()
+	}
+
+	rateLimitSummary := "<disabled>"
+	if sched.rateLimiter != nil {
+		rateLimitSummary = sched.rateLimiter.Summary()
+	}
+
 	return &mySchedSummary{
-		prefix:              prefix,
+		prefix:               prefix,
 		//当前调度器的运行状态
-		running:             sched.running,
+		running:              sched.running,
 		//池的尺寸信息
-		poolSizeArgs:        sched.poolSizeArgs,
+		poolSizeArgs:         sched.getPoolSizeArgs(),
 		//channel的长度参数
-		channelArgs:         sched.channelArgs,
+		channelArgs:          sched.channelArgs,
 		//爬取网站深度
-		crawlDepth:          sched.crawlDepth,
+		crawlDepth:           sched.crawlDepth,
 		//获取各个channel的使用状态
-		chanmanSummary:      sched.chanman.Summary(),
+		chanmanSummary:       sched.chanman.Summary(),
 		//获取缓存的使用情况
-		reqCacheSummary:     sched.reqCache.summary(),
+		reqCacheSummary:      sched.reqCache.summary(),
 		//网页下载器池的使用状况
-		dlPoolLen:           sched.dlPool.Used(),
+		dlPoolLen:            sched.dlPool.Used(),
 		//网页下载器池的长度
-		dlPoolCap:           sched.dlPool.Total(),
+		dlPoolCap:            sched.dlPool.Total(),
+		//因健康检查失败而被替换的网页下载器的数量
+		dlPoolReplaced:       sched.dlPool.Replaced(),
+		//下载的goroutine的数量
+		dlWorkers:            sched.dlWorkers.size(),
 		//分析器池的使用状况
-		analyzerPoolLen:     sched.analyzerPool.Used(),
+		analyzerPoolLen:      sched.analyzerPool.Used(),
 		//分析器池的长度
-		analyzerPoolCap:     sched.analyzerPool.Total(),
+		analyzerPoolCap:      sched.analyzerPool.Total(),
+		//因健康检查失败而被替换的分析器的数量
+		analyzerPoolReplaced: sched.analyzerPool.Replaced(),
+		//分析的goroutine的数量
+		analyzerWorkers:      sched.analyzerWorkers.size(),
 		//条目处理管道的简要信息
-		itemPipelineSummary: sched.itemPipeline.Summary(),
+		itemPipelineSummary:  sched.itemPipeline.Summary(),
 		//已请求的url数量
-		urlCount:            urlCount,
+		urlCount:             urlCount,
 		//请求的url的详情
-		urlDetail:           urlDetail,
+		urlDetail:            urlDetail,
 		//获取运行状态
-		stopSignSummary:     sched.stopSign.Summary(),
+		stopSignSummary:      sched.stopSign.Summary(),
+		//被跳过的url数量
+		skippedCount:         atomic.LoadUint64(&sched.skippedCount),
+		//重新爬取的状态
+		recrawlSummary:       recrawlSummary,
+		//并发控制的状态
+		concurrencySummary:   concurrencySummary,
+		//速率限制的状态
+		rateLimitSummary:     rateLimitSummary,
 	}
 }
 
-// 获取摘要信息。
+//获取摘要信息。
 func (ss *mySchedSummary) getSummary(detail bool) string {
 	prefix := ss.prefix
 	template := prefix + "Running: %v \n" +
@@ -112,10 +160,14 @@ func (ss *mySchedSummary) getSummary(detail bool) string {
 		prefix + "Crawl depth: %d \n" +
 		prefix + "Channels manager: %s \n" +
 		prefix + "Request cache: %s\n" +
-		prefix + "Downloader pool: %d/%d\n" +
-		prefix + "Analyzer pool: %d/%d\n" +
+		prefix + "Downloader pool: %d/%d (workers: %d, replaced: %d)\n" +
+		prefix + "Analyzer pool: %d/%d (workers: %d, replaced: %d)\n" +
 		prefix + "Item pipeline: %s\n" +
 		prefix + "Urls(%d): %s" +
+		prefix + "Skipped urls: %d\n" +
+		prefix + "Recrawl: %s\n" +
+		prefix + "Concurrency: %s\n" +
+		prefix + "Rate limit: %s\n" +
 		prefix + "Stop sign: %s\n"
 	return fmt.Sprintf(template,
 		func() bool {
@@ -126,8 +178,8 @@ func (ss *mySchedSummary) getSummary(detail bool) string {
 		ss.crawlDepth,
 		ss.chanmanSummary,
 		ss.reqCacheSummary,
-		ss.dlPoolLen, ss.dlPoolCap,
-		ss.analyzerPoolLen, ss.analyzerPoolCap,
+		ss.dlPoolLen, ss.dlPoolCap, ss.dlWorkers, ss.dlPoolReplaced,
+		ss.analyzerPoolLen, ss.analyzerPoolCap, ss.analyzerWorkers, ss.analyzerPoolReplaced,
 		ss.itemPipelineSummary,
 		ss.urlCount,
 		func() string {
@@ -137,6 +189,10 @@ func (ss *mySchedSummary) getSummary(detail bool) string {
 				return "<concealed>\n"
 			}
 		}(),
+		ss.skippedCount,
+		ss.recrawlSummary,
+		ss.concurrencySummary,
+		ss.rateLimitSummary,
 		ss.stopSignSummary)
 }
 
@@ -166,7 +222,15 @@ func (ss *mySchedSummary) Same(other SchedSummary) bool {
 		ss.dlPoolCap != otherSs.dlPoolCap ||
 		ss.analyzerPoolLen != otherSs.analyzerPoolLen ||
 		ss.analyzerPoolCap != otherSs.analyzerPoolCap ||
+		ss.dlPoolReplaced != otherSs.dlPoolReplaced ||
+		ss.dlWorkers != otherSs.dlWorkers ||
+		ss.analyzerWorkers != otherSs.analyzerWorkers ||
+		ss.analyzerPoolReplaced != otherSs.analyzerPoolReplaced ||
 		ss.urlCount != otherSs.urlCount ||
+		ss.skippedCount != otherSs.skippedCount ||
+		ss.recrawlSummary != otherSs.recrawlSummary ||
+		ss.concurrencySummary != otherSs.concurrencySummary ||
+		ss.rateLimitSummary != otherSs.rateLimitSummary ||
 		ss.stopSignSummary != otherSs.stopSignSummary ||
 		ss.reqCacheSummary != otherSs.reqCacheSummary ||
 		ss.itemPipelineSummary != otherSs.itemPipelineSummary ||
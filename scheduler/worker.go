@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"sync"
+)
+
+//工作goroutine组
+//组中的工作goroutine的数量与对应的池的容量保持一致,数据通道满了之后发送方会被阻塞,
+//这样即使待处理的数据很多,goroutine的数量也是固定的
+type workerGroup struct {
+	//名称
+	name string
+	//每个工作goroutine执行的函数,参数quit被关闭之后它应在处理完当前的数据之后返回
+	work func(quit <-chan struct{})
+	//每个工作goroutine的退出通知
+	quits []chan struct{}
+	//互斥锁
+	mutex sync.Mutex
+}
+
+//创建工作goroutine组
+func newWorkerGroup(name string, work func(quit <-chan struct{})) *workerGroup {
+	return &workerGroup{name: name, work: work}
+}
+
+//调整工作goroutine的数量,减少时被通知退出的工作goroutine会先处理完当前的数据
+func (group *workerGroup) resize(total uint32) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	current := uint32(len(group.quits))
+	if total == current {
+		return
+	}
+	logger.Infof("Resize the %s workers: %d -> %d\n", group.name, current, total)
+	for current < total {
+		quit := make(chan struct{})
+		group.quits = append(group.quits, quit)
+		go group.work(quit)
+		current++
+	}
+	for current > total {
+		current--
+		close(group.quits[current])
+		group.quits = group.quits[:current]
+	}
+}
+
+//获得工作goroutine的数量
+func (group *workerGroup) size() uint32 {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return uint32(len(group.quits))
+}
+
+//判断工作goroutine是否已被通知退出
+//在接收下一个数据之前调用,以免退出通知和数据同时就绪时继续接收数据
+func quitting(quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,182 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	analy "summerWebCrawler/analyzer"
+	"summerWebCrawler/base"
+)
+
+//WARC读取器的接口类型
+type Reader interface {
+	//读取下一个记录,没有更多记录时返回io.EOF
+	ReadRecord() (*Record, error)
+	//关闭读取器
+	Close() error
+}
+
+//WARC读取器的实现类型
+type myReader struct {
+	//带缓冲的读取器
+	reader *bufio.Reader
+	//需要在关闭时关闭的底层读取器
+	closers []io.Closer
+}
+
+//创建WARC读取器,压缩和未压缩的内容都可以被读取
+func NewReader(r io.Reader) (Reader, error) {
+	buffered := bufio.NewReader(r)
+	reader := &myReader{}
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		//逐个记录压缩的文件由多个gzip成员组成,gzip读取器默认会依次读取所有成员
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		reader.closers = append(reader.closers, gz)
+		buffered = bufio.NewReader(gz)
+	}
+	reader.reader = buffered
+	return reader, nil
+}
+
+//打开WARC文件
+func OpenReader(path string) (Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader.(*myReader).closers = append(reader.(*myReader).closers, file)
+	return reader, nil
+}
+
+func (reader *myReader) ReadRecord() (*Record, error) {
+	//跳过记录之间的空行
+	var version string
+	for {
+		line, err := reader.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		if version = strings.TrimSpace(line); version != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, errors.New(fmt.Sprintf("Invalid warc record version line '%s'!", version))
+	}
+	header := NewHeader()
+	for {
+		line, err := reader.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		index := strings.Index(line, ":")
+		if index < 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid warc header line '%s'!", line))
+		}
+		header.Set(strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:]))
+	}
+	length, err := strconv.ParseInt(header.Get(HEADER_CONTENT_LENGTH), 10, 64)
+	if err != nil || length < 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid warc content length '%s'!", header.Get(HEADER_CONTENT_LENGTH)))
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(reader.reader, block); err != nil {
+		return nil, err
+	}
+	//内容块之后是两个CRLF
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(reader.reader, trailer); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &Record{Header: header, Block: block}, nil
+}
+
+func (reader *myReader) Close() error {
+	var err error
+	for _, closer := range reader.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//根据响应记录或者渲染结果的记录重建http响应
+//响应的Request字段是一个以记录的目标url为地址的GET请求
+func ParseResponse(record *Record) (*http.Response, error) {
+	if record.Type() != RECORD_TYPE_RESPONSE && record.Type() != RECORD_TYPE_CONVERSION {
+		return nil, errors.New(fmt.Sprintf("The warc record is not a response but '%s'!", record.Type()))
+	}
+	targetUrl, err := url.Parse(record.TargetURI())
+	if err != nil {
+		return nil, err
+	}
+	httpReq := &http.Request{
+		Method:     "GET",
+		URL:        targetUrl,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       targetUrl.Host,
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), httpReq)
+}
+
+//重放归档
+//归档中的每个响应和渲染结果都会被交给分析器,然后连同分析结果一起交给handle.该过程不会访问网络.
+//渲染结果的元数据中带有META_RENDERED
+//handle返回错误时重放会被中止
+func Replay(reader Reader,
+	respParsers []analy.ParseResponse,
+	handle func(resp *base.Response, dataList []base.Data, errs []error) error) error {
+	analyzer := analy.NewAnalyzer()
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if record.Type() != RECORD_TYPE_RESPONSE && record.Type() != RECORD_TYPE_CONVERSION {
+			continue
+		}
+		httpResp, err := ParseResponse(record)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Parse response record %s failing: %s", record.ID(), err))
+		}
+		resp := base.NewResponse(httpResp, 0)
+		if record.Type() == RECORD_TYPE_CONVERSION {
+			resp.SetMeta(base.META_RENDERED, true)
+		}
+		dataList, errs := analyzer.Analyze(respParsers, *resp)
+		if err := handle(resp, dataList, errs); err != nil {
+			return err
+		}
+	}
+}
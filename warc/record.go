@@ -0,0 +1,150 @@
+package warc
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//WARC的版本
+const WARC_VERSION = "WARC/1.1"
+
+//记录的类型
+const (
+	RECORD_TYPE_WARCINFO = "warcinfo"
+	RECORD_TYPE_REQUEST  = "request"
+	RECORD_TYPE_RESPONSE = "response"
+	RECORD_TYPE_METADATA = "metadata"
+	//由其他内容转换而来的记录,如浏览器渲染之后的网页
+	RECORD_TYPE_CONVERSION = "conversion"
+)
+
+//常用的头部字段
+const (
+	HEADER_TYPE           = "WARC-Type"
+	HEADER_RECORD_ID      = "WARC-Record-ID"
+	HEADER_DATE           = "WARC-Date"
+	HEADER_TARGET_URI     = "WARC-Target-URI"
+	HEADER_CONCURRENT_TO  = "WARC-Concurrent-To"
+	HEADER_WARCINFO_ID    = "WARC-Warcinfo-ID"
+	HEADER_FILENAME       = "WARC-Filename"
+	HEADER_BLOCK_DIGEST   = "WARC-Block-Digest"
+	HEADER_PAYLOAD_DIGEST = "WARC-Payload-Digest"
+	HEADER_CONTENT_TYPE   = "Content-Type"
+	HEADER_CONTENT_LENGTH = "Content-Length"
+	HEADER_REFERS_TO      = "WARC-Refers-To"
+)
+
+//记录的头部,保持字段被设置的顺序,字段名不区分大小写
+type Header struct {
+	//字段名的列表
+	names []string
+	//小写的字段名到值的映射
+	values map[string]string
+}
+
+//创建头部
+func NewHeader() *Header {
+	return &Header{values: make(map[string]string)}
+}
+
+//设置字段
+func (header *Header) Set(name string, value string) {
+	key := strings.ToLower(name)
+	if _, exists := header.values[key]; !exists {
+		header.names = append(header.names, name)
+	}
+	header.values[key] = value
+}
+
+//获取字段,不存在时返回空字符串
+func (header *Header) Get(name string) string {
+	return header.values[strings.ToLower(name)]
+}
+
+//获得所有字段名
+func (header *Header) Names() []string {
+	return header.names
+}
+
+//WARC记录
+type Record struct {
+	//头部
+	Header *Header
+	//内容块
+	Block []byte
+}
+
+//创建记录
+//记录ID和日期会被自动设置
+func NewRecord(recordType string, contentType string, block []byte) *Record {
+	header := NewHeader()
+	header.Set(HEADER_TYPE, recordType)
+	header.Set(HEADER_RECORD_ID, NewRecordID())
+	header.Set(HEADER_DATE, time.Now().UTC().Format(time.RFC3339Nano))
+	if contentType != "" {
+		header.Set(HEADER_CONTENT_TYPE, contentType)
+	}
+	return &Record{Header: header, Block: block}
+}
+
+//获得记录类型
+func (record *Record) Type() string {
+	return record.Header.Get(HEADER_TYPE)
+}
+
+//获得记录ID
+func (record *Record) ID() string {
+	return record.Header.Get(HEADER_RECORD_ID)
+}
+
+//获得目标url
+func (record *Record) TargetURI() string {
+	return record.Header.Get(HEADER_TARGET_URI)
+}
+
+//把记录写入w,内容长度和内容块摘要会被自动设置
+func (record *Record) writeTo(w io.Writer) error {
+	record.Header.Set(HEADER_CONTENT_LENGTH, strconv.Itoa(len(record.Block)))
+	if record.Header.Get(HEADER_BLOCK_DIGEST) == "" {
+		record.Header.Set(HEADER_BLOCK_DIGEST, Digest(record.Block))
+	}
+	var buf strings.Builder
+	buf.WriteString(WARC_VERSION + "\r\n")
+	for _, name := range record.Header.names {
+		buf.WriteString(name + ": " + record.Header.Get(name) + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	if _, err := io.WriteString(w, buf.String()); err != nil {
+		return err
+	}
+	if _, err := w.Write(record.Block); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n\r\n")
+	return err
+}
+
+//生成记录ID
+func NewRecordID() string {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		panic(errors.New(fmt.Sprintf("Generate record id failing: %s", err)))
+	}
+	//版本4的UUID
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+//计算摘要,格式为"sha1:BASE32"
+func Digest(content []byte) string {
+	sum := sha1.Sum(content)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
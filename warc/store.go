@@ -0,0 +1,292 @@
+package warc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+//响应存储的接口类型
+//响应存储按url索引WARC归档中的响应记录和渲染结果的记录,该接口同时实现了网页下载器的响应存储接口,
+//可以被用来创建回放下载器
+type Store interface {
+	//查找与请求对应的响应,未找到时返回(nil, nil).只有GET请求会被查找
+	//归档中的跳转响应会被跟随,直到跳转之后的url不在归档中为止.响应的Request字段是参数httpReq,
+	//发生过跳转时是以跳转之后的url为地址的副本
+	Lookup(httpReq *http.Request) (*http.Response, error)
+	//获得被索引的url的数量
+	Len() int
+	//获取摘要信息
+	Summary() string
+}
+
+//响应记录在归档中的位置
+type recordLocation struct {
+	//文件路径
+	path string
+	//记录在文件中的偏移量,对于压缩的文件即gzip成员的偏移量
+	offset int64
+	//文件是否按记录压缩
+	compressed bool
+	//是否是渲染结果的记录
+	rendered bool
+}
+
+//响应存储的实现类型
+type myStore struct {
+	//url到响应记录位置的映射
+	index map[string]recordLocation
+	//归档文件的路径
+	paths []string
+	//命中的次数
+	hits uint64
+	//未命中的次数
+	misses uint64
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var storeSummaryTemplate = "{files:%d, urls:%d, hits:%d, misses:%d}"
+
+//查找时最多跟随的跳转次数,与http客户端的默认策略相同
+const maxStoreRedirects = 10
+
+//根据WARC归档文件创建响应存储
+//创建时会扫描所有文件并建立索引,同一个url有多个响应记录时以最后一个为准,
+//响应记录优先于渲染结果的记录.查找时才会读取记录的内容
+func NewStore(paths ...string) (Store, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("The warc file list is empty!")
+	}
+	store := &myStore{index: make(map[string]recordLocation), paths: paths}
+	for _, path := range paths {
+		if err := store.scan(path); err != nil {
+			return nil, errors.New(fmt.Sprintf("Index warc file %s failing: %s", path, err))
+		}
+	}
+	return store, nil
+}
+
+//扫描一个归档文件,把其中的响应记录加入索引
+func (store *myStore) scan(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	counter := &countingReader{r: file}
+	buffered := bufio.NewReader(counter)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	compressed := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	//当前读取位置为已从文件读出的字节数减去缓冲中尚未被读取的字节数
+	position := func() int64 {
+		return counter.n - int64(buffered.Buffered())
+	}
+	if !compressed {
+		reader := &myReader{reader: buffered}
+		for {
+			offset := position()
+			record, err := reader.ReadRecord()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			store.add(record, recordLocation{path: path, offset: offset})
+		}
+	}
+	//逐个读取gzip成员,每个成员包含一个记录
+	offset := position()
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	for {
+		gz.Multistream(false)
+		record, err := (&myReader{reader: bufio.NewReader(gz)}).ReadRecord()
+		if err != nil {
+			return err
+		}
+		store.add(record, recordLocation{path: path, offset: offset, compressed: true})
+		if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+			return err
+		}
+		offset = position()
+		if err := gz.Reset(buffered); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+//把响应记录和渲染结果的记录加入索引
+func (store *myStore) add(record *Record, location recordLocation) {
+	switch record.Type() {
+	case RECORD_TYPE_RESPONSE:
+	case RECORD_TYPE_CONVERSION:
+		location.rendered = true
+	default:
+		return
+	}
+	key, err := storeKey(record.TargetURI())
+	if err != nil {
+		return
+	}
+	if old, ok := store.index[key]; ok && !old.rendered && location.rendered {
+		return
+	}
+	store.index[key] = location
+}
+
+func (store *myStore) Lookup(httpReq *http.Request) (*http.Response, error) {
+	if httpReq == nil || httpReq.URL == nil {
+		return nil, errors.New("Invalid http request!")
+	}
+	location, ok := store.locate(httpReq)
+	if !ok {
+		return nil, nil
+	}
+	httpResp, err := location.read()
+	if err != nil {
+		return nil, err
+	}
+	finalUrl := httpReq.URL
+	for hops := 0; hops < maxStoreRedirects; hops++ {
+		nextUrl := redirectLocation(httpResp, finalUrl)
+		if nextUrl == nil {
+			break
+		}
+		key, err := storeKey(nextUrl.String())
+		if err != nil {
+			break
+		}
+		next, ok := store.index[key]
+		if !ok {
+			break
+		}
+		nextResp, err := next.read()
+		if err != nil {
+			return nil, err
+		}
+		httpResp, finalUrl = nextResp, nextUrl
+	}
+	finalReq := httpReq
+	if finalUrl != httpReq.URL {
+		finalReq = httpReq.Clone(httpReq.Context())
+		finalReq.URL = finalUrl
+		finalReq.Host = finalUrl.Host
+	}
+	httpResp.Request = finalReq
+	return httpResp, nil
+}
+
+//获得跳转响应的Location对应的url,参数reqUrl是响应对应的url.不是跳转响应时返回nil
+func redirectLocation(httpResp *http.Response, reqUrl *url.URL) *url.URL {
+	switch httpResp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+	location := httpResp.Header.Get("Location")
+	if location == "" {
+		return nil
+	}
+	locationUrl, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	return reqUrl.ResolveReference(locationUrl)
+}
+
+//读取该位置的记录并重建http响应
+func (location recordLocation) read() (*http.Response, error) {
+	file, err := os.Open(location.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(location.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var r io.Reader = file
+	if location.compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		gz.Multistream(false)
+		r = gz
+	}
+	record, err := (&myReader{reader: bufio.NewReader(r)}).ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+	//响应体已经在内存中,关闭文件之后仍然可以读取
+	return ParseResponse(record)
+}
+
+//查找请求对应的响应记录的位置并计数
+func (store *myStore) locate(httpReq *http.Request) (recordLocation, bool) {
+	var location recordLocation
+	ok := false
+	if httpReq.Method == "" || httpReq.Method == "GET" {
+		if key, err := storeKey(httpReq.URL.String()); err == nil {
+			location, ok = store.index[key]
+		}
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if ok {
+		store.hits++
+	} else {
+		store.misses++
+	}
+	return location, ok
+}
+
+func (store *myStore) Len() int {
+	return len(store.index)
+}
+
+func (store *myStore) Summary() string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return fmt.Sprintf(storeSummaryTemplate, len(store.paths), len(store.index), store.hits, store.misses)
+}
+
+//获得url在索引中的键,片段会被去掉
+func storeKey(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+//计算读取的字节数的读取器
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	n, err := counter.r.Read(p)
+	counter.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,154 @@
+package warc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"summerWebCrawler/base"
+	"testing"
+)
+
+//创建写入临时目录的WARC写入器,返回写入器和目录
+func newTestWriter(t *testing.T, compress bool) (Writer, string) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatalf("Create temp dir failing: %s", err)
+	}
+	writer, err := NewWriter(NewWriterArgs(filepath.Join(dir, "crawl"), compress, 0, nil))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Create writer failing: %s", err)
+	}
+	return writer, dir
+}
+
+//创建参数rawUrl的响应
+func newTestResponse(rawUrl string) *http.Response {
+	httpReq, _ := http.NewRequest("GET", rawUrl, nil)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Request:    httpReq,
+	}
+}
+
+//写入响应之后关闭写入器并为写入的文件创建响应存储
+func openTestStore(t *testing.T, writer Writer, dir string) Store {
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failing: %s", err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "crawl-*"))
+	store, err := NewStore(paths...)
+	if err != nil {
+		t.Fatalf("Create store failing: %s", err)
+	}
+	return store
+}
+
+//在响应存储中查找url,返回响应体,未找到时返回空字符串
+func lookupBody(t *testing.T, store Store, rawUrl string) string {
+	httpReq, _ := http.NewRequest("GET", rawUrl, nil)
+	httpResp, err := store.Lookup(httpReq)
+	if err != nil {
+		t.Fatalf("Lookup %s failing: %s", rawUrl, err)
+	}
+	if httpResp == nil {
+		return ""
+	}
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	return string(body)
+}
+
+//渲染结果被写入conversion记录,回放时仍然可以找到,但同一url的响应记录优先
+func TestStoreRenderedRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		writer, dir := newTestWriter(t, compress)
+		defer os.RemoveAll(dir)
+		writer.RecordResponse(newTestResponse("http://example.com/static"), []byte("static"))
+		writer.RecordRendered(newTestResponse("http://example.com/app"), []byte("rendered"))
+		writer.RecordResponse(newTestResponse("http://example.com/both"), []byte("network"))
+		writer.RecordRendered(newTestResponse("http://example.com/both"), []byte("rendered"))
+		store := openTestStore(t, writer, dir)
+		cases := map[string]string{
+			"http://example.com/static": "static",
+			"http://example.com/app":    "rendered",
+			"http://example.com/both":   "network",
+			"http://example.com/miss":   "",
+		}
+		for rawUrl, want := range cases {
+			if body := lookupBody(t, store, rawUrl); body != want {
+				t.Errorf("The body of %s is %q, want %q (compress=%v)", rawUrl, body, want, compress)
+			}
+		}
+		//重放时渲染结果带有渲染标记
+		paths, _ := filepath.Glob(filepath.Join(dir, "crawl-*"))
+		reader, err := OpenReader(paths[0])
+		if err != nil {
+			t.Fatalf("Open reader failing: %s", err)
+		}
+		rendered := make([]string, 0)
+		err = Replay(reader, nil, func(resp *base.Response, dataList []base.Data, errs []error) error {
+			if isRendered, _ := resp.Meta()[base.META_RENDERED].(bool); isRendered {
+				rendered = append(rendered, resp.HttpResp().Request.URL.Path)
+			}
+			return nil
+		})
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Replay failing: %s", err)
+		}
+		if strings.Join(rendered, ",") != "/app,/both" {
+			t.Errorf("The rendered responses are %v, want [/app /both] (compress=%v)", rendered, compress)
+		}
+	}
+}
+
+//创建跳转到参数location的响应
+func newRedirectResponse(rawUrl string, statusCode int, location string) *http.Response {
+	httpResp := newTestResponse(rawUrl)
+	httpResp.StatusCode = statusCode
+	httpResp.Header = http.Header{"Location": {location}}
+	return httpResp
+}
+
+//查找时跟随归档中的跳转,响应的Request字段是跳转之后的url
+func TestStoreRedirectRoundTrip(t *testing.T) {
+	writer, dir := newTestWriter(t, true)
+	defer os.RemoveAll(dir)
+	writer.RecordResponse(newRedirectResponse("http://example.com/a", http.StatusMovedPermanently, "/b"), nil)
+	writer.RecordResponse(newRedirectResponse("http://example.com/b", http.StatusFound, "http://example.com/c#top"), nil)
+	writer.RecordResponse(newTestResponse("http://example.com/c"), []byte("page c"))
+	writer.RecordResponse(newRedirectResponse("http://example.com/loop", http.StatusFound, "loop"), nil)
+	writer.RecordResponse(newRedirectResponse("http://example.com/dangling", http.StatusFound, "/missing"), nil)
+	store := openTestStore(t, writer, dir)
+	cases := []struct {
+		rawUrl     string
+		statusCode int
+		finalUrl   string
+		body       string
+	}{
+		{"http://example.com/a", http.StatusOK, "http://example.com/c#top", "page c"},
+		{"http://example.com/b", http.StatusOK, "http://example.com/c#top", "page c"},
+		{"http://example.com/c", http.StatusOK, "http://example.com/c", "page c"},
+		//跟随的次数有上限
+		{"http://example.com/loop", http.StatusFound, "http://example.com/loop", ""},
+		//跳转之后的url不在归档中时返回跳转响应本身
+		{"http://example.com/dangling", http.StatusFound, "http://example.com/dangling", ""},
+	}
+	for _, c := range cases {
+		httpReq, _ := http.NewRequest("GET", c.rawUrl, nil)
+		httpResp, err := store.Lookup(httpReq)
+		if err != nil || httpResp == nil {
+			t.Fatalf("Lookup %s returns (%v, %v)", c.rawUrl, httpResp, err)
+		}
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		if httpResp.StatusCode != c.statusCode || string(body) != c.body {
+			t.Errorf("The response of %s is (%d, %q), want (%d, %q)", c.rawUrl, httpResp.StatusCode, body, c.statusCode, c.body)
+		}
+		if finalUrl := httpResp.Request.URL.String(); finalUrl != c.finalUrl {
+			t.Errorf("The final url of %s is %s, want %s", c.rawUrl, finalUrl, c.finalUrl)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package warc
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//WARC写入器的接口类型
+type Writer interface {
+	//写入记录,除warcinfo以外的记录都会关联到当前文件的warcinfo记录
+	WriteRecord(record *Record) error
+	//写入一对请求记录和响应记录,参数body是响应体的全部内容
+	//该方法同时实现了网页下载器的响应记录器接口
+	RecordResponse(httpResp *http.Response, body []byte) error
+	//写入一对请求记录和渲染结果的记录,参数body是渲染之后的网页
+	//渲染结果不是网络上收到的响应体,因此被写入conversion类型的记录,其内容块与响应记录的格式相同
+	RecordRendered(httpResp *http.Response, body []byte) error
+	//写入元数据记录
+	//参数concurrentTo是关联的记录的ID(可以为空),参数fields中的字段会按照名称排序之后写入
+	WriteMetadata(targetURI string, concurrentTo string, fields map[string]string) error
+	//关闭写入器
+	Close() error
+	//获取摘要信息
+	Summary() string
+}
+
+//WARC写入器的参数
+type WriterArgs struct {
+	//文件名前缀(可以包含目录),文件名形如"前缀-00000.warc.gz"
+	prefix string
+	//是否按记录进行gzip压缩
+	compress bool
+	//单个文件的尺寸上限,超过上限之后会在下一组记录之前轮转到新的文件,0表示不限
+	maxFileSize int64
+	//写入warcinfo记录的附加字段
+	info map[string]string
+}
+
+var writerArgsTemplate = "{prefix:%s, compress:%v, maxFileSize:%d, info:%v}"
+
+//创建WARC写入器的参数
+func NewWriterArgs(prefix string, compress bool, maxFileSize int64, info map[string]string) WriterArgs {
+	return WriterArgs{prefix: prefix, compress: compress, maxFileSize: maxFileSize, info: info}
+}
+
+//获得文件名前缀
+func (args WriterArgs) Prefix() string {
+	return args.prefix
+}
+
+//获得是否压缩
+func (args WriterArgs) Compress() bool {
+	return args.compress
+}
+
+//获得单个文件的尺寸上限
+func (args WriterArgs) MaxFileSize() int64 {
+	return args.maxFileSize
+}
+
+func (args WriterArgs) Check() error {
+	if strings.TrimSpace(args.prefix) == "" {
+		return errors.New("The warc file prefix can not be empty!")
+	}
+	if args.maxFileSize < 0 {
+		return errors.New("The max warc file size can not be negative!")
+	}
+	return nil
+}
+
+func (args WriterArgs) String() string {
+	return fmt.Sprintf(writerArgsTemplate, args.prefix, args.compress, args.maxFileSize, args.info)
+}
+
+//WARC写入器的实现类型
+type myWriter struct {
+	//参数
+	args WriterArgs
+	//当前文件的序号
+	seq int
+	//当前文件
+	file *os.File
+	//当前文件的尺寸
+	size int64
+	//当前文件的warcinfo记录的ID
+	warcinfoId string
+	//已写入的记录的数量
+	records uint64
+	//打开过的文件的数量
+	files uint32
+	//是否已关闭
+	closed bool
+	//互斥锁
+	mutex sync.Mutex
+}
+
+var writerSummaryTemplate = "{file:%s, files:%d, records:%d, size:%d, closed:%v}"
+
+//创建WARC写入器,第一个文件会在写入第一个记录时被创建
+func NewWriter(args WriterArgs) (Writer, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	return &myWriter{args: args, seq: -1}, nil
+}
+
+//获得文件路径
+func (writer *myWriter) filePath(seq int) string {
+	ext := ".warc"
+	if writer.args.compress {
+		ext += ".gz"
+	}
+	return fmt.Sprintf("%s-%05d%s", writer.args.prefix, seq, ext)
+}
+
+//打开下一个不存在的文件并写入warcinfo记录
+func (writer *myWriter) openNext() error {
+	if writer.file != nil {
+		if err := writer.file.Close(); err != nil {
+			return err
+		}
+		writer.file = nil
+	}
+	seq := writer.seq + 1
+	for {
+		if _, err := os.Stat(writer.filePath(seq)); os.IsNotExist(err) {
+			break
+		}
+		seq++
+	}
+	file, err := os.OpenFile(writer.filePath(seq), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	writer.seq = seq
+	writer.file = file
+	writer.size = 0
+	writer.files++
+	fields := map[string]string{
+		"software": "summerWebCrawler",
+		"format":   "WARC File Format 1.1",
+	}
+	for k, v := range writer.args.info {
+		fields[k] = v
+	}
+	info := NewRecord(RECORD_TYPE_WARCINFO, "application/warc-fields", encodeFields(fields))
+	info.Header.Set(HEADER_FILENAME, filepath.Base(writer.filePath(seq)))
+	writer.warcinfoId = info.ID()
+	return writer.write(info)
+}
+
+//写入一个记录,调用方需持有锁
+func (writer *myWriter) write(record *Record) error {
+	if record.Type() != RECORD_TYPE_WARCINFO && writer.warcinfoId != "" {
+		record.Header.Set(HEADER_WARCINFO_ID, writer.warcinfoId)
+	}
+	counter := &countingWriter{w: writer.file}
+	if writer.args.compress {
+		//每个记录都是一个单独的gzip成员,这样可以按照偏移量随机访问记录
+		gz := gzip.NewWriter(counter)
+		if err := record.writeTo(gz); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	} else if err := record.writeTo(counter); err != nil {
+		return err
+	}
+	writer.size += counter.n
+	writer.records++
+	return nil
+}
+
+//写入一组记录,同一组记录总在同一个文件中
+func (writer *myWriter) writeGroup(records ...*Record) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return errors.New("The warc writer has been closed!")
+	}
+	if writer.file == nil || (writer.args.maxFileSize > 0 && writer.size >= writer.args.maxFileSize) {
+		if err := writer.openNext(); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if err := writer.write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (writer *myWriter) WriteRecord(record *Record) error {
+	if record == nil || record.Header == nil {
+		return errors.New("Invalid warc record!")
+	}
+	return writer.writeGroup(record)
+}
+
+func (writer *myWriter) RecordResponse(httpResp *http.Response, body []byte) error {
+	return writer.recordExchange(RECORD_TYPE_RESPONSE, httpResp, body)
+}
+
+func (writer *myWriter) RecordRendered(httpResp *http.Response, body []byte) error {
+	return writer.recordExchange(RECORD_TYPE_CONVERSION, httpResp, body)
+}
+
+//写入一对请求记录和参数recordType类型的响应记录
+func (writer *myWriter) recordExchange(recordType string, httpResp *http.Response, body []byte) error {
+	if httpResp == nil || httpResp.Request == nil {
+		return errors.New("Invalid http response!")
+	}
+	httpReq := httpResp.Request
+	targetUri := httpReq.URL.String()
+
+	response := NewRecord(recordType, "application/http; msgtype=response", encodeResponse(httpResp, body))
+	response.Header.Set(HEADER_TARGET_URI, targetUri)
+	response.Header.Set(HEADER_PAYLOAD_DIGEST, Digest(body))
+
+	request := NewRecord(RECORD_TYPE_REQUEST, "application/http; msgtype=request", encodeRequest(httpReq))
+	request.Header.Set(HEADER_TARGET_URI, targetUri)
+	request.Header.Set(HEADER_CONCURRENT_TO, response.ID())
+	return writer.writeGroup(response, request)
+}
+
+func (writer *myWriter) WriteMetadata(targetURI string, concurrentTo string, fields map[string]string) error {
+	metadata := NewRecord(RECORD_TYPE_METADATA, "application/warc-fields", encodeFields(fields))
+	metadata.Header.Set(HEADER_TARGET_URI, targetURI)
+	if concurrentTo != "" {
+		metadata.Header.Set(HEADER_CONCURRENT_TO, concurrentTo)
+	}
+	return writer.writeGroup(metadata)
+}
+
+func (writer *myWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+	if writer.file == nil {
+		return nil
+	}
+	err := writer.file.Sync()
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	writer.file = nil
+	return err
+}
+
+func (writer *myWriter) Summary() string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	file := ""
+	if writer.seq >= 0 {
+		file = writer.filePath(writer.seq)
+	}
+	return fmt.Sprintf(writerSummaryTemplate, file, writer.files, writer.records, writer.size, writer.closed)
+}
+
+//编码warc-fields格式的内容块
+func encodeFields(fields map[string]string) []byte {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name + ": " + fields[name] + "\r\n")
+	}
+	return []byte(buf.String())
+}
+
+//编码http请求
+func encodeRequest(httpReq *http.Request) []byte {
+	var buf strings.Builder
+	method := httpReq.Method
+	if method == "" {
+		method = "GET"
+	}
+	buf.WriteString(method + " " + httpReq.URL.RequestURI() + " HTTP/1.1\r\n")
+	host := httpReq.Host
+	if host == "" {
+		host = httpReq.URL.Host
+	}
+	buf.WriteString("Host: " + host + "\r\n")
+	httpReq.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	return []byte(buf.String())
+}
+
+//编码http响应
+//http客户端可能已经去掉了分块编码并解压了响应体,因此这里记录的是解码之后的响应体,
+//Transfer-Encoding会被去掉,Content-Length会被设置为响应体的实际长度
+func encodeResponse(httpResp *http.Response, body []byte) []byte {
+	var buf strings.Builder
+	major, minor := httpResp.ProtoMajor, httpResp.ProtoMinor
+	if major == 0 {
+		major, minor = 1, 1
+	}
+	status := httpResp.Status
+	if status == "" {
+		status = strconv.Itoa(httpResp.StatusCode) + " " + http.StatusText(httpResp.StatusCode)
+	}
+	buf.WriteString(fmt.Sprintf("HTTP/%d.%d %s\r\n", major, minor, status))
+	header := make(http.Header, len(httpResp.Header))
+	for k, v := range httpResp.Header {
+		header[k] = v
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	return append([]byte(buf.String()), body...)
+}
+
+//计算写入的字节数的写入器
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (counter *countingWriter) Write(p []byte) (int, error) {
+	n, err := counter.w.Write(p)
+	counter.n += int64(n)
+	return n, err
+}